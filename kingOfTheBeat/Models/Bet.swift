@@ -12,3 +12,11 @@ struct Bet: Codable {
     let songId: Int
     let betAmount: Int
 }
+
+struct SongBets: Codable {
+    let songId: Int
+    let locked: Bool
+    let totalStaked: Int
+    let betCount: Int
+    let bets: [Bet]
+}
@@ -12,4 +12,5 @@ struct Track: Codable {
     let trackName: String
     let artistName: String
     let albumUrl: String
+    let round: Int?
 }
@@ -10,8 +10,18 @@ class APIService {
     }
     
     func getAccessToken(completion: @escaping (Bool) -> Void) {
-        let worker = BaseUrlWorker(baseURL: APIConstants.authBaseUrl)
-        let request = Request(endpoint: SpotifyAPIEndpoint.token, method: .post)
+        guard let code = UserDefaults.standard.string(forKey: "Code"), !code.isEmpty else {
+            // Код уже обменян сервером, повторно его использовать нельзя.
+            completion(UserDefaults.standard.string(forKey: "Session") != nil)
+            return
+        }
+        
+        let worker = BaseUrlWorker(baseURL: APIConstants.serverBaseUrl)
+        let body = try? JSONSerialization.data(withJSONObject: [
+            "code": code,
+            "redirectUri": APIConstants.redirectUri
+        ])
+        let request = Request(endpoint: ServerAuthEndpoint.exchange, method: .post, body: body)
         
         worker.execute(with: request) { result in
             switch result {
@@ -21,19 +31,19 @@ class APIService {
                         do {
                             let json = try JSONSerialization.jsonObject(with: data) as? [String: Any]
                             
-                            if let accessToken = json?["access_token"] as? String,
-                               let refreshToken = json?["refresh_token"] as? String {
+                            if let accessToken = json?["accessToken"] as? String,
+                               let session = json?["sessionToken"] as? String,
+                               let userId = json?["userId"] as? Int {
                                 UserDefaults.standard.setValue(accessToken, forKey: "Authorization")
-                                UserDefaults.standard.setValue(refreshToken, forKey: "Refresh")
+                                UserDefaults.standard.setValue(session, forKey: "Session")
+                                UserDefaults.standard.setValue(userId, forKey: "UserId")
+                                UserDefaults.standard.removeObject(forKey: "Code")
                                 UserDefaults.standard.synchronize()
                                 
-                                print("Новые access и refresh токены сохранены.")
-                                if let scopeString = json?["scope"] as? String {
-                                    print("Spotify granted scopes: \(scopeString)")
-                                }
+                                print("Access токен и сессия сохранены.")
                                 completion(true)
                             } else {
-                                print("Ошибка: access_token или refresh_token отсутствуют в ответе.")
+                                print("Ошибка: accessToken или sessionToken отсутствуют в ответе.")
                                 completion(false)
                             }
                         } catch {
@@ -62,24 +72,15 @@ class APIService {
     }
     
     func renewAccessToken(completion: @escaping (Bool) -> Void) {
-        let worker = BaseUrlWorker(baseURL: APIConstants.authBaseUrl)
+        let worker = BaseUrlWorker(baseURL: APIConstants.serverBaseUrl)
         
-        guard let refreshToken = UserDefaults.standard.string(forKey: "Refresh"), !refreshToken.isEmpty else {
-            print("Ошибка: Refresh токен отсутствует.")
+        guard let session = UserDefaults.standard.string(forKey: "Session"), !session.isEmpty else {
+            print("Ошибка: Сессия отсутствует.")
             completion(false)
             return
         }
         
-        let parameters: [String: String] = [
-            "grant_type": "refresh_token",
-            "refresh_token": refreshToken,
-            "client_id": APIConstants.clientId,
-            "client_secret": APIConstants.clientSecret
-        ]
-        
-        let request = Request(endpoint: SpotifyAPIEndpoint.refreshToken,
-                              method: .post,
-                              parameters: parameters)
+        let request = Request(endpoint: ServerAuthEndpoint.token)
         
         worker.execute(with: request) { result in
             switch result {
@@ -88,15 +89,12 @@ class APIService {
                     if let data = response.data {
                         do {
                             let json = try JSONSerialization.jsonObject(with: data) as? [String: Any]
-                            if let accessToken = json?["access_token"] as? String {
+                            if let accessToken = json?["accessToken"] as? String {
                                 UserDefaults.standard.setValue(accessToken, forKey: "Authorization")
-                                if let newRefreshToken = json?["refresh_token"] as? String {
-                                    UserDefaults.standard.setValue(newRefreshToken, forKey: "Refresh")
-                                }
                                 print("Access токен обновлен.")
                                 completion(true)
                             } else {
-                                print("Ошибка: access_token отсутствует в ответе обновления.")
+                                print("Ошибка: accessToken отсутствует в ответе обновления.")
                                 completion(false)
                             }
                         } catch {
@@ -115,6 +113,10 @@ class APIService {
                     } else {
                         print("Ошибка: Неожиданный статус код \(String(describing: response.response))")
                     }
+                    if (response.response as? HTTPURLResponse)?.statusCode == 401 {
+                        // Сессия истекла: нужен повторный вход через Spotify.
+                        UserDefaults.standard.removeObject(forKey: "Session")
+                    }
                     completion(false)
                 }
             case .failure(let error):
@@ -173,8 +175,6 @@ class APIService {
 enum SpotifyAPIEndpoint: Endpoint {
     case authorize
     case profilePic
-    case refreshToken
-    case token
     
     var compositePath: String {
         switch self {
@@ -182,10 +182,6 @@ enum SpotifyAPIEndpoint: Endpoint {
             return "/authorize"
         case .profilePic:
             return "/v1/me"
-        case .refreshToken:
-            return "/api/token"
-        case .token:
-            return "/api/token"
         }
     }
     
@@ -199,15 +195,6 @@ enum SpotifyAPIEndpoint: Endpoint {
                 return [:]
             }
             return ["Authorization": "Bearer \(token)"]
-        case .refreshToken:
-            return ["Content-Type": "application/x-www-form-urlencoded"]
-        case .token:
-            let credentials = "\(APIConstants.clientId):\(APIConstants.clientSecret)"
-            guard let data = credentials.data(using: .utf8) else {
-                fatalError("Ошибка при конвертации данных в строку")
-            }
-            let base64String = data.base64EncodedString()
-            return ["Content-Type": "application/x-www-form-urlencoded", "Authorization": "Basic \(base64String)"]
         }
     }
     
@@ -217,24 +204,34 @@ enum SpotifyAPIEndpoint: Endpoint {
             return APIConstants.authParams
         case .profilePic:
             return [:]
-        case .refreshToken:
-            guard let refreshToken = UserDefaults.standard.string(forKey: "Refresh"), !refreshToken.isEmpty else {
-                print("Error: Refresh token is missing.")
+        }
+    }
+}
+
+// Обмен кода и обновление токена идут через сервер: секрет приложения хранится только там.
+enum ServerAuthEndpoint: Endpoint {
+    case exchange
+    case token
+    
+    var compositePath: String {
+        switch self {
+        case .exchange:
+            return "/auth/spotify/exchange"
+        case .token:
+            return "/auth/spotify/token"
+        }
+    }
+    
+    var headers: [String : String] {
+        switch self {
+        case .exchange:
+            return ["Content-Type": "application/json"]
+        case .token:
+            guard let session = UserDefaults.standard.string(forKey: "Session"), !session.isEmpty else {
+                print("Error: Session token is missing.")
                 return [:]
             }
-            return [
-                "grant_type": "refresh_token",
-                "refresh_token": refreshToken,
-                "client_id": APIConstants.clientId,
-                "client_secret": APIConstants.clientSecret
-            ]
-        case .token:
-            return [
-                "grant_type": "authorization_code",
-                "code": UserDefaults.standard.string(forKey: "Code") ?? "",
-                "redirect_uri": APIConstants.redirectUri
-            ]
+            return ["Authorization": "Bearer \(session)"]
         }
     }
 }
-
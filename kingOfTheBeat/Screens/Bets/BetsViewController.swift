@@ -241,12 +241,15 @@ final class BetsViewController: UIViewController {
     
     
     @objc private func handleNext() {
-        sendBetsToServer { [weak self] success in
-            guard let self = self, success else {
-                self?.showAlert("Failed to submit bets.")
-                return
+        sendBetsToServer { [weak self] error in
+            DispatchQueue.main.async {
+                guard let self = self else { return }
+                if let error = error {
+                    self.showAlert(error)
+                    return
+                }
+                self.waitForOthers()
             }
-            self.waitForOthers()
         }
     }
     
@@ -279,9 +282,13 @@ final class BetsViewController: UIViewController {
         }.resume()
     }
 
-    private func sendBetsToServer(completion: @escaping (Bool) -> Void) {
+    // Ставки принимаются только с сессией: userId сервер берёт из неё, а не из тела запроса.
+    private func sendBetsToServer(completion: @escaping (String?) -> Void) {
         let roomId = UserDefaults.standard.integer(forKey: "Room")
-        let userId = UserDefaults.standard.integer(forKey: "UserId")
+        guard let session = UserDefaults.standard.string(forKey: "Session") else {
+            completion("Please log in with Spotify to place bets.")
+            return
+        }
 
         fetchSongsForBets(for: roomId) { songs in
             let betsData = self.bets.enumerated().map { index, bet in
@@ -293,13 +300,12 @@ final class BetsViewController: UIViewController {
 
             let payload: [String: Any] = [
                 "roomId": roomId,
-                "userId": userId,
                 "bets": betsData
             ]
 
             guard let url = URL(string: "http://localhost:8080/bets/submit"),
                   let body = try? JSONSerialization.data(withJSONObject: payload) else {
-                completion(false)
+                completion("Failed to submit bets.")
                 return
             }
 
@@ -307,14 +313,28 @@ final class BetsViewController: UIViewController {
             request.httpMethod = "POST"
             request.httpBody = body
             request.setValue("application/json", forHTTPHeaderField: "Content-Type")
+            request.setValue("Bearer \(session)", forHTTPHeaderField: "Authorization")
 
-            URLSession.shared.dataTask(with: request) { _, _, error in
+            URLSession.shared.dataTask(with: request) { data, response, error in
                 if let error = error {
                     print("Error submitting bets:", error)
-                    completion(false)
+                    completion("Failed to submit bets.")
                     return
                 }
-                completion(true)
+                let status = (response as? HTTPURLResponse)?.statusCode ?? 0
+                switch status {
+                case 200:
+                    completion(nil)
+                case 401:
+                    UserDefaults.standard.removeObject(forKey: "Session")
+                    completion("Session expired. Please log in again.")
+                case 409:
+                    completion("Betting is already closed.")
+                default:
+                    let message = data.flatMap { String(data: $0, encoding: .utf8) } ?? ""
+                    print("Error submitting bets: \(status) - \(message)")
+                    completion(message.isEmpty ? "Failed to submit bets." : message)
+                }
             }.resume()
         }
     }
@@ -19,7 +19,7 @@ final class MainInteractor: MainBusinessLogic {
         presenter.routeToRoomScreen(MainModels.RouteToRoom.Response())
     }
     
-    public func joinRoom(with code: Int, userId: Int, completion: @escaping (Bool) -> Void) {
+    public func joinRoom(with code: String, userId: Int, completion: @escaping (Bool) -> Void) {
         guard let url = URL(string: "http://localhost:8080/room/add-user") else {
             completion(false)
             return
@@ -29,7 +29,7 @@ final class MainInteractor: MainBusinessLogic {
         request.httpMethod = "POST"
         request.setValue("application/json", forHTTPHeaderField: "Content-Type")
 
-        let body: [String: Any] = ["userId": userId, "roomId": code]
+        let body: [String: Any] = ["userId": userId, "code": code]
         request.httpBody = try? JSONSerialization.data(withJSONObject: body)
 
         URLSession.shared.dataTask(with: request) { data, response, error in
@@ -44,8 +44,13 @@ final class MainInteractor: MainBusinessLogic {
                 return
             }
 
-            if httpResponse.statusCode == 200 {
-                UserDefaults.standard.setValue(code, forKey: "Room")
+            if httpResponse.statusCode == 200,
+               let data = data,
+               let json = try? JSONSerialization.jsonObject(with: data) as? [String: Any],
+               let roomId = json["roomId"] as? Int {
+                // Сервер возвращает id комнаты, в которую привёл код приглашения
+                UserDefaults.standard.setValue(roomId, forKey: "Room")
+                UserDefaults.standard.setValue(code, forKey: "JoinCode")
                 completion(true)
             } else {
                 print("Не удалось присоединиться, код ответа:", httpResponse.statusCode)
@@ -3,7 +3,7 @@ import Foundation
 protocol MainBusinessLogic {
     func loadCreationScreen(_ request: MainModels.RouteToCreation.Request)
     func loadRoomScreen(_ request: MainModels.RouteToRoom.Request)
-    func joinRoom(with code: Int, userId: Int, completion: @escaping (Bool) -> Void)
+    func joinRoom(with code: String, userId: Int, completion: @escaping (Bool) -> Void)
 }
 
 protocol MainPresentationLogic {
@@ -164,13 +164,13 @@ final class MainViewController: UIViewController {
     }
     
     @objc func joinButtonTapped() {
-        guard let codeText = textField.text, let roomCode = Int(codeText) else {
+        guard let codeText = textField.text?.trimmingCharacters(in: .whitespaces), !codeText.isEmpty else {
             print("Invalid room code")
             return
         }
 
         let userId = UserDefaults.standard.integer(forKey: "UserId")
-        interactor.joinRoom(with: roomCode, userId: userId) { success in
+        interactor.joinRoom(with: codeText.uppercased(), userId: userId) { success in
             DispatchQueue.main.async {
                 if success {
                     self.interactor.loadRoomScreen(MainModels.RouteToRoom.Request())
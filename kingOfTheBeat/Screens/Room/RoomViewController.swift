@@ -116,7 +116,7 @@ final class RoomViewController: UIViewController {
         titleLabel.pinTop(to: containerView.topAnchor, 15)
         titleLabel.pinCenterX(to: containerView)
 
-        code.text = String(UserDefaults.standard.integer(forKey: "Room"))
+        code.text = UserDefaults.standard.string(forKey: "JoinCode")
         code.font = UIFont.systemFont(ofSize: 32, weight: UIFont.Weight(5))
         code.textColor = .black
         code.textAlignment = .center
@@ -16,82 +16,49 @@ final class RoomCreationInteractor: RoomCreationBusinessLogic {
         presenter.routeToMainScreen(RoomCreationModels.RouteToMain.Response())
     }
     
-    private func fetchRandomRoomKey(completion: @escaping (String?) -> Void) {
-        let url = URL(string: "http://localhost:8080/random-room-key")!
-        var request = URLRequest(url: url)
-        request.httpMethod = "GET"
+    private func registerRoom(_ name: String, completion: @escaping (Int, String) -> Void) {
+        let url = URL(string: "http://localhost:8080/rooms/create")!
+        var urlRequest = URLRequest(url: url)
+        urlRequest.httpMethod = "POST"
+        urlRequest.setValue("application/json", forHTTPHeaderField: "Content-Type")
+        // id комнаты и код приглашения выдаёт сервер
+        let body: [String: Any] = ["name": name, "ownerId": UserDefaults.standard.integer(forKey: "UserId")]
+        urlRequest.httpBody = try? JSONSerialization.data(withJSONObject: body)
 
-        let task = URLSession.shared.dataTask(with: request) { data, response, error in
+        URLSession.shared.dataTask(with: urlRequest) { data, response, error in
             if let error = error {
-                print("Error fetching random user key: \(error)")
-                completion(nil)
+                print("Error creating room: \(error)")
                 return
             }
 
-            guard let data = data else {
-                print("No data returned")
-                completion(nil)
+            guard let httpResponse = response as? HTTPURLResponse,
+                  (200...299).contains(httpResponse.statusCode) else {
+                print("Room creation failed with response: \(String(describing: response))")
                 return
             }
 
-            do {
-                let key = try JSONDecoder().decode(String.self, from: data)
-                completion(key)
-            } catch {
-                print("Failed to decode response: \(error)")
-                completion(nil)
+            guard let data = data,
+                  let json = try? JSONSerialization.jsonObject(with: data) as? [String: Any],
+                  let id = json["room_id"] as? Int,
+                  let code = json["join_code"] as? String else {
+                print("Failed to decode created room")
+                return
             }
-        }
-        task.resume()
-    }
-    
-    private func registerRoom(with id: Int, _ name: String, completion: @escaping () -> Void) {
-        let url = URL(string: "http://localhost:8080/rooms/create")!
-        var urlRequest = URLRequest(url: url)
-        urlRequest.httpMethod = "POST"
-        urlRequest.setValue("application/json", forHTTPHeaderField: "Content-Type")
-        let newRoom = Room(roomId: id, name: name, ownerId: UserDefaults.standard.integer(forKey: "UserId"), topic: nil)
-
-        do {
-            let requestBody = try JSONEncoder().encode(newRoom)
-            urlRequest.httpBody = requestBody
-
-            URLSession.shared.dataTask(with: urlRequest) { data, response, error in
-                if let error = error {
-                    print("Error creating room: \(error)")
-                    return
-                }
-
-                guard let httpResponse = response as? HTTPURLResponse,
-                      (200...299).contains(httpResponse.statusCode) else {
-                    print("Room creation failed with response: \(String(describing: response))")
-                    return
-                }
 
-                print("Room created successfully")
-                completion()
-            }.resume()
-        } catch {
-            print("Failed to encode room: \(error)")
-        }
+            print("Room created successfully")
+            completion(id, code)
+        }.resume()
     }
 
     func createRoom(_ request: RoomCreationModels.CreateRoom.Request) {
-        fetchRandomRoomKey { key in
-            guard let key = key, let id = Int(key) else {
-                print("Failed to fetch room key")
-                return
-            }
-
-            self.registerRoom(with: id, request.name) {
-                // Сохраняем комнату только после успешной регистрации
-                UserDefaults.standard.setValue(id, forKey: "Room")
-                print("Room successfully created with ID: \(id)")
-                DispatchQueue.main.async {
-                    self.presenter.routeToRoomScreen(RoomCreationModels.CreateRoom.Response(name: request.name))
-                }
+        registerRoom(request.name) { id, code in
+            // Сохраняем комнату только после успешной регистрации
+            UserDefaults.standard.setValue(id, forKey: "Room")
+            UserDefaults.standard.setValue(code, forKey: "JoinCode")
+            print("Room successfully created with ID: \(id)")
+            DispatchQueue.main.async {
+                self.presenter.routeToRoomScreen(RoomCreationModels.CreateRoom.Response(name: request.name))
             }
         }
     }
 }
-
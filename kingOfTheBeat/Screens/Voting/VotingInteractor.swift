@@ -5,6 +5,9 @@ final class VotingInteractor: VotingBusinessLogic {
     // MARK: - Presenter
     private var presenter: VotingPresentationLogic
     
+    // Раунд матча на экране: сервер закрывает только его
+    private var currentRound: Int?
+    
     // MARK: - Lifecycle
     init(presenter: VotingPresentationLogic) {
         self.presenter = presenter
@@ -17,6 +20,10 @@ final class VotingInteractor: VotingBusinessLogic {
         }
         var request = URLRequest(url: url)
         request.httpMethod = "POST"
+        // Сбрасывает отметки только владелец комнаты, остальным сервер отвечает 403.
+        if let session = UserDefaults.standard.string(forKey: "Session") {
+            request.setValue("Bearer \(session)", forHTTPHeaderField: "Authorization")
+        }
         URLSession.shared.dataTask(with: request) { _, response, error in
             guard error == nil,
                   let httpResponse = response as? HTTPURLResponse,
@@ -58,6 +65,7 @@ final class VotingInteractor: VotingBusinessLogic {
             }
             do {
                 let tracks = try JSONDecoder().decode([Track].self, from: data)
+                self.currentRound = tracks.first?.round
                 completion(tracks)
             } catch {
                 completion([])
@@ -66,7 +74,11 @@ final class VotingInteractor: VotingBusinessLogic {
     }
     
     func advanceRound(roomId: Int, completion: @escaping (Bool) -> Void) {
-        var request = URLRequest(url: URL(string: "http://localhost:8080/room/determine-winner?roomId=\(roomId)")!)
+        guard let round = currentRound else {
+            completion(false)
+            return
+        }
+        var request = URLRequest(url: URL(string: "http://localhost:8080/room/determine-winner?roomId=\(roomId)&round=\(round)")!)
         request.httpMethod = "POST"
         URLSession.shared.dataTask(with: request) { _, response, error in
             guard error == nil,
@@ -129,16 +141,27 @@ final class VotingInteractor: VotingBusinessLogic {
             completion(0)
             return
         }
+        guard let session = UserDefaults.standard.string(forKey: "Session") else {
+            completion(0)
+            return
+        }
         let url = URL(string: "http://localhost:8080/bets/for-song?roomId=\(roomId)&songId=\(songId)")!
-        URLSession.shared.dataTask(with: url) { data, _, error in
+        var request = URLRequest(url: url)
+        request.setValue("Bearer \(session)", forHTTPHeaderField: "Authorization")
+        URLSession.shared.dataTask(with: request) { data, response, error in
             guard let data = data, error == nil else {
                 completion(0)
                 return
             }
+            guard (response as? HTTPURLResponse)?.statusCode == 200 else {
+                print("Error fetching bets for song \(songId): \(String(decoding: data, as: UTF8.self))")
+                completion(0)
+                return
+            }
             do {
-                let bets = try JSONDecoder().decode([Bet].self, from: data)
-                let total = bets.reduce(0) { $0 + $1.betAmount }
-                completion(total)
+                // Чужие ставки скрыты до закрытия приёма, сумма приходит всегда.
+                let bets = try JSONDecoder().decode(SongBets.self, from: data)
+                completion(bets.totalStaked)
             } catch {
                 completion(0)
             }
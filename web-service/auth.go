@@ -0,0 +1,373 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+const sessionTTL = 30 * 24 * time.Hour
+
+var (
+	tokenCipher     cipher.AEAD
+	errNoSession    = errors.New("missing or invalid session")
+	errNotConnected = errors.New("spotify account is not connected")
+)
+
+// initTokenCipher готовит AES-GCM для шифрования токенов Spotify в базе.
+// Ключ берётся из TOKEN_ENCRYPTION_KEY (32 байта в base64); без него
+// используется случайный ключ, и сохранённые токены не переживут рестарт.
+func initTokenCipher() {
+	key, err := base64.StdEncoding.DecodeString(os.Getenv("TOKEN_ENCRYPTION_KEY"))
+	if err != nil || len(key) != 32 {
+		log.Println("TOKEN_ENCRYPTION_KEY is missing or invalid, using an ephemeral key")
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			log.Fatal("Unable to generate token encryption key:", err)
+		}
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		log.Fatal("Unable to init token cipher:", err)
+	}
+	tokenCipher, err = cipher.NewGCM(block)
+	if err != nil {
+		log.Fatal("Unable to init token cipher:", err)
+	}
+}
+
+func encryptToken(plain string) ([]byte, error) {
+	nonce := make([]byte, tokenCipher.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return tokenCipher.Seal(nonce, nonce, []byte(plain), nil), nil
+}
+
+func decryptToken(sealed []byte) (string, error) {
+	size := tokenCipher.NonceSize()
+	if len(sealed) < size {
+		return "", errors.New("encrypted token is too short")
+	}
+	plain, err := tokenCipher.Open(nil, sealed[:size], sealed[size:], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+func hashSessionToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func createSession(ctx context.Context, userID int) (string, time.Time, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", time.Time{}, err
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+	expiresAt := time.Now().Add(sessionTTL)
+
+	_, err := db.Exec(ctx, `
+		INSERT INTO session (token_hash, user_id, expires_at) VALUES ($1, $2, $3)`,
+		hashSessionToken(token), userID, expiresAt)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("insert session: %w", err)
+	}
+	return token, expiresAt, nil
+}
+
+func bearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+}
+
+// sessionUserID возвращает пользователя по сессионному токену из заголовка Authorization.
+func sessionUserID(r *http.Request) (int, error) {
+	token := bearerToken(r)
+	if token == "" {
+		return 0, errNoSession
+	}
+
+	var userID int
+	err := db.QueryRow(r.Context(), `
+		SELECT user_id FROM session WHERE token_hash = $1 AND expires_at > now()`,
+		hashSessionToken(token)).Scan(&userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, errNoSession
+	}
+	if err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
+
+// requireSession пишет 401 и возвращает false, если запрос без действующей сессии.
+func requireSession(w http.ResponseWriter, r *http.Request) (int, bool) {
+	userID, err := sessionUserID(r)
+	if errors.Is(err, errNoSession) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return 0, false
+	}
+	if err != nil {
+		log.Println("Error checking session:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return 0, false
+	}
+	return userID, true
+}
+
+func storeSpotifyToken(ctx context.Context, tx pgx.Tx, userID int, spotifyID string, token *spotifyTokenResponse) error {
+	access, err := encryptToken(token.AccessToken)
+	if err != nil {
+		return fmt.Errorf("encrypt access token: %w", err)
+	}
+	refresh, err := encryptToken(token.RefreshToken)
+	if err != nil {
+		return fmt.Errorf("encrypt refresh token: %w", err)
+	}
+	expiresAt := time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO spotify_token (user_id, spotify_id, access_token, refresh_token, scope, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (user_id) DO UPDATE
+		   SET spotify_id    = COALESCE(NULLIF(EXCLUDED.spotify_id, ''), spotify_token.spotify_id),
+		       access_token  = EXCLUDED.access_token,
+		       refresh_token = EXCLUDED.refresh_token,
+		       scope         = EXCLUDED.scope,
+		       expires_at    = EXCLUDED.expires_at`,
+		userID, spotifyID, access, refresh, token.Scope, expiresAt)
+	if err != nil {
+		return fmt.Errorf("store spotify token: %w", err)
+	}
+	return nil
+}
+
+// spotifyAccessToken возвращает действующий access token пользователя,
+// при необходимости обновляя его через refresh token на стороне сервера.
+func spotifyAccessToken(ctx context.Context, userID int) (string, time.Time, error) {
+	var (
+		spotifyID     string
+		sealedAccess  []byte
+		sealedRefresh []byte
+		scope         string
+		expiresAt     time.Time
+	)
+	err := db.QueryRow(ctx, `
+		SELECT COALESCE(spotify_id, ''), access_token, refresh_token, COALESCE(scope, ''), expires_at
+		  FROM spotify_token
+		 WHERE user_id = $1`, userID).Scan(&spotifyID, &sealedAccess, &sealedRefresh, &scope, &expiresAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", time.Time{}, errNotConnected
+	}
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("load spotify token: %w", err)
+	}
+
+	if time.Until(expiresAt) > time.Minute {
+		access, err := decryptToken(sealedAccess)
+		if err == nil {
+			return access, expiresAt, nil
+		}
+		log.Println("Error decrypting access token, refreshing:", err)
+	}
+
+	refresh, err := decryptToken(sealedRefresh)
+	if err != nil {
+		return "", time.Time{}, errNotConnected
+	}
+	token, err := spotify.refreshToken(ctx, refresh)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("refresh spotify token: %w", err)
+	}
+	if token.RefreshToken == "" {
+		token.RefreshToken = refresh
+	}
+	if token.Scope == "" {
+		token.Scope = scope
+	}
+	err = db.BeginFunc(ctx, func(tx pgx.Tx) error {
+		return storeSpotifyToken(ctx, tx, userID, spotifyID, token)
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token.AccessToken, time.Now().Add(time.Duration(token.ExpiresIn) * time.Second), nil
+}
+
+// spotifyUser возвращает пользователя, привязанного к аккаунту Spotify, и
+// заводит нового, если аккаунт входит впервые. Пользователь определяется
+// только по профилю Spotify: id из запроса клиента здесь не доверяют.
+func spotifyUser(ctx context.Context, tx pgx.Tx, profile *spotifyProfile) (int, bool, error) {
+	// Параллельный первый вход того же аккаунта ждёт здесь и находит уже созданного пользователя.
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext('spotify:' || $1))`, profile.ID); err != nil {
+		return 0, false, fmt.Errorf("lock spotify account: %w", err)
+	}
+
+	var userID int
+	err := tx.QueryRow(ctx, `SELECT user_id FROM spotify_token WHERE spotify_id = $1`, profile.ID).Scan(&userID)
+	if err == nil {
+		return userID, false, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return 0, false, fmt.Errorf("find linked user: %w", err)
+	}
+
+	profilePic := ""
+	if len(profile.Images) > 0 {
+		profilePic = profile.Images[0].URL
+	}
+	userID, err = createUser(ctx, tx, profile.DisplayName, profilePic)
+	if err != nil {
+		return 0, false, err
+	}
+	return userID, true, nil
+}
+
+func spotifyExchangeHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("Received request to /auth/spotify/exchange")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var data struct {
+		Code        string `json:"code"`
+		RedirectURI string `json:"redirectUri"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if data.Code == "" {
+		http.Error(w, "code is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	token, err := spotify.exchangeCode(ctx, data.Code, data.RedirectURI)
+	if err != nil {
+		log.Println("Error exchanging Spotify code:", err)
+		http.Error(w, "Failed to exchange authorization code", http.StatusBadGateway)
+		return
+	}
+
+	profile, err := spotify.me(ctx, token.AccessToken)
+	if err != nil {
+		log.Println("Error fetching Spotify profile:", err)
+		http.Error(w, "Failed to fetch Spotify profile", http.StatusBadGateway)
+		return
+	}
+	if profile.ID == "" {
+		http.Error(w, "Spotify profile has no id", http.StatusBadGateway)
+		return
+	}
+
+	var userID int
+	var created bool
+	err = db.BeginFunc(ctx, func(tx pgx.Tx) error {
+		var err error
+		if userID, created, err = spotifyUser(ctx, tx, profile); err != nil {
+			return err
+		}
+		return storeSpotifyToken(ctx, tx, userID, profile.ID, token)
+	})
+	if err != nil {
+		log.Println("Error linking Spotify account:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	session, sessionExpiresAt, err := createSession(ctx, userID)
+	if err != nil {
+		log.Println("Error creating session:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	if created {
+		log.Printf("Created user %d for Spotify account %s\n", userID, profile.ID)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"userId":               userID,
+		"created":              created,
+		"sessionToken":         session,
+		"sessionExpiresAt":     sessionExpiresAt,
+		"accessToken":          token.AccessToken,
+		"accessTokenExpiresAt": time.Now().Add(time.Duration(token.ExpiresIn) * time.Second),
+	})
+}
+
+func spotifyTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	accessToken, expiresAt, err := spotifyAccessToken(r.Context(), userID)
+	if errors.Is(err, errNotConnected) {
+		http.Error(w, "Spotify account is not connected", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println("Error getting Spotify access token:", err)
+		http.Error(w, "Failed to refresh Spotify token", http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"accessToken": accessToken,
+		"expiresAt":   expiresAt,
+	})
+}
+
+func logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token := bearerToken(r)
+	if token == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if _, err := db.Exec(r.Context(), `DELETE FROM session WHERE token_hash = $1`, hashSessionToken(token)); err != nil {
+		log.Println("Error deleting session:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSpotifyClientExchangeAndProfile(t *testing.T) {
+	fake := newFakeSpotify(t)
+	fake.addProfile("alice", "Alice")
+	ctx := context.Background()
+
+	token, err := spotify.exchangeCode(ctx, "code-alice", "")
+	if err != nil {
+		t.Fatalf("exchangeCode: %v", err)
+	}
+	if token.AccessToken != "access-alice" || token.RefreshToken != "refresh-alice" || token.ExpiresIn != 3600 {
+		t.Fatalf("unexpected token: %+v", token)
+	}
+
+	profile, err := spotify.me(ctx, token.AccessToken)
+	if err != nil {
+		t.Fatalf("me: %v", err)
+	}
+	if profile.ID != "alice" || profile.DisplayName != "Alice" || len(profile.Images) != 1 {
+		t.Fatalf("unexpected profile: %+v", profile)
+	}
+
+	refreshed, err := spotify.refreshToken(ctx, token.RefreshToken)
+	if err != nil {
+		t.Fatalf("refreshToken: %v", err)
+	}
+	if refreshed.AccessToken != "access-alice" {
+		t.Fatalf("unexpected refreshed token: %+v", refreshed)
+	}
+}
+
+func TestSpotifyClientErrors(t *testing.T) {
+	newFakeSpotify(t)
+	ctx := context.Background()
+
+	_, err := spotify.exchangeCode(ctx, "code-nobody", "")
+	var apiErr *spotifyError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("exchange with unknown code: got %v, want 400 spotifyError", err)
+	}
+
+	spotify.clientSecret = "wrong"
+	_, err = spotify.exchangeCode(ctx, "code-alice", "")
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("exchange with bad client secret: got %v, want 401 spotifyError", err)
+	}
+
+	if _, err := spotify.me(ctx, "access-nobody"); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("me with unknown token: got %v, want 401 spotifyError", err)
+	}
+}
+
+type exchangeResponse struct {
+	UserID       int    `json:"userId"`
+	Created      bool   `json:"created"`
+	SessionToken string `json:"sessionToken"`
+	AccessToken  string `json:"accessToken"`
+}
+
+func postExchange(t *testing.T, body string) (*httptest.ResponseRecorder, exchangeResponse) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/auth/spotify/exchange", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	spotifyExchangeHandler(rec, req)
+
+	var resp exchangeResponse
+	if rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("decode exchange response: %v", err)
+		}
+	}
+	return rec, resp
+}
+
+func TestSpotifyExchangeDerivesUserFromProfile(t *testing.T) {
+	openTestDB(t)
+	fake := newFakeSpotify(t)
+	fake.addProfile("alice", "Alice")
+	fake.addProfile("mallory", "Mallory")
+
+	// Зарегистрированный, но ещё не привязанный к Spotify пользователь.
+	victim := mustCreateUser(t, "Victim")
+
+	rec, mallory := postExchange(t, `{"userId": `+strconv.Itoa(victim)+`, "code": "code-mallory"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("exchange: status %d: %s", rec.Code, rec.Body)
+	}
+	if mallory.UserID == victim {
+		t.Fatalf("session was issued for the userId from the request body")
+	}
+	if !mallory.Created {
+		t.Fatalf("first sign-in should create a user")
+	}
+
+	_, again := postExchange(t, `{"code": "code-mallory"}`)
+	if again.UserID != mallory.UserID || again.Created {
+		t.Fatalf("second sign-in: got user %d created=%v, want %d created=false", again.UserID, again.Created, mallory.UserID)
+	}
+
+	_, alice := postExchange(t, `{"code": "code-alice"}`)
+	if alice.UserID == mallory.UserID || alice.UserID == victim {
+		t.Fatalf("alice got someone else's user %d", alice.UserID)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/auth/spotify/token", nil)
+	req.Header.Set("Authorization", "Bearer "+alice.SessionToken)
+	if got, err := sessionUserID(req); err != nil || got != alice.UserID {
+		t.Fatalf("session resolves to %d (%v), want %d", got, err, alice.UserID)
+	}
+
+	var balance int
+	if err := db.QueryRow(context.Background(), `SELECT balance FROM "user" WHERE user_id = $1`, alice.UserID).Scan(&balance); err != nil {
+		t.Fatalf("load balance: %v", err)
+	}
+	if balance != startingBalance {
+		t.Fatalf("new user balance = %d, want %d", balance, startingBalance)
+	}
+}
+
+func TestSpotifyExchangeRejectsBadCode(t *testing.T) {
+	openTestDB(t)
+	newFakeSpotify(t)
+
+	if rec, _ := postExchange(t, `{"code": "code-nobody"}`); rec.Code != http.StatusBadGateway {
+		t.Fatalf("unknown code: status %d, want 502", rec.Code)
+	}
+	if rec, _ := postExchange(t, `{}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("missing code: status %d, want 400", rec.Code)
+	}
+}
+
+func TestSpotifyIDIsUnique(t *testing.T) {
+	openTestDB(t)
+	a := mustCreateUser(t, "A")
+	b := mustCreateUser(t, "B")
+
+	insert := `INSERT INTO spotify_token (user_id, spotify_id, access_token, refresh_token, expires_at)
+	           VALUES ($1, 'shared', 'x', 'y', now())`
+	mustExec(t, insert, a)
+	if _, err := db.Exec(context.Background(), insert, b); err == nil {
+		t.Fatalf("second user linked to the same Spotify account")
+	}
+}
@@ -0,0 +1,419 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/jackc/pgx/v4"
+)
+
+type BetRequest struct {
+	SongId    int `json:"songId"`
+	BetAmount int `json:"betAmount"`
+}
+
+var (
+	errBetTooSmall     = errors.New("bet is below the room minimum")
+	errBetTooLarge     = errors.New("bet is above the room maximum")
+	errSongNotInRoom   = errors.New("song is not in play in this room")
+	errInvalidBetLimit = errors.New("invalid bet limits")
+	errBettingClosed   = errors.New("betting is closed")
+)
+
+// BetReceipt — итог размещения ставок: сколько ушло в эскроу и что осталось на счёте.
+type BetReceipt struct {
+	BetIDs  []int `json:"betIds"`
+	Staked  int   `json:"staked"`
+	Balance int   `json:"balance"`
+}
+
+func betErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errInvalidAmount), errors.Is(err, errBetTooSmall), errors.Is(err, errBetTooLarge),
+		errors.Is(err, errSongNotInRoom), errors.Is(err, errInvalidBetLimit):
+		return http.StatusBadRequest
+	case errors.Is(err, errInsufficientFunds), errors.Is(err, errBettingClosed):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// escrowAccount — счёт комнаты, на котором ставки лежат до расчёта.
+func escrowAccount(roomID int) Account { return Account{Kind: accountEscrow, ID: roomID} }
+
+// lockStakeAccount блокирует счёт, с которого платит игрок (его собственный
+// или банк команды), и возвращает его баланс. Параллельные ставки с того же
+// счёта ждут на этой блокировке, поэтому уйти в минус нельзя.
+func lockStakeAccount(ctx context.Context, tx pgx.Tx, roomID, userID int) (Account, int, error) {
+	var teamID *int
+	if err := tx.QueryRow(ctx, `
+		SELECT team_id FROM participation WHERE room_id = $1 AND user_id = $2`,
+		roomID, userID).Scan(&teamID); err != nil {
+		return Account{}, 0, fmt.Errorf("fetch participation: %w", err)
+	}
+
+	var balance int
+	if teamID != nil {
+		if err := tx.QueryRow(ctx, `SELECT balance FROM team WHERE team_id = $1 FOR UPDATE`, *teamID).Scan(&balance); err != nil {
+			return Account{}, 0, fmt.Errorf("lock team: %w", err)
+		}
+		return teamAccount(*teamID), balance, nil
+	}
+	if err := tx.QueryRow(ctx, `SELECT COALESCE(balance, 0) FROM "user" WHERE user_id = $1 FOR UPDATE`, userID).Scan(&balance); err != nil {
+		return Account{}, 0, fmt.Errorf("lock user: %w", err)
+	}
+	return userAccount(userID), balance, nil
+}
+
+// betWindowOpen сообщает, может ли игрок сейчас сделать ставки: приём ставок
+// в комнате не закрыт (см. bettingLocked) и игрок ещё не отмечен как
+// сделавший ставки.
+func betWindowOpen(ctx context.Context, q queryRower, roomID, userID int) (bool, error) {
+	locked, err := bettingLocked(ctx, q, roomID)
+	if err != nil || locked {
+		return false, err
+	}
+	var submitted bool
+	err = q.QueryRow(ctx, `
+		SELECT COALESCE(bets_submitted, FALSE) FROM participation WHERE room_id = $1 AND user_id = $2`,
+		roomID, userID).Scan(&submitted)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	return !submitted, err
+}
+
+// placeBets в одной транзакции проверяет ставки по балансу и лимитам
+// комнаты, переводит их сумму в эскроу комнаты и отмечает готовность игрока
+// (и всей его команды). Окно ставок проверяется после блокировки счёта,
+// поэтому повторная отправка видит уже отмеченную готовность.
+func placeBets(ctx context.Context, tx pgx.Tx, roomID, userID int, bets []BetRequest) (*BetReceipt, error) {
+	from, balance, err := lockStakeAccount(ctx, tx, roomID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	open, err := betWindowOpen(ctx, tx, roomID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("check betting window: %w", err)
+	}
+	if !open {
+		return nil, errBettingClosed
+	}
+
+	var minBet int
+	var maxBet *int
+	if err := tx.QueryRow(ctx, `SELECT min_bet, max_bet FROM room WHERE room_id = $1`, roomID).Scan(&minBet, &maxBet); err != nil {
+		return nil, fmt.Errorf("fetch bet limits: %w", err)
+	}
+
+	receipt := &BetReceipt{BetIDs: []int{}}
+	for _, bet := range bets {
+		switch {
+		case bet.BetAmount <= 0:
+			return nil, errInvalidAmount
+		case bet.BetAmount < minBet:
+			return nil, errBetTooSmall
+		case maxBet != nil && bet.BetAmount > *maxBet:
+			return nil, errBetTooLarge
+		}
+		receipt.Staked += bet.BetAmount
+	}
+	if receipt.Staked > balance {
+		return nil, errInsufficientFunds
+	}
+
+	for _, bet := range bets {
+		var betID int
+		err := tx.QueryRow(ctx, `
+			INSERT INTO bets (room_id, user_id, song_id, bet_amount, team_id, songs_in_play)
+			SELECT $1, $2, s.song_id, $4, (SELECT team_id FROM participation WHERE room_id = $1 AND user_id = $2),
+			       (SELECT COUNT(*) FROM song o
+			         WHERE o.room_id = $1
+			           AND NOT EXISTS (SELECT 1 FROM song_progress sp WHERE sp.song_id = o.song_id AND sp.eliminated))
+			  FROM song s
+			 WHERE s.song_id = $3 AND s.room_id = $1
+			   AND NOT EXISTS (SELECT 1 FROM song_progress sp WHERE sp.song_id = s.song_id AND sp.eliminated)
+			RETURNING bet_id`,
+			roomID, userID, bet.SongId, bet.BetAmount).Scan(&betID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, errSongNotInRoom
+		}
+		if err != nil {
+			return nil, fmt.Errorf("insert bet: %w", err)
+		}
+		if _, err := transfer(ctx, tx, from, escrowAccount(roomID), bet.BetAmount, reasonBetStake, betRef(betID)); err != nil {
+			return nil, fmt.Errorf("stake bet %d: %w", betID, err)
+		}
+		receipt.BetIDs = append(receipt.BetIDs, betID)
+	}
+	receipt.Balance = balance - receipt.Staked
+
+	if _, err := tx.Exec(ctx, `
+		UPDATE participation SET bets_submitted = true
+		 WHERE room_id = $2
+		   AND (user_id = $1 OR team_id = (SELECT team_id FROM participation WHERE room_id = $2 AND user_id = $1))`,
+		userID, roomID); err != nil {
+		return nil, fmt.Errorf("mark bets submitted: %w", err)
+	}
+	if err := lockBets(ctx, tx, roomID); err != nil {
+		return nil, fmt.Errorf("lock bets: %w", err)
+	}
+	return receipt, nil
+}
+
+// setBetLimitsHandler задаёт минимальную и максимальную ставку комнаты (0 — без потолка).
+func setBetLimitsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	callerID, ok := requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	var data struct {
+		RoomId int `json:"roomId"`
+		MinBet int `json:"minBet"`
+		MaxBet int `json:"maxBet"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if data.MinBet < 1 || data.MaxBet < 0 || (data.MaxBet > 0 && data.MaxBet < data.MinBet) {
+		http.Error(w, errInvalidBetLimit.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tag, err := db.Exec(r.Context(), `
+		UPDATE room SET min_bet = $2, max_bet = NULLIF($3, 0)
+		 WHERE room_id = $1 AND owner_id = $4 AND status = 'open'`,
+		data.RoomId, data.MinBet, data.MaxBet, callerID)
+	if err != nil {
+		log.Println("Error updating bet limits:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		http.Error(w, "Room not found, already started, or you are not the owner", http.StatusForbidden)
+		return
+	}
+
+	publishRoomEvent(data.RoomId, "bet_limits", map[string]int{"minBet": data.MinBet, "maxBet": data.MaxBet})
+	w.WriteHeader(http.StatusOK)
+}
+
+// settleLostBets после финала переводит на house ставки, оставшиеся в эскроу без выплаты.
+func settleLostBets(ctx context.Context, tx pgx.Tx, roomID int) error {
+	rows, err := tx.Query(ctx, `
+		UPDATE bets SET settled_at = now()
+		 WHERE room_id = $1 AND settled_at IS NULL AND bet_amount > 0
+		RETURNING bet_id, bet_amount`, roomID)
+	if err != nil {
+		return fmt.Errorf("settle bets: %w", err)
+	}
+	lost := map[int]int{}
+	for rows.Next() {
+		var betID, amount int
+		if err := rows.Scan(&betID, &amount); err != nil {
+			rows.Close()
+			return fmt.Errorf("scan bet: %w", err)
+		}
+		lost[betID] = amount
+	}
+	rows.Close()
+
+	for betID, amount := range lost {
+		if _, err := transfer(ctx, tx, escrowAccount(roomID), houseAccount, amount, reasonBetLoss, betRef(betID)); err != nil {
+			return fmt.Errorf("settle bet %d: %w", betID, err)
+		}
+	}
+	return nil
+}
+
+// closeBets аннулирует выбранные ставки и выводит их сумму из эскроу: при
+// возврате — владельцам ставок, при потере — на house. Ставки остаются в
+// истории рассчитанными с отметкой voided_at. where — условие на bets b,
+// аргументы начинаются с $1. Возвращает закрытую сумму.
+func closeBets(ctx context.Context, tx pgx.Tx, refund bool, where string, args ...interface{}) (int, error) {
+	payout := "0"
+	if refund {
+		payout = "b.bet_amount"
+	}
+	rows, err := tx.Query(ctx, `
+		UPDATE bets b SET settled_at = now(), voided_at = now(), payout = `+payout+`
+		 WHERE b.settled_at IS NULL AND (`+where+`)
+		RETURNING b.bet_id, b.room_id, b.user_id, b.team_id, COALESCE(b.bet_amount, 0)`, args...)
+	if err != nil {
+		return 0, fmt.Errorf("void bets: %w", err)
+	}
+	type closed struct {
+		betID, roomID, amount int
+		owner                 Account
+	}
+	var bets []closed
+	for rows.Next() {
+		var c closed
+		var userID int
+		var teamID *int
+		if err := rows.Scan(&c.betID, &c.roomID, &userID, &teamID, &c.amount); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("scan bet: %w", err)
+		}
+		c.owner = userAccount(userID)
+		if teamID != nil {
+			c.owner = teamAccount(*teamID)
+		}
+		bets = append(bets, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	total := 0
+	for _, c := range bets {
+		if c.amount <= 0 {
+			continue
+		}
+		to, reason := houseAccount, reasonBetForfeit
+		if refund {
+			to, reason = c.owner, reasonBetRefund
+		}
+		if _, err := transfer(ctx, tx, escrowAccount(c.roomID), to, c.amount, reason, betRef(c.betID)); err != nil {
+			return total, fmt.Errorf("close bet %d: %w", c.betID, err)
+		}
+		total += c.amount
+	}
+	return total, nil
+}
+
+func refundBets(ctx context.Context, tx pgx.Tx, where string, args ...interface{}) (int, error) {
+	return closeBets(ctx, tx, true, where, args...)
+}
+
+// refundRoomStakes возвращает владельцам всё, что лежит в эскроу комнаты:
+// нерассчитанные ставки на песни и ставки на ещё не рассчитанных рынках,
+// а банки невыплаченных команд делит между участниками. Вызывается до того,
+// как комната истекает, отменяется или удаляется.
+func refundRoomStakes(ctx context.Context, tx pgx.Tx, roomID int) error {
+	if _, err := refundBets(ctx, tx, `b.room_id = $1`, roomID); err != nil {
+		return fmt.Errorf("refund bets: %w", err)
+	}
+
+	rows, err := tx.Query(ctx, `
+		SELECT market_id FROM bet_market WHERE room_id = $1 AND status IN ('open', 'closed')`, roomID)
+	if err != nil {
+		return fmt.Errorf("query open markets: %w", err)
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return fmt.Errorf("scan market: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	for _, id := range ids {
+		if err := voidMarket(ctx, tx, id); err != nil {
+			return err
+		}
+	}
+	if err := payOutTeamsTx(ctx, tx, roomID); err != nil {
+		return fmt.Errorf("pay out teams: %w", err)
+	}
+	return nil
+}
+
+// SongBets — ставки на песню, какими их видит вызывающий.
+type SongBets struct {
+	SongId      int   `json:"songId"`
+	Locked      bool  `json:"locked"`
+	TotalStaked int   `json:"totalStaked"`
+	BetCount    int   `json:"betCount"`
+	Bets        []Bet `json:"bets"`
+}
+
+// betsLockCondition закрывает приём ставок в комнате r: все игроки сделали
+// ставки, началось голосование, прошёл первый матч или комната уже не в лобби
+// и не в игре.
+const betsLockCondition = `r.status NOT IN ('open', 'in_game') OR COALESCE(r.current_round, 0) > 0
+		    OR EXISTS (SELECT 1 FROM votes v WHERE v.room_id = r.room_id)
+		    OR COALESCE((SELECT BOOL_AND(p.bets_submitted) FROM participation p
+		                  WHERE p.room_id = r.room_id AND p.role = 'player' AND p.left_at IS NULL), FALSE)`
+
+// bettingLocked сообщает, закрыт ли приём ставок в комнате (см.
+// betsLockCondition и lockBets). placeBets не принимает ставки после
+// закрытия, поэтому чужие ставки, открытые по этому признаку, уже нельзя
+// повторить.
+func bettingLocked(ctx context.Context, q queryRower, roomID int) (bool, error) {
+	var locked bool
+	err := q.QueryRow(ctx, `
+		SELECT r.bets_locked_at IS NOT NULL OR `+betsLockCondition+`
+		  FROM room r WHERE r.room_id = $1`, roomID).Scan(&locked)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	return locked, err
+}
+
+// lockBets запоминает в room.bets_locked_at момент закрытия приёма ставок.
+// Закрытие необратимо: bets_submitted сбрасывается между раундами
+// голосования, но приём ставок и чужие ставки от этого уже не открываются.
+func lockBets(ctx context.Context, q queryRower, roomID int) error {
+	var locked bool
+	err := q.QueryRow(ctx, `
+		UPDATE room r SET bets_locked_at = now()
+		 WHERE r.room_id = $1 AND r.bets_locked_at IS NULL AND (`+betsLockCondition+`)
+		RETURNING TRUE`, roomID).Scan(&locked)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	return err
+}
+
+// songBetsView отдаёт свои ставки и ставки своей команды всегда, чужие —
+// только после закрытия приёма ставок; сумма и число ставок видны всем.
+func songBetsView(ctx context.Context, roomID, songID, callerID int) (*SongBets, error) {
+	view := &SongBets{SongId: songID, Bets: []Bet{}}
+	locked, err := bettingLocked(ctx, db, roomID)
+	if err != nil {
+		return nil, fmt.Errorf("check betting lock: %w", err)
+	}
+	view.Locked = locked
+
+	rows, err := db.Query(ctx, `
+		SELECT b.user_id, b.bet_amount,
+		       b.user_id = $3 OR (b.team_id IS NOT NULL AND b.team_id =
+		           (SELECT team_id FROM participation WHERE room_id = $1 AND user_id = $3))
+		  FROM bets b
+		 WHERE b.room_id = $1 AND b.song_id = $2 AND b.voided_at IS NULL
+	  ORDER BY b.bet_id`, roomID, songID, callerID)
+	if err != nil {
+		return nil, fmt.Errorf("query bets: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		bet := Bet{SongId: songID}
+		var own bool
+		if err := rows.Scan(&bet.UserId, &bet.BetAmount, &own); err != nil {
+			return nil, fmt.Errorf("scan bet: %w", err)
+		}
+		view.TotalStaked += bet.BetAmount
+		view.BetCount++
+		if own || locked {
+			view.Bets = append(view.Bets, bet)
+		}
+	}
+	return view, rows.Err()
+}
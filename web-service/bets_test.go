@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+func placeTestBets(t *testing.T, roomID, userID int, bets ...BetRequest) error {
+	t.Helper()
+	return db.BeginFunc(context.Background(), func(tx pgx.Tx) error {
+		_, err := placeBets(context.Background(), tx, roomID, userID, bets)
+		return err
+	})
+}
+
+func escrowBalance(t *testing.T, roomID int) int {
+	t.Helper()
+	var escrow int
+	if err := db.QueryRow(context.Background(), `
+		SELECT COALESCE(SUM(amount), 0) FROM ledger_entry WHERE account_kind = 'escrow' AND account_id = $1`,
+		roomID).Scan(&escrow); err != nil {
+		t.Fatalf("load escrow: %v", err)
+	}
+	return escrow
+}
+
+func TestSubmitBetsRequiresSession(t *testing.T) {
+	rec := httptest.NewRecorder()
+	body := `{"roomId": 1, "userId": 1, "bets": [{"songId": 1, "betAmount": 10}]}`
+	submitBetsHandler(rec, httptest.NewRequest(http.MethodPost, "/bets/submit", strings.NewReader(body)))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("status %d, want 401", rec.Code)
+	}
+}
+
+func TestBettingWindow(t *testing.T) {
+	openTestDB(t)
+	owner := mustCreateUser(t, "Owner")
+	guest := mustCreateUser(t, "Guest")
+	late := mustCreateUser(t, "Late")
+	roomID := mustCreateRoom(t, owner, "Room")
+	for _, userID := range []int{owner, guest, late} {
+		mustExec(t, `INSERT INTO participation (user_id, room_id, role) VALUES ($1, $2, 'player')`, userID, roomID)
+	}
+	song := mustAddSong(t, roomID, guest, "A", "X", "")
+	bet := BetRequest{SongId: song, BetAmount: 10}
+
+	if err := placeTestBets(t, roomID, owner, bet); err != nil {
+		t.Fatalf("first bets: %v", err)
+	}
+	// Повторная отправка после отметки о ставках отклоняется.
+	if err := placeTestBets(t, roomID, owner, bet); !errors.Is(err, errBettingClosed) {
+		t.Fatalf("second bets: %v, want errBettingClosed", err)
+	}
+
+	mustExec(t, `INSERT INTO votes (user_id, song_id, room_id, round) VALUES ($1, $2, $3, 0)`, owner, song, roomID)
+	if err := placeTestBets(t, roomID, guest, bet); !errors.Is(err, errBettingClosed) {
+		t.Fatalf("bets after voting started: %v, want errBettingClosed", err)
+	}
+	mustExec(t, `DELETE FROM votes WHERE room_id = $1`, roomID)
+
+	// После первого матча ставки закрыты, даже если голосов уже нет.
+	mustExec(t, `UPDATE room SET current_round = 1 WHERE room_id = $1`, roomID)
+	if err := placeTestBets(t, roomID, guest, bet); !errors.Is(err, errBettingClosed) {
+		t.Fatalf("bets after round 1: %v, want errBettingClosed", err)
+	}
+	mustExec(t, `UPDATE room SET current_round = 0 WHERE room_id = $1`, roomID)
+
+	for _, status := range []string{roomFinished, roomExpired, roomCancelled} {
+		mustExec(t, `UPDATE room SET status = $2 WHERE room_id = $1`, roomID, status)
+		if err := placeTestBets(t, roomID, late, bet); !errors.Is(err, errBettingClosed) {
+			t.Fatalf("bets in %s room: %v, want errBettingClosed", status, err)
+		}
+		// Закрытие, по которому открываются чужие ставки, совпадает с закрытием приёма.
+		if locked, err := bettingLocked(context.Background(), db, roomID); err != nil || !locked {
+			t.Fatalf("bettingLocked in %s room = %v, %v", status, locked, err)
+		}
+	}
+	if got := userBalance(t, late); got != startingBalance {
+		t.Fatalf("rejected bets moved coins: balance %d", got)
+	}
+}
+
+func TestBetsLockSurvivesReset(t *testing.T) {
+	openTestDB(t)
+	owner := mustCreateUser(t, "Owner")
+	guest := mustCreateUser(t, "Guest")
+	roomID := mustCreateRoom(t, owner, "Room")
+	for _, userID := range []int{owner, guest} {
+		mustExec(t, `INSERT INTO participation (user_id, room_id, role) VALUES ($1, $2, 'player')`, userID, roomID)
+	}
+	song := mustAddSong(t, roomID, guest, "A", "X", "")
+	bet := BetRequest{SongId: song, BetAmount: 10}
+	for _, userID := range []int{owner, guest} {
+		if err := placeTestBets(t, roomID, userID, bet); err != nil {
+			t.Fatalf("bets of %d: %v", userID, err)
+		}
+	}
+
+	// Сброс отметок перед раундом голосования не открывает приём ставок заново.
+	mustExec(t, `UPDATE participation SET bets_submitted = false WHERE room_id = $1`, roomID)
+	if locked, err := bettingLocked(context.Background(), db, roomID); err != nil || !locked {
+		t.Fatalf("bettingLocked after reset = %v, %v", locked, err)
+	}
+	if err := placeTestBets(t, roomID, guest, bet); !errors.Is(err, errBettingClosed) {
+		t.Fatalf("bets after reset: %v, want errBettingClosed", err)
+	}
+}
+
+func TestResetBetsSubmittedRequiresSession(t *testing.T) {
+	rec := httptest.NewRecorder()
+	resetBetsSubmittedHandler(rec, httptest.NewRequest(http.MethodPost, "/room/reset-bets-submitted?roomId=1", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("status %d, want 401", rec.Code)
+	}
+}
+
+func TestJanitorRefundsEscrow(t *testing.T) {
+	openTestDB(t)
+	owner := mustCreateUser(t, "Owner")
+	guest := mustCreateUser(t, "Guest")
+	roomID := mustCreateRoom(t, owner, "Room")
+	for _, userID := range []int{owner, guest} {
+		mustExec(t, `INSERT INTO participation (user_id, room_id, role) VALUES ($1, $2, 'player')`, userID, roomID)
+	}
+	song := mustAddSong(t, roomID, guest, "A", "X", "")
+	if err := placeTestBets(t, roomID, owner, BetRequest{SongId: song, BetAmount: 40}); err != nil {
+		t.Fatalf("place bets: %v", err)
+	}
+	if got := escrowBalance(t, roomID); got != 40 {
+		t.Fatalf("escrow %d, want 40", got)
+	}
+
+	ctx := context.Background()
+	mustExec(t, `UPDATE room SET last_activity_at = now() - interval '1 hour' WHERE room_id = $1`, roomID)
+	expired, err := expireIdleRooms(ctx, roomOpen, time.Minute)
+	if err != nil {
+		t.Fatalf("expire rooms: %v", err)
+	}
+	if len(expired) != 1 || expired[0] != roomID {
+		t.Fatalf("expired %v, want [%d]", expired, roomID)
+	}
+	if got := escrowBalance(t, roomID); got != 0 {
+		t.Fatalf("escrow after expiry %d, want 0", got)
+	}
+	if got := userBalance(t, owner); got != startingBalance {
+		t.Fatalf("owner balance %d, want %d", got, startingBalance)
+	}
+
+	if err := deleteRoom(ctx, roomID); err != nil {
+		t.Fatalf("delete room: %v", err)
+	}
+	audit, err := auditLedger(ctx)
+	if err != nil {
+		t.Fatalf("audit ledger: %v", err)
+	}
+	if !audit.OK() {
+		t.Fatalf("ledger audit failed: %+v", audit)
+	}
+}
+
+func TestRefundedBetsKeepHistory(t *testing.T) {
+	openTestDB(t)
+	owner := mustCreateUser(t, "Owner")
+	guest := mustCreateUser(t, "Guest")
+	roomID := mustCreateRoom(t, owner, "Room")
+	for _, userID := range []int{owner, guest} {
+		mustExec(t, `INSERT INTO participation (user_id, room_id, role) VALUES ($1, $2, 'player')`, userID, roomID)
+	}
+	song := mustAddSong(t, roomID, guest, "Bohemian Rhapsody", "Queen", "")
+	if err := placeTestBets(t, roomID, owner, BetRequest{SongId: song, BetAmount: 30}); err != nil {
+		t.Fatalf("place bets: %v", err)
+	}
+
+	ctx := context.Background()
+	err := db.BeginFunc(ctx, func(tx pgx.Tx) error {
+		_, err := refundBets(ctx, tx, `b.room_id = $1`, roomID)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("refund bets: %v", err)
+	}
+
+	var payout *int
+	var voided bool
+	if err := db.QueryRow(ctx, `
+		SELECT payout, voided_at IS NOT NULL FROM bets WHERE room_id = $1 AND user_id = $2`,
+		roomID, owner).Scan(&payout, &voided); err != nil {
+		t.Fatalf("refunded bet is gone: %v", err)
+	}
+	if payout == nil || *payout != 30 || !voided {
+		t.Fatalf("refunded bet: payout %v, voided %v", payout, voided)
+	}
+
+	// Контекст ставки в истории переживает удаление комнаты.
+	if err := deleteRoom(ctx, roomID); err != nil {
+		t.Fatalf("delete room: %v", err)
+	}
+	page, err := balanceHistory(ctx, owner, 0, 10)
+	if err != nil {
+		t.Fatalf("balance history: %v", err)
+	}
+	reasons := map[string]bool{}
+	for _, e := range page.Entries {
+		if e.Reason != reasonBetStake && e.Reason != reasonBetRefund {
+			continue
+		}
+		reasons[e.Reason] = true
+		if e.RoomID == nil || *e.RoomID != roomID || e.SongID == nil || *e.SongID != song ||
+			e.TrackName == nil || *e.TrackName != "Bohemian Rhapsody" {
+			t.Fatalf("%s entry lost its context: %+v", e.Reason, e)
+		}
+	}
+	if !reasons[reasonBetStake] || !reasons[reasonBetRefund] {
+		t.Fatalf("history is missing bet entries: %+v", page.Entries)
+	}
+}
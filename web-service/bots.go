@@ -0,0 +1,416 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// Локальный каталог песен по темам, из которого боты берут треки.
+//
+//go:embed bot_catalog.json
+var botCatalogJSON []byte
+
+type botSong struct {
+	TrackName  string `json:"trackName"`
+	ArtistName string `json:"artistName"`
+}
+
+var botCatalog map[string][]botSong
+
+const (
+	botStartBalance = 1000
+	// Доля баланса, которую бот ставит за игру, в процентах.
+	botBetPercent = 10
+	botMaxBets    = 2
+	songsPerRoom  = 12
+)
+
+var botTickInterval = time.Duration(getEnvInt("BOT_TICK_MS", 2000)) * time.Millisecond
+
+func initBots() {
+	if err := json.Unmarshal(botCatalogJSON, &botCatalog); err != nil {
+		log.Fatal("Invalid bot catalog:", err)
+	}
+}
+
+func catalogForTopic(topic string) []botSong {
+	if songs, ok := botCatalog[topic]; ok {
+		return songs
+	}
+	var all []botSong
+	for _, songs := range botCatalog {
+		all = append(all, songs...)
+	}
+	return all
+}
+
+// createBots создаёт count ботов и сажает их в комнату игроками.
+func createBots(ctx context.Context, roomID, count int) ([]User, error) {
+	var players int
+	if err := db.QueryRow(ctx, `
+		SELECT COUNT(*) FROM participation WHERE room_id = $1 AND role = 'player'`,
+		roomID).Scan(&players); err != nil {
+		return nil, fmt.Errorf("count players: %w", err)
+	}
+	if free := maxPlayers - players; count > free {
+		count = free
+	}
+
+	var bots []User
+	for i := 0; i < count; i++ {
+		key := generateRandomKey()
+		for keyExists(key) {
+			key = generateRandomKey()
+		}
+		userID, _ := strconv.Atoi(key)
+
+		bot := User{UserId: userID, Name: fmt.Sprintf("Bot %d", players+i+1), Role: rolePlayer}
+		if err := insertBot(ctx, roomID, bot); err != nil {
+			return bots, err
+		}
+		bots = append(bots, bot)
+	}
+	return bots, nil
+}
+
+func insertBot(ctx context.Context, roomID int, bot User) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO "user" (user_id, balance, name, profile_pic, is_bot) VALUES ($1, 0, $2, '', TRUE)`,
+		bot.UserId, bot.Name); err != nil {
+		return fmt.Errorf("insert bot user: %w", err)
+	}
+	if err := grantStartingBalance(ctx, tx, bot.UserId, botStartBalance); err != nil {
+		return fmt.Errorf("grant bot balance: %w", err)
+	}
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO participation (user_id, room_id, role) VALUES ($1, $2, $3)`,
+		bot.UserId, roomID, rolePlayer); err != nil {
+		return fmt.Errorf("add bot to room: %w", err)
+	}
+	return tx.Commit(ctx)
+}
+
+func addBotsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var data struct {
+		UserId int `json:"userId"`
+		RoomId int `json:"roomId"`
+		Count  int `json:"count"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if data.Count <= 0 {
+		data.Count = 1
+	}
+
+	var ownerId int
+	err := db.QueryRow(r.Context(), "SELECT owner_id FROM room WHERE room_id = $1", data.RoomId).Scan(&ownerId)
+	if err != nil {
+		log.Println("Error fetching room:", err)
+		http.Error(w, "Room not found", http.StatusNotFound)
+		return
+	}
+	if data.UserId != ownerId {
+		http.Error(w, "Only the owner can add bots", http.StatusForbidden)
+		return
+	}
+
+	bots, err := createBots(r.Context(), data.RoomId, data.Count)
+	if err != nil {
+		log.Println("Error adding bots:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if len(bots) == 0 {
+		http.Error(w, "Room is full (max 6 participants)", http.StatusBadRequest)
+		return
+	}
+
+	publishRoomEvent(data.RoomId, "bots_added", bots)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(bots)
+}
+
+type roomBot struct {
+	userID        int
+	submitted     bool
+	betsSubmitted bool
+	balance       int
+}
+
+// botsAct делает за ботов комнаты следующий шаг игры: отправляет песни,
+// делает ставки или голосует в текущем матче. Повторный вызов безопасен.
+func botsAct(ctx context.Context, roomID int) error {
+	var topic string
+	var players int
+	var allSubmitted bool
+	err := db.QueryRow(ctx, `
+		SELECT COALESCE(r.topic, ''),
+		       (SELECT COUNT(*) FROM participation WHERE room_id = r.room_id AND role = 'player' AND left_at IS NULL),
+		       COALESCE((SELECT BOOL_AND(is_submitted) FROM participation WHERE room_id = r.room_id AND role = 'player' AND left_at IS NULL), FALSE)
+		  FROM room r
+		 WHERE r.room_id = $1`, roomID).Scan(&topic, &players, &allSubmitted)
+	if err != nil {
+		return fmt.Errorf("fetch room: %w", err)
+	}
+	if topic == "" || players == 0 {
+		return nil
+	}
+
+	rows, err := db.Query(ctx, `
+		SELECT p.user_id, p.is_submitted, p.bets_submitted, u.balance
+		  FROM participation p
+		  JOIN "user" u ON u.user_id = p.user_id
+		 WHERE p.room_id = $1 AND p.role = 'player' AND u.is_bot`, roomID)
+	if err != nil {
+		return fmt.Errorf("fetch bots: %w", err)
+	}
+	var bots []roomBot
+	for rows.Next() {
+		var b roomBot
+		if err := rows.Scan(&b.userID, &b.submitted, &b.betsSubmitted, &b.balance); err != nil {
+			rows.Close()
+			return fmt.Errorf("scan bot: %w", err)
+		}
+		bots = append(bots, b)
+	}
+	rows.Close()
+
+	for _, b := range bots {
+		if !b.submitted {
+			if err := botSubmitSongs(ctx, roomID, b, topic, players); err != nil {
+				return err
+			}
+		}
+	}
+	if !allSubmitted {
+		return nil
+	}
+
+	for _, b := range bots {
+		placed, err := botPlaceBets(ctx, roomID, b)
+		if err != nil {
+			return err
+		}
+		if placed {
+			// Ставки делаются до первого матча, голосовать начинаем со следующего шага.
+			continue
+		}
+		if err := botVote(ctx, roomID, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func botSubmitSongs(ctx context.Context, roomID int, b roomBot, topic string, players int) error {
+	perPlayer := songsPerRoom / players
+	if perPlayer < 1 {
+		perPlayer = 1
+	}
+	catalog := catalogForTopic(topic)
+
+	batch := &pgx.Batch{}
+	for i, idx := range rand.Perm(len(catalog)) {
+		if i == perPlayer {
+			break
+		}
+		batch.Queue(`
+			INSERT INTO song (room_id, user_id, track_name, artist_name, album_url, team_id)
+			VALUES ($1, $2, $3, $4, '', (SELECT team_id FROM participation WHERE room_id = $1 AND user_id = $2))`,
+			roomID, b.userID, catalog[idx].TrackName, catalog[idx].ArtistName)
+	}
+	batch.Queue(`UPDATE participation SET is_submitted = true WHERE room_id = $1 AND user_id = $2`, roomID, b.userID)
+
+	if err := db.SendBatch(ctx, batch).Close(); err != nil {
+		return fmt.Errorf("bot %d submit songs: %w", b.userID, err)
+	}
+	selectMatchupWhenSubmitted(ctx, roomID)
+	return nil
+}
+
+// botPlaceBets ставит botBetPercent баланса на случайные чужие песни. Возвращает
+// true, если ставки были сделаны на этом шаге.
+func botPlaceBets(ctx context.Context, roomID int, b roomBot) (bool, error) {
+	open, err := betWindowOpen(ctx, db, roomID, b.userID)
+	if err != nil {
+		return false, fmt.Errorf("check bot betting window: %w", err)
+	}
+	if !open {
+		return false, nil
+	}
+
+	rows, err := db.Query(ctx, `
+		SELECT song_id FROM song
+		 WHERE room_id = $1 AND user_id <> $2
+		 ORDER BY random()
+		 LIMIT $3`, roomID, b.userID, botMaxBets)
+	if err != nil {
+		return false, fmt.Errorf("select songs for bets: %w", err)
+	}
+	var songIDs []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return false, fmt.Errorf("scan song_id: %w", err)
+		}
+		songIDs = append(songIDs, id)
+	}
+	rows.Close()
+
+	stake := b.balance * botBetPercent / 100
+	if len(songIDs) == 0 || stake < len(songIDs) {
+		return false, nil
+	}
+
+	// Ставка подгоняется под лимиты комнаты.
+	var minBet int
+	var maxBet *int
+	if err := db.QueryRow(ctx, `SELECT min_bet, max_bet FROM room WHERE room_id = $1`, roomID).Scan(&minBet, &maxBet); err != nil {
+		return false, fmt.Errorf("fetch bet limits: %w", err)
+	}
+	amount := stake / len(songIDs)
+	if maxBet != nil && amount > *maxBet {
+		amount = *maxBet
+	}
+	if amount < minBet {
+		return false, nil
+	}
+
+	bets := make([]BetRequest, 0, len(songIDs))
+	for _, id := range songIDs {
+		bets = append(bets, BetRequest{SongId: id, BetAmount: amount})
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(context.Background())
+
+	if _, err := placeBets(ctx, tx, roomID, b.userID, bets); err != nil {
+		return false, fmt.Errorf("bot %d place bets: %w", b.userID, err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return false, fmt.Errorf("bot %d commit bets: %w", b.userID, err)
+	}
+	publishOdds(ctx, roomID)
+	return true, nil
+}
+
+// botVote голосует за случайную песню текущего матча, если бот ещё не голосовал в этом раунде.
+func botVote(ctx context.Context, roomID int, b roomBot) error {
+	round, song1, song2, err := currentMatchup(ctx, roomID)
+	if errors.Is(err, errNoMatchup) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var voted bool
+	if err := db.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM votes WHERE room_id = $1 AND user_id = $2 AND round = $3)`,
+		roomID, b.userID, round).Scan(&voted); err != nil {
+		return fmt.Errorf("check bot vote: %w", err)
+	}
+	if voted {
+		return nil
+	}
+
+	songID := song1
+	if rand.Intn(2) == 0 {
+		songID = song2
+	}
+
+	batch := &pgx.Batch{}
+	batch.Queue(`INSERT INTO votes (user_id, song_id, room_id, round) VALUES ($1, $2, $3, $4)`,
+		b.userID, songID, roomID, round)
+	batch.Queue(`UPDATE participation SET bets_submitted = true WHERE room_id = $1 AND user_id = $2`, roomID, b.userID)
+	if err := db.SendBatch(ctx, batch).Close(); err != nil {
+		return fmt.Errorf("bot %d vote: %w", b.userID, err)
+	}
+	if err := lockBets(ctx, db, roomID); err != nil {
+		return fmt.Errorf("lock bets: %w", err)
+	}
+	closeMarkets(ctx, roomID, round)
+	return nil
+}
+
+func botsActHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+	roomID, err := strconv.Atoi(r.URL.Query().Get("roomId"))
+	if err != nil {
+		http.Error(w, "roomId is required", http.StatusBadRequest)
+		return
+	}
+	if err := botsAct(r.Context(), roomID); err != nil {
+		log.Println("Error running bots:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// runBotDriver периодически делает ход за ботов во всех комнатах, где они есть.
+func runBotDriver() {
+	ticker := time.NewTicker(botTickInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), botTickInterval)
+		rows, err := db.Query(ctx, `
+			SELECT DISTINCT p.room_id
+			  FROM participation p
+			  JOIN "user" u ON u.user_id = p.user_id
+			  JOIN room r ON r.room_id = p.room_id
+			 WHERE u.is_bot AND r.status IN ('open', 'in_game')`)
+		if err != nil {
+			log.Println("Error fetching rooms with bots:", err)
+			cancel()
+			continue
+		}
+		var roomIDs []int
+		for rows.Next() {
+			var id int
+			if err := rows.Scan(&id); err == nil {
+				roomIDs = append(roomIDs, id)
+			}
+		}
+		rows.Close()
+
+		for _, id := range roomIDs {
+			if err := botsAct(ctx, id); err != nil {
+				log.Printf("Bots in room %d: %v", id, err)
+			}
+		}
+		cancel()
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+)
+
+// TestBotOnlyGames прогоняет несколько игр только из ботов от создания
+// комнаты до финала на одноразовой базе — сквозная проверка игрового цикла.
+func TestBotOnlyGames(t *testing.T) {
+	openTestDB(t)
+	initBots()
+	for game := 1; game <= 3; game++ {
+		simulateBotGame(t, game)
+	}
+
+	audit, err := auditLedger(context.Background())
+	if err != nil {
+		t.Fatalf("audit ledger: %v", err)
+	}
+	if !audit.OK() {
+		t.Fatalf("ledger audit failed: %+v", audit)
+	}
+}
+
+func simulateBotGame(t *testing.T, game int) {
+	t.Helper()
+	ctx := context.Background()
+	roomID := mustCreateRoom(t, 0, "Simulation")
+
+	players := 3 + rand.Intn(maxPlayers-2)
+	bots, err := createBots(ctx, roomID, players)
+	if err != nil {
+		t.Fatalf("game %d: create bots: %v", game, err)
+	}
+	mustExec(t, `UPDATE room SET owner_id = $2, topic = 'Party' WHERE room_id = $1`, roomID, bots[0].UserId)
+
+	// Отправка песен, ставки и первый голос.
+	for i := 0; i < 3; i++ {
+		if err := botsAct(ctx, roomID); err != nil {
+			t.Fatalf("game %d: bots act: %v", game, err)
+		}
+	}
+
+	var songs int
+	if err := db.QueryRow(ctx, `SELECT COUNT(*) FROM song WHERE room_id = $1`, roomID).Scan(&songs); err != nil {
+		t.Fatalf("count songs: %v", err)
+	}
+	if want := players * (songsPerRoom / players); songs != want {
+		t.Fatalf("game %d: expected %d songs, got %d", game, want, songs)
+	}
+
+	for round := 0; ; round++ {
+		if round >= songs {
+			t.Fatalf("game %d: tournament did not finish after %d rounds", game, round)
+		}
+		finished, err := isRoomFinished(ctx, roomID)
+		if err != nil {
+			t.Fatalf("check finished: %v", err)
+		}
+		if finished {
+			break
+		}
+		if err := botsAct(ctx, roomID); err != nil {
+			t.Fatalf("game %d: bots act: %v", game, err)
+		}
+		if _, err := settleMatch(ctx, roomID, round); err != nil {
+			t.Fatalf("game %d: settle round %d: %v", game, round, err)
+		}
+	}
+
+	standings, err := finalStandings(ctx, roomID)
+	if err != nil {
+		t.Fatalf("final standings: %v", err)
+	}
+	if len(standings) != songs {
+		t.Fatalf("game %d: expected %d songs in standings, got %d", game, songs, len(standings))
+	}
+
+	var negative int
+	if err := db.QueryRow(ctx, `
+		SELECT COUNT(*) FROM "user" u
+		  JOIN participation p ON p.user_id = u.user_id
+		 WHERE p.room_id = $1 AND u.balance < 0`, roomID).Scan(&negative); err != nil {
+		t.Fatalf("check balances: %v", err)
+	}
+	if negative > 0 {
+		t.Fatalf("game %d: %d bots ended with a negative balance", game, negative)
+	}
+
+	var escrow int
+	if err := db.QueryRow(ctx, `
+		SELECT COALESCE(SUM(amount), 0) FROM ledger_entry WHERE account_kind = 'escrow' AND account_id = $1`,
+		roomID).Scan(&escrow); err != nil {
+		t.Fatalf("check escrow: %v", err)
+	}
+	if escrow != 0 {
+		t.Fatalf("game %d: %d coins left in escrow after the final", game, escrow)
+	}
+}
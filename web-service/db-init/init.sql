@@ -76,4 +76,471 @@ ALTER TABLE room
 
 ALTER TABLE song
   ADD COLUMN eliminated BOOLEAN DEFAULT FALSE,
-  ADD COLUMN eliminated_round INTEGER DEFAULT 0;
\ No newline at end of file
+  ADD COLUMN eliminated_round INTEGER DEFAULT 0;
+
+-- Токены Spotify (access и refresh хранятся зашифрованными)
+CREATE TABLE IF NOT EXISTS "spotify_token" (
+    user_id INTEGER PRIMARY KEY,
+    spotify_id VARCHAR,
+    access_token BYTEA NOT NULL,
+    refresh_token BYTEA NOT NULL,
+    scope VARCHAR,
+    expires_at TIMESTAMPTZ NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES "user"(user_id) ON DELETE CASCADE
+);
+
+-- Сессии приложения (хранится только хеш токена)
+CREATE TABLE IF NOT EXISTS "session" (
+    token_hash VARCHAR PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES "user"(user_id) ON DELETE CASCADE
+);
+
+ALTER TABLE song ADD COLUMN spotify_track_id VARCHAR;
+
+-- Плейлисты с результатами, выгруженные в Spotify
+CREATE TABLE IF NOT EXISTS "room_playlist" (
+    room_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    playlist_id VARCHAR NOT NULL,
+    playlist_url VARCHAR,
+    updated_at TIMESTAMPTZ DEFAULT now(),
+    PRIMARY KEY (room_id, user_id),
+    FOREIGN KEY (room_id) REFERENCES "room"(room_id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES "user"(user_id) ON DELETE CASCADE
+);
+
+-- Несколько музыкальных сервисов: id трека хранится вместе с провайдером,
+-- плейлист выгружается в каждый сервис отдельно.
+ALTER TABLE song
+  ADD COLUMN provider VARCHAR DEFAULT 'spotify',
+  ADD COLUMN provider_track_id VARCHAR,
+  ADD COLUMN isrc VARCHAR;
+
+UPDATE song SET provider_track_id = spotify_track_id WHERE spotify_track_id IS NOT NULL;
+ALTER TABLE song DROP COLUMN spotify_track_id;
+
+ALTER TABLE room_playlist ADD COLUMN provider VARCHAR NOT NULL DEFAULT 'spotify';
+ALTER TABLE room_playlist
+  DROP CONSTRAINT room_playlist_pkey,
+  ADD PRIMARY KEY (room_id, user_id, provider);
+
+-- Сопоставление песен с каталогами других музыкальных сервисов
+CREATE TABLE IF NOT EXISTS "track_match" (
+    song_id INTEGER NOT NULL,
+    provider VARCHAR NOT NULL,
+    track_id VARCHAR NOT NULL,
+    PRIMARY KEY (song_id, provider),
+    FOREIGN KEY (song_id) REFERENCES "song"(song_id) ON DELETE CASCADE
+);
+
+-- Расписание синхронного воспроизведения песен текущего матча
+CREATE TABLE IF NOT EXISTS "playback_schedule" (
+    room_id INTEGER NOT NULL,
+    round INTEGER NOT NULL,
+    position INTEGER NOT NULL,
+    song_id INTEGER NOT NULL,
+    start_at TIMESTAMPTZ NOT NULL,
+    offset_ms INTEGER NOT NULL DEFAULT 0,
+    duration_ms INTEGER NOT NULL,
+    speaker_user_id INTEGER,
+    PRIMARY KEY (room_id, round, position),
+    FOREIGN KEY (room_id) REFERENCES "room"(room_id) ON DELETE CASCADE,
+    FOREIGN KEY (song_id) REFERENCES "song"(song_id) ON DELETE CASCADE
+);
+
+-- Роль участника: игрок или зритель
+ALTER TABLE participation ADD COLUMN role VARCHAR NOT NULL DEFAULT 'player';
+
+-- Вес голосов зрителей (0 — зрители не голосуют)
+ALTER TABLE room ADD COLUMN audience_vote_weight DOUBLE PRECISION NOT NULL DEFAULT 0;
+
+-- Команды внутри комнаты с общим банком для ставок
+CREATE TABLE IF NOT EXISTS "team" (
+    team_id SERIAL PRIMARY KEY,
+    room_id INTEGER NOT NULL,
+    name VARCHAR NOT NULL,
+    balance INTEGER NOT NULL DEFAULT 0,
+    paid_out BOOLEAN NOT NULL DEFAULT FALSE,
+    FOREIGN KEY (room_id) REFERENCES "room"(room_id) ON DELETE CASCADE
+);
+
+ALTER TABLE participation
+  ADD COLUMN team_id INTEGER REFERENCES "team"(team_id) ON DELETE SET NULL,
+  ADD COLUMN team_contribution INTEGER NOT NULL DEFAULT 0;
+
+ALTER TABLE song ADD COLUMN team_id INTEGER REFERENCES "team"(team_id) ON DELETE SET NULL;
+ALTER TABLE bets ADD COLUMN team_id INTEGER REFERENCES "team"(team_id) ON DELETE SET NULL;
+
+-- Голоса привязаны к раунду, чтобы матчи считались независимо
+ALTER TABLE votes ADD COLUMN round INTEGER NOT NULL DEFAULT 0;
+
+-- Боты, которых владелец добавляет в комнату вместо недостающих игроков
+ALTER TABLE "user" ADD COLUMN is_bot BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Время входа в комнату: по нему выбирается новый владелец
+ALTER TABLE participation ADD COLUMN joined_at TIMESTAMPTZ NOT NULL DEFAULT now();
+
+-- Пользователи, которым владелец запретил входить в комнату
+CREATE TABLE IF NOT EXISTS "room_ban" (
+    room_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    banned_by INTEGER NOT NULL,
+    reason VARCHAR,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (room_id, user_id),
+    FOREIGN KEY (room_id) REFERENCES "room"(room_id) ON DELETE CASCADE
+);
+
+-- Политика выхода посреди игры: песни ушедшего, его ставки и бронь места
+ALTER TABLE room
+  ADD COLUMN leave_songs VARCHAR NOT NULL DEFAULT 'keep',
+  ADD COLUMN leave_bets VARCHAR NOT NULL DEFAULT 'refund',
+  ADD COLUMN seat_reserve_minutes INTEGER NOT NULL DEFAULT 5;
+
+ALTER TABLE participation
+  ADD COLUMN left_at TIMESTAMPTZ,
+  ADD COLUMN reserved_until TIMESTAMPTZ;
+
+-- Жизненный цикл комнаты: open, in_game, finished, expired
+ALTER TABLE room
+  ADD COLUMN status VARCHAR NOT NULL DEFAULT 'open',
+  ADD COLUMN created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  ADD COLUMN last_activity_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  ADD COLUMN finished_at TIMESTAMPTZ,
+  ADD COLUMN expired_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS room_status_idx ON room (status, last_activity_at);
+
+-- Итоги завершённых игр, которые janitor перенёс из room
+CREATE TABLE IF NOT EXISTS "room_archive" (
+    archive_id SERIAL PRIMARY KEY,
+    room_id INTEGER NOT NULL,
+    owner_id INTEGER,
+    name VARCHAR,
+    topic VARCHAR,
+    players INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ,
+    finished_at TIMESTAMPTZ,
+    archived_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    results JSONB
+);
+
+-- Коды для входа в комнату, отдельные от её номера
+CREATE TABLE IF NOT EXISTS "room_join_code" (
+    code VARCHAR PRIMARY KEY,
+    room_id INTEGER NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    revoked_at TIMESTAMPTZ,
+    FOREIGN KEY (room_id) REFERENCES "room"(room_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS room_join_code_room_idx ON room_join_code (room_id);
+
+-- Публичные комнаты и лобби быстрой игры
+ALTER TABLE room
+  ADD COLUMN is_public BOOLEAN NOT NULL DEFAULT FALSE,
+  ADD COLUMN language VARCHAR,
+  ADD COLUMN quick_play BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE INDEX IF NOT EXISTS room_public_idx ON room (status, created_at) WHERE is_public;
+
+-- Подписанные приглашения в комнату с ограничением срока и числа использований
+CREATE TABLE IF NOT EXISTS "room_invite" (
+    invite_id VARCHAR PRIMARY KEY,
+    room_id INTEGER NOT NULL,
+    created_by INTEGER NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    max_uses INTEGER NOT NULL CHECK (max_uses > 0),
+    uses INTEGER NOT NULL DEFAULT 0,
+    revoked_at TIMESTAMPTZ,
+    FOREIGN KEY (room_id) REFERENCES "room"(room_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS room_invite_room_idx ON room_invite (room_id);
+
+-- Запланированные турниры: время старта, окончание регистрации и минимум игроков
+ALTER TABLE room
+  ADD COLUMN scheduled_start_at TIMESTAMPTZ,
+  ADD COLUMN registration_closes_at TIMESTAMPTZ,
+  ADD COLUMN min_players INTEGER,
+  ADD COLUMN cancelled_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS room_scheduled_idx ON room (scheduled_start_at) WHERE status = 'open';
+
+-- Журнал движения монет: операция и её проводки, сумма проводок операции равна нулю
+CREATE TABLE IF NOT EXISTS "ledger_txn" (
+    txn_id BIGSERIAL PRIMARY KEY,
+    reason VARCHAR NOT NULL,
+    ref VARCHAR,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS "ledger_entry" (
+    entry_id BIGSERIAL PRIMARY KEY,
+    txn_id BIGINT NOT NULL REFERENCES "ledger_txn"(txn_id),
+    account_kind VARCHAR NOT NULL,
+    account_id INTEGER NOT NULL DEFAULT 0,
+    amount INTEGER NOT NULL CHECK (amount <> 0)
+);
+
+CREATE INDEX IF NOT EXISTS ledger_entry_account_idx ON ledger_entry (account_kind, account_id);
+CREATE INDEX IF NOT EXISTS ledger_entry_txn_idx ON ledger_entry (txn_id);
+CREATE INDEX IF NOT EXISTS ledger_txn_ref_idx ON ledger_txn (ref);
+
+-- Записи журнала не меняются и не удаляются
+CREATE OR REPLACE FUNCTION ledger_immutable() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'ledger is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER ledger_txn_immutable BEFORE UPDATE OR DELETE ON ledger_txn
+    FOR EACH ROW EXECUTE FUNCTION ledger_immutable();
+CREATE TRIGGER ledger_entry_immutable BEFORE UPDATE OR DELETE ON ledger_entry
+    FOR EACH ROW EXECUTE FUNCTION ledger_immutable();
+
+-- Начальные остатки существующих счетов переносятся в журнал
+WITH opening AS (
+    SELECT 'user' AS kind, user_id AS id, balance FROM "user" WHERE COALESCE(balance, 0) <> 0
+    UNION ALL
+    SELECT 'team', team_id, balance FROM team WHERE balance <> 0
+), txns AS (
+    INSERT INTO ledger_txn (reason, ref)
+    SELECT 'opening_balance', kind || ':' || id FROM opening
+    RETURNING txn_id, ref
+)
+INSERT INTO ledger_entry (txn_id, account_kind, account_id, amount)
+SELECT t.txn_id, o.kind, o.id, o.balance
+  FROM txns t JOIN opening o ON t.ref = o.kind || ':' || o.id
+ UNION ALL
+SELECT t.txn_id, 'house', 0, -o.balance
+  FROM txns t JOIN opening o ON t.ref = o.kind || ':' || o.id;
+
+-- Лимиты ставок комнаты и отметка о расчёте ставки
+ALTER TABLE room
+  ADD COLUMN min_bet INTEGER NOT NULL DEFAULT 1,
+  ADD COLUMN max_bet INTEGER;
+
+ALTER TABLE bets ADD COLUMN settled_at TIMESTAMPTZ;
+ALTER TABLE bets ADD CONSTRAINT bets_amount_positive CHECK (bet_amount > 0) NOT VALID;
+
+-- Итоги матчей: матч рассчитывается один раз, повторный запрос получает сохранённый итог
+CREATE TABLE IF NOT EXISTS "match_settlement" (
+    room_id INTEGER NOT NULL,
+    round INTEGER NOT NULL,
+    winner_song_id INTEGER NOT NULL,
+    loser_song_id INTEGER NOT NULL,
+    outcome JSONB NOT NULL,
+    settled_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (room_id, round),
+    FOREIGN KEY (room_id) REFERENCES "room"(room_id) ON DELETE CASCADE
+);
+
+-- Модель выплат комнаты и итоговая выплата по ставке
+ALTER TABLE room ADD COLUMN payout_model VARCHAR NOT NULL DEFAULT 'fixed';
+ALTER TABLE bets ADD COLUMN payout INTEGER;
+
+-- Рынки ставок: исход матча, разница голосов (больше/меньше линии) и победитель турнира
+CREATE TABLE IF NOT EXISTS "bet_market" (
+    market_id SERIAL PRIMARY KEY,
+    room_id INTEGER NOT NULL,
+    kind VARCHAR NOT NULL CHECK (kind IN ('matchup', 'margin', 'champion')),
+    round INTEGER,
+    song1_id INTEGER,
+    song2_id INTEGER,
+    line NUMERIC,
+    status VARCHAR NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'closed', 'settled', 'void')),
+    result VARCHAR,
+    opened_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    closed_at TIMESTAMPTZ,
+    settled_at TIMESTAMPTZ,
+    FOREIGN KEY (room_id) REFERENCES "room"(room_id) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS bet_market_room_kind_round
+    ON bet_market (room_id, kind, COALESCE(round, -1)) WHERE status <> 'void';
+
+CREATE TABLE IF NOT EXISTS "market_bet" (
+    bet_id SERIAL PRIMARY KEY,
+    market_id INTEGER NOT NULL,
+    room_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    team_id INTEGER,
+    selection VARCHAR NOT NULL,
+    stake INTEGER NOT NULL CHECK (stake > 0),
+    payout INTEGER,
+    placed_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    settled_at TIMESTAMPTZ,
+    FOREIGN KEY (market_id) REFERENCES "bet_market"(market_id) ON DELETE CASCADE,
+    FOREIGN KEY (room_id) REFERENCES "room"(room_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS market_bet_market ON market_bet (market_id);
+
+-- Поиск истории трека для расчёта коэффициентов
+CREATE INDEX IF NOT EXISTS song_provider_track ON song (provider, provider_track_id);
+
+-- Поощрения: ежедневная выдача, пополнение при разорении и серия игровых дней
+CREATE TABLE IF NOT EXISTS "user_reward" (
+    user_id INTEGER PRIMARY KEY,
+    last_daily_claim DATE,
+    last_topup_at TIMESTAMPTZ,
+    streak_days INTEGER NOT NULL DEFAULT 0,
+    last_played_on DATE,
+    FOREIGN KEY (user_id) REFERENCES "user"(user_id) ON DELETE CASCADE
+);
+
+-- Друзья: односторонняя подписка, по ней фильтруются таблицы лидеров
+CREATE TABLE IF NOT EXISTS "friendship" (
+    user_id INTEGER NOT NULL,
+    friend_id INTEGER NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (user_id, friend_id),
+    CHECK (user_id <> friend_id),
+    FOREIGN KEY (user_id) REFERENCES "user"(user_id) ON DELETE CASCADE,
+    FOREIGN KEY (friend_id) REFERENCES "user"(user_id) ON DELETE CASCADE
+);
+
+-- Дневные агрегаты игрока для таблиц лидеров: пополняются по мере игры,
+-- окна неделя/месяц суммируют дни, а не журнал и ставки
+CREATE TABLE IF NOT EXISTS "player_stats_daily" (
+    user_id INTEGER NOT NULL,
+    day DATE NOT NULL,
+    net_coins INTEGER NOT NULL DEFAULT 0,
+    games_played INTEGER NOT NULL DEFAULT 0,
+    games_won INTEGER NOT NULL DEFAULT 0,
+    finals INTEGER NOT NULL DEFAULT 0,
+    staked INTEGER NOT NULL DEFAULT 0,
+    returned INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (user_id, day),
+    FOREIGN KEY (user_id) REFERENCES "user"(user_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS player_stats_daily_day ON player_stats_daily (day);
+
+-- Движение монет по счёту игрока копится в дневном агрегате
+CREATE OR REPLACE FUNCTION player_stats_net_coins() RETURNS trigger AS $$
+BEGIN
+    INSERT INTO player_stats_daily (user_id, day, net_coins)
+    VALUES (NEW.account_id, CURRENT_DATE, NEW.amount)
+    ON CONFLICT (user_id, day) DO UPDATE
+       SET net_coins = player_stats_daily.net_coins + EXCLUDED.net_coins;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER ledger_entry_player_stats AFTER INSERT ON ledger_entry
+    FOR EACH ROW WHEN (NEW.account_kind = 'user')
+    EXECUTE FUNCTION player_stats_net_coins();
+
+-- Перенос истории: движение монет по дням журнала и победы в завершённых турнирах
+INSERT INTO player_stats_daily (user_id, day, net_coins)
+SELECT e.account_id, t.created_at::date, SUM(e.amount)
+  FROM ledger_entry e
+  JOIN ledger_txn t ON t.txn_id = e.txn_id
+  JOIN "user" u ON u.user_id = e.account_id
+ WHERE e.account_kind = 'user'
+ GROUP BY e.account_id, t.created_at::date
+ON CONFLICT (user_id, day) DO UPDATE SET net_coins = EXCLUDED.net_coins;
+
+INSERT INTO player_stats_daily (user_id, day, games_won)
+SELECT s.user_id, COALESCE(r.finished_at, now())::date, COUNT(*)
+  FROM room r
+  JOIN song s ON s.room_id = r.room_id
+ WHERE r.status = 'finished'
+   AND NOT EXISTS (SELECT 1 FROM song_progress sp WHERE sp.song_id = s.song_id AND sp.eliminated)
+ GROUP BY s.user_id, COALESCE(r.finished_at, now())::date
+ON CONFLICT (user_id, day) DO UPDATE SET games_won = EXCLUDED.games_won;
+
+-- Аккаунт Spotify привязан не более чем к одному пользователю: вход определяет
+-- пользователя по spotify_id. Из дублей остаётся самая свежая привязка.
+DELETE FROM spotify_token t
+ USING spotify_token o
+ WHERE t.spotify_id = o.spotify_id AND t.user_id <> o.user_id
+   AND (t.expires_at, t.user_id) < (o.expires_at, o.user_id);
+ALTER TABLE spotify_token ADD CONSTRAINT spotify_token_spotify_id_key UNIQUE (spotify_id);
+
+-- Ставка оценивается по числу песен в игре на момент ставки, а не на момент расчёта
+ALTER TABLE bets ADD COLUMN songs_in_play INTEGER;
+
+-- Возвращённые и сгоревшие ставки остаются в истории с отметкой voided_at, а не удаляются
+ALTER TABLE bets ADD COLUMN voided_at TIMESTAMPTZ;
+
+-- Контекст операции журнала хранится в самой записи: ставки, рынки и песни
+-- удаляются вместе с комнатой, а история счёта должна их помнить
+ALTER TABLE ledger_txn
+  ADD COLUMN room_id INTEGER,
+  ADD COLUMN song_id INTEGER,
+  ADD COLUMN track_name VARCHAR;
+
+ALTER TABLE ledger_txn DISABLE TRIGGER ledger_txn_immutable;
+UPDATE ledger_txn t
+   SET room_id = c.room_id, song_id = c.song_id, track_name = c.track_name
+  FROM (SELECT t.txn_id, COALESCE(b.room_id, mb.room_id, tm.room_id) AS room_id, b.song_id, s.track_name
+          FROM ledger_txn t
+     LEFT JOIN bets b ON split_part(t.ref, ':', 1) = 'bet' AND b.bet_id = split_part(t.ref, ':', 2)::int
+     LEFT JOIN song s ON s.song_id = b.song_id
+     LEFT JOIN market_bet mb ON split_part(t.ref, ':', 1) = 'market_bet' AND mb.bet_id = split_part(t.ref, ':', 2)::int
+     LEFT JOIN team tm ON split_part(t.ref, ':', 1) = 'team' AND tm.team_id = split_part(t.ref, ':', 2)::int) c
+ WHERE c.txn_id = t.txn_id AND c.room_id IS NOT NULL;
+ALTER TABLE ledger_txn ENABLE TRIGGER ledger_txn_immutable;
+
+-- Итоги игрока за всё время: таблица all не суммирует все дни, а читает одну
+-- строку. Строку ведёт триггер на player_stats_daily — по разнице с прошлым
+-- значением дня, поэтому писать в дневные агрегаты можно как прежде
+CREATE TABLE IF NOT EXISTS "player_stats_total" (
+    user_id INTEGER PRIMARY KEY,
+    net_coins INTEGER NOT NULL DEFAULT 0,
+    games_played INTEGER NOT NULL DEFAULT 0,
+    games_won INTEGER NOT NULL DEFAULT 0,
+    finals INTEGER NOT NULL DEFAULT 0,
+    staked INTEGER NOT NULL DEFAULT 0,
+    returned INTEGER NOT NULL DEFAULT 0,
+    FOREIGN KEY (user_id) REFERENCES "user"(user_id) ON DELETE CASCADE
+);
+
+CREATE OR REPLACE FUNCTION player_stats_total_add() RETURNS trigger AS $$
+DECLARE
+    prev player_stats_daily%ROWTYPE;
+BEGIN
+    IF TG_OP = 'UPDATE' THEN
+        prev := OLD;
+    END IF;
+    INSERT INTO player_stats_total (user_id, net_coins, games_played, games_won, finals, staked, returned)
+    VALUES (NEW.user_id,
+            NEW.net_coins - COALESCE(prev.net_coins, 0),
+            NEW.games_played - COALESCE(prev.games_played, 0),
+            NEW.games_won - COALESCE(prev.games_won, 0),
+            NEW.finals - COALESCE(prev.finals, 0),
+            NEW.staked - COALESCE(prev.staked, 0),
+            NEW.returned - COALESCE(prev.returned, 0))
+    ON CONFLICT (user_id) DO UPDATE
+       SET net_coins = player_stats_total.net_coins + EXCLUDED.net_coins,
+           games_played = player_stats_total.games_played + EXCLUDED.games_played,
+           games_won = player_stats_total.games_won + EXCLUDED.games_won,
+           finals = player_stats_total.finals + EXCLUDED.finals,
+           staked = player_stats_total.staked + EXCLUDED.staked,
+           returned = player_stats_total.returned + EXCLUDED.returned;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+INSERT INTO player_stats_total (user_id, net_coins, games_played, games_won, finals, staked, returned)
+SELECT user_id, SUM(net_coins), SUM(games_played), SUM(games_won), SUM(finals), SUM(staked), SUM(returned)
+  FROM player_stats_daily
+ GROUP BY user_id
+ON CONFLICT (user_id) DO NOTHING;
+
+CREATE TRIGGER player_stats_daily_total AFTER INSERT OR UPDATE ON player_stats_daily
+    FOR EACH ROW EXECUTE FUNCTION player_stats_total_add();
+
+ALTER TABLE room ADD COLUMN bets_locked_at TIMESTAMPTZ;
+
+UPDATE room r SET bets_locked_at = now()
+ WHERE r.status NOT IN ('open', 'in_game') OR COALESCE(r.current_round, 0) > 0
+    OR EXISTS (SELECT 1 FROM votes v WHERE v.room_id = r.room_id);
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// deezerClient реализует MusicProvider поверх публичного Deezer API. Поиск и
+// получение треков не требуют токена, работа с плейлистами — требует.
+type deezerClient struct {
+	apiURL     string
+	httpClient *http.Client
+}
+
+func newDeezerClientFromEnv() *deezerClient {
+	return &deezerClient{
+		apiURL:     strings.TrimRight(getEnv("DEEZER_API_URL", "https://api.deezer.com"), "/"),
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+type deezerTrack struct {
+	ID       int64  `json:"id"`
+	Title    string `json:"title"`
+	ISRC     string `json:"isrc"`
+	Duration int    `json:"duration"`
+	Preview  string `json:"preview"`
+	Artist   struct {
+		Name string `json:"name"`
+	} `json:"artist"`
+	Album struct {
+		CoverMedium string `json:"cover_medium"`
+	} `json:"album"`
+}
+
+func (t deezerTrack) toProviderTrack() ProviderTrack {
+	return ProviderTrack{
+		Provider:    "deezer",
+		ID:          strconv.FormatInt(t.ID, 10),
+		Title:       t.Title,
+		Artist:      t.Artist.Name,
+		AlbumArtURL: t.Album.CoverMedium,
+		ISRC:        t.ISRC,
+		DurationMs:  t.Duration * 1000,
+		PreviewURL:  t.Preview,
+	}
+}
+
+// deezerError — Deezer сообщает об ошибках телом ответа со статусом 200.
+type deezerError struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+	Code    int    `json:"code"`
+}
+
+func (e *deezerError) Error() string {
+	return fmt.Sprintf("deezer error %d (%s): %s", e.Code, e.Type, e.Message)
+}
+
+// deezerNoData — код ошибки Deezer для несуществующего объекта.
+const deezerNoData = 800
+
+func (c *deezerClient) request(ctx context.Context, method, path string, params url.Values, out interface{}) error {
+	if params == nil {
+		params = url.Values{}
+	}
+	endpoint := c.apiURL + path
+	if encoded := params.Encode(); encoded != "" {
+		endpoint += "?" + encoded
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("deezer responded with %d: %s", resp.StatusCode, string(data))
+	}
+
+	var envelope struct {
+		Error *deezerError `json:"error"`
+	}
+	if err := json.Unmarshal(data, &envelope); err == nil && envelope.Error != nil {
+		return envelope.Error
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+func (c *deezerClient) Name() string {
+	return "deezer"
+}
+
+func (c *deezerClient) Search(ctx context.Context, accessToken, query string, limit int) ([]ProviderTrack, error) {
+	var result struct {
+		Data []deezerTrack `json:"data"`
+	}
+	params := url.Values{}
+	params.Set("q", query)
+	params.Set("limit", strconv.Itoa(limit))
+	if err := c.request(ctx, http.MethodGet, "/search/track", params, &result); err != nil {
+		return nil, fmt.Errorf("search tracks: %w", err)
+	}
+
+	tracks := make([]ProviderTrack, 0, len(result.Data))
+	for _, t := range result.Data {
+		tracks = append(tracks, t.toProviderTrack())
+	}
+	return tracks, nil
+}
+
+func (c *deezerClient) lookup(ctx context.Context, ref string) (*ProviderTrack, error) {
+	var track deezerTrack
+	err := c.request(ctx, http.MethodGet, "/track/"+url.PathEscape(ref), nil, &track)
+	if dzErr, ok := err.(*deezerError); ok && dzErr.Code == deezerNoData {
+		return nil, errTrackNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get track: %w", err)
+	}
+	if track.ID == 0 {
+		return nil, errTrackNotFound
+	}
+	result := track.toProviderTrack()
+	return &result, nil
+}
+
+func (c *deezerClient) Track(ctx context.Context, accessToken, id string) (*ProviderTrack, error) {
+	return c.lookup(ctx, id)
+}
+
+func (c *deezerClient) FindByISRC(ctx context.Context, accessToken, isrc string) (*ProviderTrack, error) {
+	return c.lookup(ctx, "isrc:"+isrc)
+}
+
+func (c *deezerClient) ExportPlaylist(ctx context.Context, accessToken string, export PlaylistExport) (*ExportedPlaylist, error) {
+	auth := url.Values{}
+	auth.Set("access_token", accessToken)
+
+	playlistID := ""
+	if export.ExistingID != "" {
+		var existing struct {
+			ID     int64 `json:"id"`
+			Tracks struct {
+				Data []struct {
+					ID int64 `json:"id"`
+				} `json:"data"`
+			} `json:"tracks"`
+		}
+		err := c.request(ctx, http.MethodGet, "/playlist/"+url.PathEscape(export.ExistingID), auth, &existing)
+		dzErr, isDeezerErr := err.(*deezerError)
+		switch {
+		case isDeezerErr && dzErr.Code == deezerNoData:
+		case err != nil:
+			return nil, fmt.Errorf("get playlist: %w", err)
+		default:
+			playlistID = strconv.FormatInt(existing.ID, 10)
+
+			params := url.Values{}
+			params.Set("access_token", accessToken)
+			params.Set("title", export.Name)
+			params.Set("description", export.Description)
+			if err := c.request(ctx, http.MethodPost, "/playlist/"+playlistID, params, nil); err != nil {
+				return nil, fmt.Errorf("update playlist details: %w", err)
+			}
+
+			if len(existing.Tracks.Data) > 0 {
+				var old []string
+				for _, t := range existing.Tracks.Data {
+					old = append(old, strconv.FormatInt(t.ID, 10))
+				}
+				params := url.Values{}
+				params.Set("access_token", accessToken)
+				params.Set("songs", strings.Join(old, ","))
+				if err := c.request(ctx, http.MethodDelete, "/playlist/"+playlistID+"/tracks", params, nil); err != nil {
+					return nil, fmt.Errorf("clear playlist: %w", err)
+				}
+			}
+		}
+	}
+
+	if playlistID == "" {
+		var created struct {
+			ID int64 `json:"id"`
+		}
+		params := url.Values{}
+		params.Set("access_token", accessToken)
+		params.Set("title", export.Name)
+		if err := c.request(ctx, http.MethodPost, "/user/me/playlists", params, &created); err != nil {
+			return nil, fmt.Errorf("create playlist: %w", err)
+		}
+		playlistID = strconv.FormatInt(created.ID, 10)
+
+		params.Del("title")
+		params.Set("description", export.Description)
+		if err := c.request(ctx, http.MethodPost, "/playlist/"+playlistID, params, nil); err != nil {
+			return nil, fmt.Errorf("update playlist details: %w", err)
+		}
+	}
+
+	if len(export.TrackIDs) > 0 {
+		params := url.Values{}
+		params.Set("access_token", accessToken)
+		params.Set("songs", strings.Join(export.TrackIDs, ","))
+		if err := c.request(ctx, http.MethodPost, "/playlist/"+playlistID+"/tracks", params, nil); err != nil {
+			return nil, fmt.Errorf("add playlist items: %w", err)
+		}
+	}
+
+	return &ExportedPlaylist{ID: playlistID, URL: "https://www.deezer.com/playlist/" + playlistID}, nil
+}
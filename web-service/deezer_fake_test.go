@@ -0,0 +1,157 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const fakeDeezerToken = "dz-token"
+
+// fakeDeezer — локальная замена Deezer API: поиск и треки из записанных
+// ответов testdata/deezer, плейлисты — в памяти. Как и настоящий Deezer,
+// ошибки отдаёт телом со статусом 200.
+type fakeDeezer struct {
+	*httptest.Server
+	client *deezerClient
+
+	mu        sync.Mutex
+	nextID    int64
+	playlists map[string]*fakePlaylist
+}
+
+func newFakeDeezer(t *testing.T) *fakeDeezer {
+	t.Helper()
+	f := &fakeDeezer{nextID: 9000, playlists: map[string]*fakePlaylist{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/search/track", f.search)
+	mux.HandleFunc("/track/", f.track)
+	mux.HandleFunc("/user/me/playlists", f.createPlaylist)
+	mux.HandleFunc("/playlist/", f.playlist)
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+
+	f.client = &deezerClient{apiURL: f.URL, httpClient: f.Client()}
+	return f
+}
+
+func deezerErrorJSON(w http.ResponseWriter, kind, message string, code int) {
+	writeJSON(w, map[string]interface{}{
+		"error": map[string]interface{}{"type": kind, "message": message, "code": code},
+	})
+}
+
+func (f *fakeDeezer) search(w http.ResponseWriter, r *http.Request) {
+	if !serveFixture(w, "deezer/search/"+fixtureSlug(r.URL.Query().Get("q"))) {
+		writeJSON(w, map[string]interface{}{"data": []interface{}{}, "total": 0})
+	}
+}
+
+// track отдаёт testdata/deezer/track/<id>.json; ссылка "isrc:<код>" ищется
+// в файле isrc_<код>.json.
+func (f *fakeDeezer) track(w http.ResponseWriter, r *http.Request) {
+	if !serveFixture(w, "deezer/track/"+fixtureSlug(strings.TrimPrefix(r.URL.Path, "/track/"))) {
+		deezerErrorJSON(w, "DataException", "no data", deezerNoData)
+	}
+}
+
+func (f *fakeDeezer) authorized(w http.ResponseWriter, r *http.Request) bool {
+	if r.URL.Query().Get("access_token") != fakeDeezerToken {
+		deezerErrorJSON(w, "OAuthException", "Invalid OAuth access token.", 300)
+		return false
+	}
+	return true
+}
+
+func (f *fakeDeezer) getPlaylist(id string) *fakePlaylist {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p, ok := f.playlists[id]
+	if !ok {
+		return nil
+	}
+	cp := *p
+	cp.URIs = append([]string(nil), p.URIs...)
+	return &cp
+}
+
+func (f *fakeDeezer) createPlaylist(w http.ResponseWriter, r *http.Request) {
+	if !f.authorized(w, r) {
+		return
+	}
+	title := r.URL.Query().Get("title")
+	if r.Method != http.MethodPost || title == "" {
+		deezerErrorJSON(w, "ParameterException", "Wrong parameter: title", 500)
+		return
+	}
+	f.mu.Lock()
+	f.nextID++
+	id := f.nextID
+	f.playlists[strconv.FormatInt(id, 10)] = &fakePlaylist{Owner: "me", Name: title}
+	f.mu.Unlock()
+	writeJSON(w, map[string]int64{"id": id})
+}
+
+// playlist обслуживает /playlist/{id} и /playlist/{id}/tracks. Треки
+// плейлиста хранятся в fakePlaylist.URIs как id Deezer.
+func (f *fakeDeezer) playlist(w http.ResponseWriter, r *http.Request) {
+	if !f.authorized(w, r) {
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/playlist/")
+	id, tracks := strings.CutSuffix(id, "/tracks")
+	q := r.URL.Query()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p, ok := f.playlists[id]
+	if !ok {
+		deezerErrorJSON(w, "DataException", "no data", deezerNoData)
+		return
+	}
+
+	switch {
+	case !tracks && r.Method == http.MethodGet:
+		var data []map[string]int64
+		for _, t := range p.URIs {
+			n, _ := strconv.ParseInt(t, 10, 64)
+			data = append(data, map[string]int64{"id": n})
+		}
+		n, _ := strconv.ParseInt(id, 10, 64)
+		writeJSON(w, map[string]interface{}{
+			"id":     n,
+			"title":  p.Name,
+			"tracks": map[string]interface{}{"data": data},
+		})
+	case !tracks && r.Method == http.MethodPost:
+		if title := q.Get("title"); title != "" {
+			p.Name = title
+		}
+		if q.Has("description") {
+			p.Description = q.Get("description")
+		}
+		writeJSON(w, true)
+	case tracks && r.Method == http.MethodPost:
+		p.URIs = append(p.URIs, strings.Split(q.Get("songs"), ",")...)
+		writeJSON(w, true)
+	case tracks && r.Method == http.MethodDelete:
+		remove := map[string]bool{}
+		for _, s := range strings.Split(q.Get("songs"), ",") {
+			remove[s] = true
+		}
+		var kept []string
+		for _, s := range p.URIs {
+			if !remove[s] {
+				kept = append(kept, s)
+			}
+		}
+		p.URIs = kept
+		writeJSON(w, true)
+	default:
+		deezerErrorJSON(w, "MethodException", "Unknown method", 501)
+	}
+}
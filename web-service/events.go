@@ -0,0 +1,29 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// RoomEvent — сообщение, которое рассылается по WebSocket участникам комнаты.
+type RoomEvent struct {
+	Type    string      `json:"type"`
+	RoomID  int         `json:"roomId"`
+	SentAt  time.Time   `json:"sentAt"`
+	Payload interface{} `json:"payload,omitempty"`
+}
+
+func publishRoomEvent(roomID int, eventType string, payload interface{}) {
+	data, err := json.Marshal(RoomEvent{
+		Type:    eventType,
+		RoomID:  roomID,
+		SentAt:  time.Now(),
+		Payload: payload,
+	})
+	if err != nil {
+		log.Println("Error encoding room event:", err)
+		return
+	}
+	go broadcastToRoom(roomID, data)
+}
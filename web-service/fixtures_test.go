@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// Записанные ответы музыкальных сервисов лежат в testdata/<сервис>/. Локальные
+// заменители отдают их вместо настоящих API: поисковый запрос ищется по
+// fixtureSlug(q), трек — по своему id.
+
+// fixtureSlug превращает запрос в имя файла: "Queen Bohemian Rhapsody" →
+// "queen_bohemian_rhapsody".
+func fixtureSlug(s string) string {
+	var b strings.Builder
+	sep := false
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if sep && b.Len() > 0 {
+				b.WriteByte('_')
+			}
+			b.WriteRune(r)
+			sep = false
+			continue
+		}
+		sep = true
+	}
+	return b.String()
+}
+
+// serveFixture отдаёт testdata/<path>.json. Если записи нет, возвращает false
+// и ничего не пишет.
+func serveFixture(w http.ResponseWriter, path string) bool {
+	data, err := os.ReadFile(filepath.Join("testdata", filepath.FromSlash(path)+".json"))
+	if err != nil {
+		return false
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+	return true
+}
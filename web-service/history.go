@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// История баланса строится по журналу: каждая запись по счёту игрока с
+// причиной, остатком после неё и контекстом (комната, песня, рынок), который
+// восстанавливается по ссылке операции.
+
+const (
+	historyPageSize    = 50
+	historyMaxPageSize = 200
+)
+
+type BalanceEntry struct {
+	TxnID        int64     `json:"txnId"`
+	Reason       string    `json:"reason"`
+	Amount       int       `json:"amount"`
+	BalanceAfter int       `json:"balanceAfter"`
+	CreatedAt    time.Time `json:"createdAt"`
+	RoomID       *int      `json:"roomId,omitempty"`
+	SongID       *int      `json:"songId,omitempty"`
+	TrackName    *string   `json:"trackName,omitempty"`
+	MarketID     *int      `json:"marketId,omitempty"`
+	Selection    *string   `json:"selection,omitempty"`
+}
+
+type BalancePage struct {
+	Entries    []BalanceEntry `json:"entries"`
+	NextBefore *int64         `json:"nextBefore,omitempty"`
+}
+
+// balanceHistory возвращает записи счёта пользователя от новых к старым,
+// начиная с операций раньше before (0 — с последней).
+func balanceHistory(ctx context.Context, userID int, before int64, limit int) (*BalancePage, error) {
+	rows, err := db.Query(ctx, `
+		WITH account AS (
+			SELECT e.txn_id, e.amount,
+			       SUM(e.amount) OVER (ORDER BY e.txn_id, e.entry_id) AS balance_after
+			  FROM ledger_entry e
+			 WHERE e.account_kind = 'user' AND e.account_id = $1
+		)
+		SELECT a.txn_id, t.reason, a.amount, a.balance_after, t.created_at,
+		       COALESCE(t.room_id, b.room_id, mb.room_id, tm.room_id), COALESCE(t.song_id, b.song_id),
+		       COALESCE(t.track_name, s.track_name),
+		       mb.market_id, mb.selection
+		  FROM account a
+		  JOIN ledger_txn t ON t.txn_id = a.txn_id
+	 LEFT JOIN bets b ON split_part(t.ref, ':', 1) = 'bet' AND b.bet_id = split_part(t.ref, ':', 2)::int
+	 LEFT JOIN song s ON s.song_id = b.song_id
+	 LEFT JOIN market_bet mb ON split_part(t.ref, ':', 1) = 'market_bet' AND mb.bet_id = split_part(t.ref, ':', 2)::int
+	 LEFT JOIN team tm ON split_part(t.ref, ':', 1) = 'team' AND tm.team_id = split_part(t.ref, ':', 2)::int
+		 WHERE $2 = 0 OR a.txn_id < $2
+	  ORDER BY a.txn_id DESC
+		 LIMIT $3`, userID, before, limit+1)
+	if err != nil {
+		return nil, fmt.Errorf("query balance history: %w", err)
+	}
+	defer rows.Close()
+
+	page := &BalancePage{Entries: []BalanceEntry{}}
+	for rows.Next() {
+		var e BalanceEntry
+		if err := rows.Scan(&e.TxnID, &e.Reason, &e.Amount, &e.BalanceAfter, &e.CreatedAt,
+			&e.RoomID, &e.SongID, &e.TrackName, &e.MarketID, &e.Selection); err != nil {
+			return nil, fmt.Errorf("scan balance entry: %w", err)
+		}
+		page.Entries = append(page.Entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(page.Entries) > limit {
+		page.Entries = page.Entries[:limit]
+		next := page.Entries[limit-1].TxnID
+		page.NextBefore = &next
+	}
+	return page, nil
+}
+
+// BetReturn — итог одной ставки: рынок или песня, ставка, выплата и доходность.
+type BetReturn struct {
+	BetID     int      `json:"betId"`
+	Kind      string   `json:"kind"`
+	SongID    *int     `json:"songId,omitempty"`
+	MarketID  *int     `json:"marketId,omitempty"`
+	Selection *string  `json:"selection,omitempty"`
+	Stake     int      `json:"stake"`
+	Payout    *int     `json:"payout,omitempty"`
+	Return    *float64 `json:"return,omitempty"`
+}
+
+type GameProfit struct {
+	UserID   int         `json:"userId"`
+	Name     string      `json:"name"`
+	TeamID   *int        `json:"teamId,omitempty"`
+	Staked   int         `json:"staked"`
+	Returned int         `json:"returned"`
+	Pending  int         `json:"pending"`
+	Net      int         `json:"net"`
+	ROI      *float64    `json:"roi,omitempty"`
+	Bets     []BetReturn `json:"bets"`
+}
+
+// gameProfit сводит ставки комнаты по участникам. До закрытия приёма ставок
+// вызывающий видит только свою строку.
+func gameProfit(ctx context.Context, roomID, callerID int) ([]*GameProfit, error) {
+	locked, err := bettingLocked(ctx, db, roomID)
+	if err != nil {
+		return nil, fmt.Errorf("check betting lock: %w", err)
+	}
+
+	rows, err := db.Query(ctx, `
+		SELECT p.user_id, COALESCE(u.name, ''), p.team_id
+		  FROM participation p
+		  JOIN "user" u ON u.user_id = p.user_id
+		 WHERE p.room_id = $1 AND p.role = 'player' AND ($2 OR p.user_id = $3)
+	  ORDER BY p.user_id`, roomID, locked, callerID)
+	if err != nil {
+		return nil, fmt.Errorf("query participants: %w", err)
+	}
+	result := []*GameProfit{}
+	byUser := map[int]*GameProfit{}
+	for rows.Next() {
+		g := &GameProfit{Bets: []BetReturn{}}
+		if err := rows.Scan(&g.UserID, &g.Name, &g.TeamID); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan participant: %w", err)
+		}
+		result = append(result, g)
+		byUser[g.UserID] = g
+	}
+	rows.Close()
+
+	rows, err = db.Query(ctx, `
+		SELECT bet_id, 'song', user_id, song_id, NULL::int, NULL::varchar, bet_amount, payout
+		  FROM bets WHERE room_id = $1 AND bet_amount > 0
+		 UNION ALL
+		SELECT bet_id, 'market', user_id, NULL, market_id, selection, stake, payout
+		  FROM market_bet WHERE room_id = $1
+	  ORDER BY 3, 2, 1`, roomID)
+	if err != nil {
+		return nil, fmt.Errorf("query room bets: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var b BetReturn
+		var userID int
+		if err := rows.Scan(&b.BetID, &b.Kind, &userID, &b.SongID, &b.MarketID, &b.Selection, &b.Stake, &b.Payout); err != nil {
+			return nil, fmt.Errorf("scan room bet: %w", err)
+		}
+		g, ok := byUser[userID]
+		if !ok {
+			continue
+		}
+		g.Staked += b.Stake
+		if b.Payout == nil {
+			g.Pending += b.Stake
+		} else {
+			ret := float64(*b.Payout-b.Stake) / float64(b.Stake)
+			b.Return = &ret
+			g.Returned += *b.Payout
+			g.Net += *b.Payout - b.Stake
+		}
+		g.Bets = append(g.Bets, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, g := range result {
+		if settled := g.Staked - g.Pending; settled > 0 {
+			roi := float64(g.Net) / float64(settled)
+			g.ROI = &roi
+		}
+	}
+	return result, nil
+}
+
+func balanceHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	limit := historyPageSize
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		if n > historyMaxPageSize {
+			n = historyMaxPageSize
+		}
+		limit = n
+	}
+	var before int64
+	if v := r.URL.Query().Get("before"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 1 {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+		before = n
+	}
+
+	page, err := balanceHistory(r.Context(), userID, before, limit)
+	if err != nil {
+		log.Println("Error fetching balance history:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+func gameProfitHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	callerID, ok := requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	roomID, err := strconv.Atoi(r.URL.Query().Get("roomId"))
+	if err != nil {
+		http.Error(w, "roomId is required", http.StatusBadRequest)
+		return
+	}
+
+	summary, err := gameProfit(r.Context(), roomID, callerID)
+	if err != nil {
+		log.Println("Error building profit summary:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summary)
+}
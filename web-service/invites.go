@@ -0,0 +1,380 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// Приглашения в комнату. Токен — это base64url(payload) + "." + подпись
+// HMAC-SHA256 от payload; payload содержит номер приглашения, комнату и срок
+// действия. Подпись защищает от подделки, а число использований и отзыв
+// хранятся в room_invite.
+
+const (
+	inviteDefaultTTL = 24 * time.Hour
+	inviteMaxTTL     = 30 * 24 * time.Hour
+)
+
+var (
+	inviteSigningKey []byte
+	inviteBaseURL    = getEnv("INVITE_BASE_URL", "kingofthebeat://invite/")
+
+	errInvalidInvite = errors.New("invalid invite token")
+	errInviteExpired = errors.New("invite expired")
+	errInviteUsedUp  = errors.New("invite has no uses left")
+	errInviteRevoked = errors.New("invite revoked")
+)
+
+type Invite struct {
+	InviteID  string     `json:"inviteId"`
+	RoomID    int        `json:"roomId"`
+	Token     string     `json:"token"`
+	URL       string     `json:"url"`
+	CreatedBy int        `json:"createdBy"`
+	ExpiresAt time.Time  `json:"expiresAt"`
+	MaxUses   int        `json:"maxUses"`
+	Uses      int        `json:"uses"`
+	RevokedAt *time.Time `json:"revokedAt,omitempty"`
+}
+
+type invitePayload struct {
+	inviteID  []byte
+	roomID    int
+	expiresAt time.Time
+}
+
+func initInviteSigning() {
+	key, err := base64.StdEncoding.DecodeString(os.Getenv("INVITE_SIGNING_KEY"))
+	if err != nil || len(key) < 32 {
+		log.Println("INVITE_SIGNING_KEY is missing or invalid, using an ephemeral key")
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			log.Fatal("Unable to generate invite signing key:", err)
+		}
+	}
+	inviteSigningKey = key
+}
+
+func signInvite(p invitePayload) string {
+	buf := make([]byte, 0, len(p.inviteID)+16)
+	buf = append(buf, p.inviteID...)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(p.roomID))
+	buf = binary.BigEndian.AppendUint64(buf, uint64(p.expiresAt.Unix()))
+
+	mac := hmac.New(sha256.New, inviteSigningKey)
+	mac.Write(buf)
+	return base64.RawURLEncoding.EncodeToString(buf) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func parseInviteToken(token string) (invitePayload, error) {
+	var p invitePayload
+	encoded, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return p, errInvalidInvite
+	}
+	buf, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || len(buf) <= 16 {
+		return p, errInvalidInvite
+	}
+	got, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return p, errInvalidInvite
+	}
+	mac := hmac.New(sha256.New, inviteSigningKey)
+	mac.Write(buf)
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return p, errInvalidInvite
+	}
+
+	n := len(buf) - 16
+	p.inviteID = buf[:n]
+	p.roomID = int(binary.BigEndian.Uint64(buf[n:]))
+	p.expiresAt = time.Unix(int64(binary.BigEndian.Uint64(buf[n+8:])), 0)
+	if time.Now().After(p.expiresAt) {
+		return p, errInviteExpired
+	}
+	return p, nil
+}
+
+func inviteURL(token string) string {
+	return inviteBaseURL + token
+}
+
+func inviteErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errInvalidInvite):
+		return http.StatusBadRequest
+	case errors.Is(err, errInviteExpired), errors.Is(err, errInviteRevoked):
+		return http.StatusGone
+	case errors.Is(err, errInviteUsedUp):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// requireRoomOwner пишет ошибку и возвращает false, если вызывающий не владелец комнаты.
+func requireRoomOwner(w http.ResponseWriter, ctx context.Context, roomID, callerID int) bool {
+	var ownerID *int
+	if err := db.QueryRow(ctx, `SELECT owner_id FROM room WHERE room_id = $1`, roomID).Scan(&ownerID); err != nil {
+		http.Error(w, "Room not found", http.StatusNotFound)
+		return false
+	}
+	if ownerID == nil || *ownerID != callerID {
+		http.Error(w, "Only the owner can manage invites", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// roomInvitesHandler: GET — список приглашений комнаты, POST — новое приглашение.
+func roomInvitesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		listInvitesHandler(w, r)
+	case http.MethodPost:
+		createInviteHandler(w, r)
+	default:
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+	}
+}
+
+func createInviteHandler(w http.ResponseWriter, r *http.Request) {
+	callerID, ok := requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	var data struct {
+		RoomId     int `json:"roomId"`
+		TTLMinutes int `json:"ttlMinutes"`
+		MaxUses    int `json:"maxUses"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	ttl := time.Duration(data.TTLMinutes) * time.Minute
+	if ttl <= 0 {
+		ttl = inviteDefaultTTL
+	}
+	if ttl > inviteMaxTTL {
+		http.Error(w, "ttlMinutes is too large", http.StatusBadRequest)
+		return
+	}
+	if data.MaxUses <= 0 {
+		data.MaxUses = 1
+	}
+
+	ctx := r.Context()
+	if !requireRoomOwner(w, ctx, data.RoomId, callerID) {
+		return
+	}
+
+	id := make([]byte, 12)
+	if _, err := rand.Read(id); err != nil {
+		log.Println("Error generating invite id:", err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	invite := Invite{
+		InviteID:  base64.RawURLEncoding.EncodeToString(id),
+		RoomID:    data.RoomId,
+		CreatedBy: callerID,
+		ExpiresAt: time.Now().Add(ttl).Truncate(time.Second),
+		MaxUses:   data.MaxUses,
+	}
+	invite.Token = signInvite(invitePayload{inviteID: id, roomID: data.RoomId, expiresAt: invite.ExpiresAt})
+	invite.URL = inviteURL(invite.Token)
+
+	if _, err := db.Exec(ctx, `
+		INSERT INTO room_invite (invite_id, room_id, created_by, expires_at, max_uses)
+		VALUES ($1, $2, $3, $4, $5)`,
+		invite.InviteID, invite.RoomID, callerID, invite.ExpiresAt, invite.MaxUses); err != nil {
+		log.Println("Error saving invite:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(invite)
+}
+
+func listInvitesHandler(w http.ResponseWriter, r *http.Request) {
+	callerID, ok := requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	roomID, err := strconv.Atoi(r.URL.Query().Get("roomId"))
+	if err != nil {
+		http.Error(w, "roomId is required", http.StatusBadRequest)
+		return
+	}
+	ctx := r.Context()
+	if !requireRoomOwner(w, ctx, roomID, callerID) {
+		return
+	}
+
+	// Отозванные и истёкшие не показываем, если не попросили все.
+	all := r.URL.Query().Get("all") == "true"
+	rows, err := db.Query(ctx, `
+		SELECT invite_id, room_id, created_by, expires_at, max_uses, uses, revoked_at
+		  FROM room_invite
+		 WHERE room_id = $1
+		   AND ($2 OR (revoked_at IS NULL AND expires_at > now() AND uses < max_uses))
+		 ORDER BY created_at DESC`, roomID, all)
+	if err != nil {
+		log.Println("Error fetching invites:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	invites := []Invite{}
+	for rows.Next() {
+		var inv Invite
+		if err := rows.Scan(&inv.InviteID, &inv.RoomID, &inv.CreatedBy, &inv.ExpiresAt,
+			&inv.MaxUses, &inv.Uses, &inv.RevokedAt); err != nil {
+			log.Println("Error scanning invite:", err)
+			continue
+		}
+		if id, err := base64.RawURLEncoding.DecodeString(inv.InviteID); err == nil {
+			inv.Token = signInvite(invitePayload{inviteID: id, roomID: inv.RoomID, expiresAt: inv.ExpiresAt})
+			inv.URL = inviteURL(inv.Token)
+		}
+		invites = append(invites, inv)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(invites)
+}
+
+func revokeInviteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	callerID, ok := requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	var data struct {
+		InviteId string `json:"inviteId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	tag, err := db.Exec(r.Context(), `
+		UPDATE room_invite i
+		   SET revoked_at = now()
+		  FROM room rm
+		 WHERE i.room_id = rm.room_id AND i.invite_id = $1 AND rm.owner_id = $2 AND i.revoked_at IS NULL`,
+		data.InviteId, callerID)
+	if err != nil {
+		log.Println("Error revoking invite:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		http.Error(w, "Invite not found or you are not the owner", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// claimInvite засчитывает одно использование приглашения, если оно ещё действует.
+func claimInvite(ctx context.Context, p invitePayload) error {
+	inviteID := base64.RawURLEncoding.EncodeToString(p.inviteID)
+	tag, err := db.Exec(ctx, `
+		UPDATE room_invite
+		   SET uses = uses + 1
+		 WHERE invite_id = $1 AND room_id = $2
+		   AND revoked_at IS NULL AND expires_at > now() AND uses < max_uses`,
+		inviteID, p.roomID)
+	if err != nil {
+		return fmt.Errorf("claim invite: %w", err)
+	}
+	if tag.RowsAffected() > 0 {
+		return nil
+	}
+
+	var revoked bool
+	err = db.QueryRow(ctx, `SELECT revoked_at IS NOT NULL FROM room_invite WHERE invite_id = $1`, inviteID).Scan(&revoked)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		return errInvalidInvite
+	case err != nil:
+		return fmt.Errorf("fetch invite: %w", err)
+	case revoked:
+		return errInviteRevoked
+	default:
+		return errInviteUsedUp
+	}
+}
+
+func releaseInvite(ctx context.Context, p invitePayload) {
+	if _, err := db.Exec(ctx, `
+		UPDATE room_invite SET uses = uses - 1 WHERE invite_id = $1 AND uses > 0`,
+		base64.RawURLEncoding.EncodeToString(p.inviteID)); err != nil {
+		log.Println("Error releasing invite use:", err)
+	}
+}
+
+func acceptInviteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	var data struct {
+		Token     string `json:"token"`
+		Spectator bool   `json:"spectator"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	payload, err := parseInviteToken(data.Token)
+	if err == nil {
+		err = claimInvite(r.Context(), payload)
+	}
+	if err != nil {
+		if inviteErrorStatus(err) == http.StatusInternalServerError {
+			log.Println("Error accepting invite:", err)
+		}
+		http.Error(w, err.Error(), inviteErrorStatus(err))
+		return
+	}
+
+	if !addUserToRoom(w, r, payload.roomID, userID, data.Spectator) {
+		releaseInvite(context.Background(), payload)
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// Номер комнаты — внутренний идентификатор, который сервер выбирает сам.
+// Чтобы войти в комнату, игроки используют отдельный короткий код: он
+// генерируется криптостойким генератором из алфавита без похожих символов
+// (0/O, 1/I/L), живёт ограниченное время и может быть заменён владельцем.
+const (
+	joinCodeAlphabet = "23456789ABCDEFGHJKMNPQRSTUVWXYZ"
+	joinCodeLength   = 6
+
+	minRoomID = 100000000
+	maxRoomID = 1<<31 - 1
+)
+
+var (
+	joinCodeTTL       = getEnvDuration("JOIN_CODE_TTL", 24*time.Hour)
+	joinLookupLimiter = newRateLimiter(getEnvInt("JOIN_LOOKUP_RATE_PER_MINUTE", 20), getEnvInt("JOIN_LOOKUP_BURST", 5))
+
+	errJoinCodeNotFound = errors.New("join code not found")
+	errJoinCodeExpired  = errors.New("join code expired")
+)
+
+type JoinCode struct {
+	Code      string    `json:"code"`
+	RoomID    int       `json:"roomId"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+func randomInt(max int64) (int64, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(max))
+	if err != nil {
+		return 0, err
+	}
+	return n.Int64(), nil
+}
+
+func generateJoinCode() (string, error) {
+	b := make([]byte, joinCodeLength)
+	for i := range b {
+		n, err := randomInt(int64(len(joinCodeAlphabet)))
+		if err != nil {
+			return "", err
+		}
+		b[i] = joinCodeAlphabet[n]
+	}
+	return string(b), nil
+}
+
+// normalizeJoinCode приводит введённый код к каноническому виду: регистр и
+// разделители не важны.
+func normalizeJoinCode(code string) string {
+	return strings.NewReplacer("-", "", " ", "").Replace(strings.ToUpper(strings.TrimSpace(code)))
+}
+
+// newRoomID выбирает свободный случайный номер комнаты вне диапазона
+// шестизначных кодов, которые раньше служили номерами.
+func newRoomID(ctx context.Context) (int, error) {
+	for attempt := 0; attempt < 5; attempt++ {
+		n, err := randomInt(maxRoomID - minRoomID)
+		if err != nil {
+			return 0, err
+		}
+		id := int(n) + minRoomID
+
+		var exists bool
+		if err := db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM room WHERE room_id = $1)`, id).Scan(&exists); err != nil {
+			return 0, fmt.Errorf("check room id: %w", err)
+		}
+		if !exists {
+			return id, nil
+		}
+	}
+	return 0, errors.New("no free room id")
+}
+
+type queryRower interface {
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+// issueJoinCode выдаёт комнате новый код; прежние коды комнаты перестают действовать.
+func issueJoinCode(ctx context.Context, q queryRower, roomID int) (*JoinCode, error) {
+	for attempt := 0; attempt < 5; attempt++ {
+		code, err := generateJoinCode()
+		if err != nil {
+			return nil, err
+		}
+		jc := &JoinCode{Code: code, RoomID: roomID}
+		err = q.QueryRow(ctx, `
+			WITH revoked AS (
+				UPDATE room_join_code SET revoked_at = now()
+				 WHERE room_id = $2 AND revoked_at IS NULL
+			)
+			INSERT INTO room_join_code (code, room_id, expires_at)
+			VALUES ($1, $2, now() + make_interval(secs => $3))
+			ON CONFLICT (code) DO NOTHING
+			RETURNING expires_at`, code, roomID, joinCodeTTL.Seconds()).Scan(&jc.ExpiresAt)
+		if errors.Is(err, pgx.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("insert join code: %w", err)
+		}
+		return jc, nil
+	}
+	return nil, errors.New("no free join code")
+}
+
+func resolveJoinCode(ctx context.Context, code string) (int, error) {
+	var roomID int
+	var expiresAt time.Time
+	var revoked bool
+	err := db.QueryRow(ctx, `
+		SELECT room_id, expires_at, revoked_at IS NOT NULL FROM room_join_code WHERE code = $1`,
+		normalizeJoinCode(code)).Scan(&roomID, &expiresAt, &revoked)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, errJoinCodeNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("lookup join code: %w", err)
+	}
+	if revoked || time.Now().After(expiresAt) {
+		return 0, errJoinCodeExpired
+	}
+	return roomID, nil
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// resolveJoinCodeRequest ищет комнату по коду с ограничением частоты запросов
+// с одного адреса, чтобы коды нельзя было перебрать. Ошибку пишет сам.
+func resolveJoinCodeRequest(w http.ResponseWriter, r *http.Request, code string) (int, bool) {
+	if code == "" {
+		http.Error(w, "code is required", http.StatusBadRequest)
+		return 0, false
+	}
+	if !joinLookupLimiter.allow(clientIP(r)) {
+		w.Header().Set("Retry-After", "10")
+		http.Error(w, "Too many attempts", http.StatusTooManyRequests)
+		return 0, false
+	}
+
+	roomID, err := resolveJoinCode(r.Context(), code)
+	switch {
+	case errors.Is(err, errJoinCodeNotFound):
+		http.Error(w, "Room not found", http.StatusNotFound)
+		return 0, false
+	case errors.Is(err, errJoinCodeExpired):
+		http.Error(w, "Join code has expired", http.StatusGone)
+		return 0, false
+	case err != nil:
+		log.Println("Error resolving join code:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return 0, false
+	}
+	return roomID, true
+}
+
+func lookupJoinCodeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	roomID, ok := resolveJoinCodeRequest(w, r, r.URL.Query().Get("code"))
+	if !ok {
+		return
+	}
+
+	var room Room
+	err := db.QueryRow(r.Context(), `
+		SELECT room_id, COALESCE(owner_id, 0), name, status FROM room WHERE room_id = $1`,
+		roomID).Scan(&room.RoomID, &room.OwnerID, &room.Name, &room.Status)
+	if err != nil {
+		http.Error(w, "Room not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(room)
+}
+
+func rotateJoinCodeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	callerID, ok := requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	var data struct {
+		RoomId int `json:"roomId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	var ownerID *int
+	if err := db.QueryRow(r.Context(), `SELECT owner_id FROM room WHERE room_id = $1`, data.RoomId).Scan(&ownerID); err != nil {
+		http.Error(w, "Room not found", http.StatusNotFound)
+		return
+	}
+	if ownerID == nil || *ownerID != callerID {
+		http.Error(w, "Only the owner can change the join code", http.StatusForbidden)
+		return
+	}
+
+	jc, err := issueJoinCode(r.Context(), db, data.RoomId)
+	if err != nil {
+		log.Println("Error issuing join code:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(jc)
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// Таблицы лидеров между играми считаются по дневным агрегатам
+// player_stats_daily. Движение монет в них пишет триггер журнала, а итоги
+// турнира (сыгранные игры, победы, финалы, ставки) — recordGameStats при
+// расчёте финала. Окно week/month суммирует последние дни, all читает
+// итоговую строку player_stats_total, которую ведёт триггер дневных агрегатов.
+const (
+	boardRichest = "richest"
+	boardWins    = "wins"
+	boardROI     = "roi"
+	boardFinals  = "finals"
+
+	leaderboardPageSize = 20
+	leaderboardMaxSize  = 100
+)
+
+var (
+	leaderboardMinStaked = getEnvInt("LEADERBOARD_MIN_STAKED", 100)
+
+	leaderboardWindows = map[string]int{
+		"week":  7,
+		"month": 30,
+		"all":   0,
+	}
+)
+
+var (
+	errUnknownBoard  = errors.New("unknown leaderboard")
+	errUnknownWindow = errors.New("unknown window")
+	errSelfFriend    = errors.New("cannot add yourself as a friend")
+)
+
+type LeaderboardEntry struct {
+	Rank       int     `json:"rank"`
+	UserID     int     `json:"userId"`
+	Name       string  `json:"name"`
+	ProfilePic string  `json:"profilePic,omitempty"`
+	Value      float64 `json:"value"`
+	Staked     int     `json:"staked,omitempty"`
+	Returned   int     `json:"returned,omitempty"`
+}
+
+type Leaderboard struct {
+	Board   string             `json:"board"`
+	Window  string             `json:"window"`
+	Scope   string             `json:"scope"`
+	Entries []LeaderboardEntry `json:"entries"`
+}
+
+// recordGameStats добавляет итоги доигранного турнира в дневные агрегаты.
+func recordGameStats(ctx context.Context, tx pgx.Tx, s *MatchSettlement) error {
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO player_stats_daily (user_id, day, games_played)
+		SELECT user_id, CURRENT_DATE, 1
+		  FROM participation
+		 WHERE room_id = $1 AND role = 'player' AND left_at IS NULL
+		ON CONFLICT (user_id, day) DO UPDATE
+		   SET games_played = player_stats_daily.games_played + 1`, s.RoomID); err != nil {
+		return fmt.Errorf("record games played: %w", err)
+	}
+
+	// Победа — у автора песни-чемпиона, финал — у авторов обеих песен финала.
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO player_stats_daily (user_id, day, games_won, finals)
+		SELECT user_id, CURRENT_DATE,
+		       COUNT(*) FILTER (WHERE song_id = $2), COUNT(*)
+		  FROM song
+		 WHERE song_id IN ($2, $3)
+	  GROUP BY user_id
+		ON CONFLICT (user_id, day) DO UPDATE
+		   SET games_won = player_stats_daily.games_won + EXCLUDED.games_won,
+		       finals = player_stats_daily.finals + EXCLUDED.finals`,
+		s.WinnerSongID, s.LoserSongID); err != nil {
+		return fmt.Errorf("record finals: %w", err)
+	}
+
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO player_stats_daily (user_id, day, staked, returned)
+		SELECT user_id, CURRENT_DATE, SUM(stake), SUM(payout)
+		  FROM (SELECT user_id, bet_amount AS stake, COALESCE(payout, 0) AS payout
+		          FROM bets WHERE room_id = $1 AND settled_at IS NOT NULL AND voided_at IS NULL AND bet_amount > 0
+		         UNION ALL
+		        SELECT b.user_id, b.stake, COALESCE(b.payout, 0)
+		          FROM market_bet b
+		          JOIN bet_market m ON m.market_id = b.market_id
+		         WHERE b.room_id = $1 AND b.settled_at IS NOT NULL AND m.status = 'settled') bets
+	  GROUP BY user_id
+		ON CONFLICT (user_id, day) DO UPDATE
+		   SET staked = player_stats_daily.staked + EXCLUDED.staked,
+		       returned = player_stats_daily.returned + EXCLUDED.returned`, s.RoomID); err != nil {
+		return fmt.Errorf("record betting returns: %w", err)
+	}
+	return nil
+}
+
+// leaderboard строит таблицу board за окно window. friendsOf > 0 оставляет
+// в ней только этого пользователя и его друзей.
+func leaderboard(ctx context.Context, board, window string, friendsOf, limit int) (*Leaderboard, error) {
+	days, ok := leaderboardWindows[window]
+	if !ok {
+		return nil, errUnknownWindow
+	}
+
+	// Для all-time богатства берётся текущий баланс, для окон — заработанное за окно.
+	var value, having string
+	switch board {
+	case boardRichest:
+		value = "SUM(d.net_coins)::float8"
+		if days == 0 {
+			value = "MAX(COALESCE(u.balance, 0))::float8"
+		}
+	case boardWins:
+		value, having = "SUM(d.games_won)::float8", "SUM(d.games_won) > 0"
+	case boardFinals:
+		value, having = "SUM(d.finals)::float8", "SUM(d.finals) > 0"
+	case boardROI:
+		value = "SUM(d.returned - d.staked)::float8 / NULLIF(SUM(d.staked), 0)"
+		having = fmt.Sprintf("SUM(d.staked) >= %d", leaderboardMinStaked)
+	default:
+		return nil, errUnknownBoard
+	}
+	if having == "" {
+		having = "TRUE"
+	}
+
+	// У итоговой строки те же столбцы, что у дневной, и она одна на игрока.
+	stats := `player_stats_daily d ON d.user_id = u.user_id AND d.day > CURRENT_DATE - $1::int`
+	if days == 0 {
+		stats = `player_stats_total d ON d.user_id = u.user_id AND $1::int = 0`
+	}
+
+	rows, err := db.Query(ctx, `
+		SELECT u.user_id, COALESCE(u.name, ''), COALESCE(u.profile_pic, ''),
+		       `+value+`, COALESCE(SUM(d.staked), 0), COALESCE(SUM(d.returned), 0)
+		  FROM "user" u
+	 LEFT JOIN `+stats+`
+		 WHERE NOT u.is_bot
+		   AND ($2 = 0 OR u.user_id = $2
+		        OR u.user_id IN (SELECT friend_id FROM friendship WHERE user_id = $2))
+	  GROUP BY u.user_id
+		HAVING `+having+` AND `+value+` IS NOT NULL
+	  ORDER BY 4 DESC, u.user_id
+		 LIMIT $3`, days, friendsOf, limit)
+	if err != nil {
+		return nil, fmt.Errorf("query leaderboard: %w", err)
+	}
+	defer rows.Close()
+
+	lb := &Leaderboard{Board: board, Window: window, Scope: "global", Entries: []LeaderboardEntry{}}
+	if friendsOf > 0 {
+		lb.Scope = "friends"
+	}
+	for rows.Next() {
+		var e LeaderboardEntry
+		if err := rows.Scan(&e.UserID, &e.Name, &e.ProfilePic, &e.Value, &e.Staked, &e.Returned); err != nil {
+			return nil, fmt.Errorf("scan leaderboard: %w", err)
+		}
+		if board != boardROI {
+			e.Staked, e.Returned = 0, 0
+		}
+		e.Rank = len(lb.Entries) + 1
+		lb.Entries = append(lb.Entries, e)
+	}
+	return lb, rows.Err()
+}
+
+func leaderboardHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	board, window := q.Get("board"), q.Get("window")
+	if board == "" {
+		board = boardRichest
+	}
+	if window == "" {
+		window = "all"
+	}
+	limit := leaderboardPageSize
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		if n > leaderboardMaxSize {
+			n = leaderboardMaxSize
+		}
+		limit = n
+	}
+
+	friendsOf := 0
+	switch q.Get("scope") {
+	case "", "global":
+	case "friends":
+		userID, ok := requireSession(w, r)
+		if !ok {
+			return
+		}
+		friendsOf = userID
+	default:
+		http.Error(w, "Unknown scope", http.StatusBadRequest)
+		return
+	}
+
+	lb, err := leaderboard(r.Context(), board, window, friendsOf, limit)
+	if errors.Is(err, errUnknownBoard) || errors.Is(err, errUnknownWindow) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Println("Error building leaderboard:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lb)
+}
+
+func friendsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		listFriendsHandler(w, r)
+	case http.MethodPost, http.MethodDelete:
+		updateFriendHandler(w, r)
+	default:
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+	}
+}
+
+func listFriendsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	rows, err := db.Query(r.Context(), `
+		SELECT u.user_id, COALESCE(u.name, ''), COALESCE(u.profile_pic, '')
+		  FROM friendship f
+		  JOIN "user" u ON u.user_id = f.friend_id
+		 WHERE f.user_id = $1
+	  ORDER BY f.created_at`, userID)
+	if err != nil {
+		log.Println("Error listing friends:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	type friend struct {
+		UserID     int    `json:"userId"`
+		Name       string `json:"name"`
+		ProfilePic string `json:"profilePic,omitempty"`
+	}
+	friends := []friend{}
+	for rows.Next() {
+		var f friend
+		if err := rows.Scan(&f.UserID, &f.Name, &f.ProfilePic); err != nil {
+			log.Println("Error scanning friend:", err)
+			continue
+		}
+		friends = append(friends, f)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(friends)
+}
+
+// updateFriendHandler добавляет (POST) или удаляет (DELETE) друга вызывающего.
+func updateFriendHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	var data struct {
+		FriendId int `json:"friendId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if data.FriendId == userID {
+		http.Error(w, errSelfFriend.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		if _, err := db.Exec(r.Context(), `
+			DELETE FROM friendship WHERE user_id = $1 AND friend_id = $2`, userID, data.FriendId); err != nil {
+			log.Println("Error removing friend:", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	var exists bool
+	if err := db.QueryRow(r.Context(), `
+		SELECT EXISTS (SELECT 1 FROM "user" WHERE user_id = $1)`, data.FriendId).Scan(&exists); err != nil {
+		log.Println("Error checking friend:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if _, err := db.Exec(r.Context(), `
+		INSERT INTO friendship (user_id, friend_id) VALUES ($1, $2)
+		ON CONFLICT DO NOTHING`, userID, data.FriendId); err != nil {
+		log.Println("Error adding friend:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
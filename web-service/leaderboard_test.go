@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestAllTimeLeaderboardReadsTotals(t *testing.T) {
+	openTestDB(t)
+	a := mustCreateUser(t, "A")
+	b := mustCreateUser(t, "B")
+
+	mustExec(t, `INSERT INTO player_stats_daily (user_id, day, games_won) VALUES ($1, CURRENT_DATE - 40, 2)`, a)
+	mustExec(t, `INSERT INTO player_stats_daily (user_id, day, games_won) VALUES ($1, CURRENT_DATE, 1)`, a)
+	mustExec(t, `INSERT INTO player_stats_daily (user_id, day, games_won) VALUES ($1, CURRENT_DATE, 2)`, b)
+	// Повторная запись того же дня добавляет в итог только разницу.
+	mustExec(t, `UPDATE player_stats_daily SET games_won = games_won + 2 WHERE user_id = $1 AND day = CURRENT_DATE`, b)
+
+	ctx := context.Background()
+	var total int
+	if err := db.QueryRow(ctx, `SELECT games_won FROM player_stats_total WHERE user_id = $1`, a).Scan(&total); err != nil {
+		t.Fatalf("load total: %v", err)
+	}
+	if total != 3 {
+		t.Fatalf("total wins of A = %d, want 3", total)
+	}
+
+	tests := []struct {
+		window string
+		want   map[int]float64
+	}{
+		{"all", map[int]float64{a: 3, b: 4}},
+		{"month", map[int]float64{a: 1, b: 4}},
+	}
+	for _, tt := range tests {
+		lb, err := leaderboard(ctx, boardWins, tt.window, 0, 10)
+		if err != nil {
+			t.Fatalf("%s leaderboard: %v", tt.window, err)
+		}
+		if len(lb.Entries) != len(tt.want) {
+			t.Fatalf("%s leaderboard: %+v", tt.window, lb.Entries)
+		}
+		for _, e := range lb.Entries {
+			if e.Value != tt.want[e.UserID] {
+				t.Fatalf("%s leaderboard: user %d has %v wins, want %v", tt.window, e.UserID, e.Value, tt.want[e.UserID])
+			}
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// Политика выхода из комнаты посреди игры. Настраивается владельцем:
+// что делать с песнями ушедшего, с его открытыми ставками и сколько минут
+// держать за ним место, чтобы он мог переподключиться.
+const (
+	leaveSongsKeep     = "keep"
+	leaveSongsWithdraw = "withdraw"
+	leaveBetsRefund    = "refund"
+	leaveBetsForfeit   = "forfeit"
+
+	maxSeatReserveMinutes = 60
+)
+
+type LeavePolicy struct {
+	Songs              string `json:"songs"`
+	Bets               string `json:"bets"`
+	SeatReserveMinutes int    `json:"seatReserveMinutes"`
+}
+
+// LeaveOutcome описывает, что произошло с ушедшим игроком; уходит в событие комнаты.
+type LeaveOutcome struct {
+	UserId         int        `json:"userId"`
+	InGame         bool       `json:"inGame"`
+	WithdrawnSongs []int      `json:"withdrawnSongs,omitempty"`
+	RefundedBets   int        `json:"refundedBets,omitempty"`
+	ForfeitedBets  int        `json:"forfeitedBets,omitempty"`
+	ReservedUntil  *time.Time `json:"reservedUntil,omitempty"`
+	MatchupReset   bool       `json:"matchupReset,omitempty"`
+}
+
+func loadLeavePolicy(ctx context.Context, tx pgx.Tx, roomID int) (LeavePolicy, error) {
+	var p LeavePolicy
+	err := tx.QueryRow(ctx, `
+		SELECT leave_songs, leave_bets, seat_reserve_minutes FROM room WHERE room_id = $1`,
+		roomID).Scan(&p.Songs, &p.Bets, &p.SeatReserveMinutes)
+	if err != nil {
+		return p, fmt.Errorf("load leave policy: %w", err)
+	}
+	return p, nil
+}
+
+// gameInProgress — песни уже отправлены, а победитель ещё не определён.
+func gameInProgress(ctx context.Context, tx pgx.Tx, roomID int) (bool, error) {
+	var total, remaining int
+	err := tx.QueryRow(ctx, `
+        SELECT COUNT(*),
+               COUNT(*) FILTER (WHERE sp.eliminated IS NULL OR sp.eliminated = FALSE)
+          FROM song s
+     LEFT JOIN song_progress sp ON s.song_id = sp.song_id
+         WHERE s.room_id = $1
+    `, roomID).Scan(&total, &remaining)
+	if err != nil {
+		return false, fmt.Errorf("check game state: %w", err)
+	}
+	return total > 0 && !(total >= 2 && remaining <= 1), nil
+}
+
+// applyLeavePolicy снимает песни и закрывает ставки ушедшего игрока по
+// политике комнаты. Если снятая песня играла в текущем матче, матч
+// перезапускается: голоса и расписание раунда сбрасываются.
+func applyLeavePolicy(ctx context.Context, tx pgx.Tx, roomID, userID int, policy LeavePolicy) (*LeaveOutcome, error) {
+	out := &LeaveOutcome{UserId: userID, InGame: true}
+
+	if policy.Songs == leaveSongsWithdraw {
+		// Если у ушедшего остались все живые песни, турнир доигрывается с ними.
+		rows, err := tx.Query(ctx, `
+			SELECT s.song_id
+			  FROM song s
+		 LEFT JOIN song_progress sp ON s.song_id = sp.song_id
+			 WHERE s.room_id = $1 AND s.user_id = $2
+			   AND (sp.eliminated IS NULL OR sp.eliminated = FALSE)
+			   AND EXISTS (
+			        SELECT 1 FROM song o
+			     LEFT JOIN song_progress op ON o.song_id = op.song_id
+			         WHERE o.room_id = $1 AND o.user_id <> $2
+			           AND (op.eliminated IS NULL OR op.eliminated = FALSE))`,
+			roomID, userID)
+		if err != nil {
+			return nil, fmt.Errorf("select leaver songs: %w", err)
+		}
+		for rows.Next() {
+			var id int
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("scan song_id: %w", err)
+			}
+			out.WithdrawnSongs = append(out.WithdrawnSongs, id)
+		}
+		rows.Close()
+	}
+
+	// Открытые ставки — на песни, которые ещё не выбыли. Их сумма лежит в
+	// эскроу комнаты: при возврате уходит игроку, при потере — на house.
+	closed, err := closeBets(ctx, tx, policy.Bets != leaveBetsForfeit, `b.room_id = $1 AND b.user_id = $2
+		AND NOT EXISTS (SELECT 1 FROM song_progress sp WHERE sp.song_id = b.song_id AND sp.eliminated)`,
+		roomID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("close leaver bets: %w", err)
+	}
+	if policy.Bets == leaveBetsForfeit {
+		out.ForfeitedBets = closed
+	} else {
+		out.RefundedBets = closed
+	}
+
+	if len(out.WithdrawnSongs) > 0 {
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO song_progress (song_id, eliminated, round)
+			SELECT id, TRUE, (SELECT current_round FROM room WHERE room_id = $1)
+			  FROM unnest($2::int[]) AS id
+			ON CONFLICT (song_id) DO UPDATE SET eliminated = TRUE, round = EXCLUDED.round`,
+			roomID, out.WithdrawnSongs); err != nil {
+			return nil, fmt.Errorf("withdraw songs: %w", err)
+		}
+		// Ставки других игроков на снятые песни аннулируются с возвратом.
+		if _, err := refundBets(ctx, tx, `b.room_id = $1 AND b.user_id <> $2 AND b.song_id = ANY($3)`,
+			roomID, userID, out.WithdrawnSongs); err != nil {
+			return nil, fmt.Errorf("void bets on withdrawn songs: %w", err)
+		}
+		if err := voidRoundMarkets(ctx, tx, roomID, out.WithdrawnSongs); err != nil {
+			return nil, fmt.Errorf("void markets on withdrawn songs: %w", err)
+		}
+
+		tag, err := tx.Exec(ctx, `
+			UPDATE room SET current_song1 = NULL, current_song2 = NULL
+			 WHERE room_id = $1 AND (current_song1 = ANY($2) OR current_song2 = ANY($2))`,
+			roomID, out.WithdrawnSongs)
+		if err != nil {
+			return nil, fmt.Errorf("reset matchup: %w", err)
+		}
+		if tag.RowsAffected() > 0 {
+			out.MatchupReset = true
+			if _, err := tx.Exec(ctx, `
+				DELETE FROM votes WHERE room_id = $1 AND round = (SELECT current_round FROM room WHERE room_id = $1)`,
+				roomID); err != nil {
+				return nil, fmt.Errorf("reset round votes: %w", err)
+			}
+			if _, err := tx.Exec(ctx, `
+				DELETE FROM playback_schedule WHERE room_id = $1 AND round = (SELECT current_round FROM room WHERE room_id = $1)`,
+				roomID); err != nil {
+				return nil, fmt.Errorf("reset playback schedule: %w", err)
+			}
+		}
+	}
+
+	return out, nil
+}
+
+// reserveSeat оставляет место за ушедшим игроком: он не учитывается в
+// проверках готовности, но может вернуться до reserved_until.
+func reserveSeat(ctx context.Context, tx pgx.Tx, roomID, userID, minutes int) (time.Time, error) {
+	var until time.Time
+	err := tx.QueryRow(ctx, `
+		UPDATE participation
+		   SET left_at = now(), reserved_until = now() + make_interval(mins => $3)
+		 WHERE room_id = $1 AND user_id = $2 AND left_at IS NULL
+		RETURNING reserved_until`, roomID, userID, minutes).Scan(&until)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return until, errNotParticipant
+	}
+	if err != nil {
+		return until, fmt.Errorf("reserve seat: %w", err)
+	}
+	return until, nil
+}
+
+// releaseExpiredSeats удаляет места, которые никто не занял вовремя.
+func releaseExpiredSeats(ctx context.Context, roomID int) error {
+	_, err := db.Exec(ctx, `
+		DELETE FROM participation
+		 WHERE room_id = $1 AND left_at IS NOT NULL AND reserved_until < now()`, roomID)
+	return err
+}
+
+// rejoinReservedSeat возвращает игрока на зарезервированное место. false —
+// места за ним нет.
+func rejoinReservedSeat(ctx context.Context, roomID, userID int) (bool, error) {
+	tag, err := db.Exec(ctx, `
+		UPDATE participation
+		   SET left_at = NULL, reserved_until = NULL
+		 WHERE room_id = $1 AND user_id = $2 AND left_at IS NOT NULL AND reserved_until >= now()`,
+		roomID, userID)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func setLeavePolicyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	callerID, ok := requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	var data struct {
+		RoomId int `json:"roomId"`
+		LeavePolicy
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if data.Songs != leaveSongsKeep && data.Songs != leaveSongsWithdraw {
+		http.Error(w, "songs must be keep or withdraw", http.StatusBadRequest)
+		return
+	}
+	if data.Bets != leaveBetsRefund && data.Bets != leaveBetsForfeit {
+		http.Error(w, "bets must be refund or forfeit", http.StatusBadRequest)
+		return
+	}
+	if data.SeatReserveMinutes < 0 || data.SeatReserveMinutes > maxSeatReserveMinutes {
+		http.Error(w, "seatReserveMinutes must be between 0 and 60", http.StatusBadRequest)
+		return
+	}
+
+	tag, err := db.Exec(r.Context(), `
+		UPDATE room SET leave_songs = $2, leave_bets = $3, seat_reserve_minutes = $4
+		 WHERE room_id = $1 AND owner_id = $5`,
+		data.RoomId, data.Songs, data.Bets, data.SeatReserveMinutes, callerID)
+	if err != nil {
+		log.Println("Error updating leave policy:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		http.Error(w, "Room not found or you are not the owner", http.StatusForbidden)
+		return
+	}
+
+	publishRoomEvent(data.RoomId, "leave_policy_changed", data.LeavePolicy)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(data.LeavePolicy)
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// Монеты двигаются только проводками: каждая операция — строка ledger_txn с
+// причиной и ссылкой на объект и две записи ledger_entry с противоположными
+// суммами, так что сумма по операции всегда ноль. Балансы в "user" и team —
+// кэш суммы записей по счёту, он обновляется в той же транзакции, а аудит
+// сверяет его с журналом. Счёт house — источник стартовых монет и выплат,
+// escrow комнаты держит ставки до расчёта.
+const (
+	accountUser   = "user"
+	accountTeam   = "team"
+	accountHouse  = "house"
+	accountEscrow = "escrow"
+
+	reasonOpeningBalance   = "opening_balance"
+	reasonStartingGrant    = "starting_grant"
+	reasonBetStake         = "bet_stake"
+	reasonBetPayout        = "bet_payout"
+	reasonBetRefund        = "bet_refund"
+	reasonBetForfeit       = "bet_forfeit"
+	reasonBetLoss          = "bet_loss"
+	reasonTeamContribution = "team_contribution"
+	reasonTeamPayout       = "team_payout"
+	reasonTeamRefund       = "team_refund"
+	reasonTeamForfeit      = "team_forfeit"
+	reasonBonus            = "bonus"
+	reasonDailyAllowance   = "daily_allowance"
+	reasonTopUp            = "top_up"
+
+	startingBalance = 1000
+)
+
+var errInvalidAmount = errors.New("amount must be positive")
+
+type Account struct {
+	Kind string `json:"kind"`
+	ID   int    `json:"id,omitempty"`
+}
+
+func userAccount(id int) Account { return Account{Kind: accountUser, ID: id} }
+func teamAccount(id int) Account { return Account{Kind: accountTeam, ID: id} }
+
+var houseAccount = Account{Kind: accountHouse}
+
+func betRef(betID int) string   { return fmt.Sprintf("bet:%d", betID) }
+func teamRef(teamID int) string { return fmt.Sprintf("team:%d", teamID) }
+
+// applyBalance обновляет кэш баланса счёта; у house и escrow кэша нет.
+func applyBalance(ctx context.Context, tx pgx.Tx, acc Account, delta int) error {
+	var err error
+	switch acc.Kind {
+	case accountUser:
+		_, err = tx.Exec(ctx, `UPDATE "user" SET balance = COALESCE(balance, 0) + $1 WHERE user_id = $2`, delta, acc.ID)
+	case accountTeam:
+		_, err = tx.Exec(ctx, `UPDATE team SET balance = balance + $1 WHERE team_id = $2`, delta, acc.ID)
+	case accountHouse, accountEscrow:
+	default:
+		err = fmt.Errorf("unknown account kind %q", acc.Kind)
+	}
+	return err
+}
+
+// transfer проводит amount монет со счёта from на счёт to. Достаточность
+// средств проверяет вызывающий: журнал лишь фиксирует движение.
+func transfer(ctx context.Context, tx pgx.Tx, from, to Account, amount int, reason, ref string) (int64, error) {
+	if amount <= 0 {
+		return 0, errInvalidAmount
+	}
+
+	// Комната и песня операции копируются в журнал по ref: сами ставки
+	// удаляются вместе с комнатой.
+	var txnID int64
+	if err := tx.QueryRow(ctx, `
+		INSERT INTO ledger_txn (reason, ref, room_id, song_id, track_name)
+		SELECT $1, NULLIF($2, ''), COALESCE(b.room_id, mb.room_id, tm.room_id), b.song_id, s.track_name
+		  FROM (SELECT split_part($2, ':', 1) AS kind,
+		               CASE WHEN split_part($2, ':', 2) ~ '^[0-9]+$' THEN split_part($2, ':', 2)::int END AS id) r
+	 LEFT JOIN bets b ON r.kind = 'bet' AND b.bet_id = r.id
+	 LEFT JOIN song s ON s.song_id = b.song_id
+	 LEFT JOIN market_bet mb ON r.kind = 'market_bet' AND mb.bet_id = r.id
+	 LEFT JOIN team tm ON r.kind = 'team' AND tm.team_id = r.id
+		RETURNING txn_id`,
+		reason, ref).Scan(&txnID); err != nil {
+		return 0, fmt.Errorf("insert ledger txn: %w", err)
+	}
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO ledger_entry (txn_id, account_kind, account_id, amount)
+		VALUES ($1, $2, $3, $4), ($1, $5, $6, $7)`,
+		txnID, from.Kind, from.ID, -amount, to.Kind, to.ID, amount); err != nil {
+		return 0, fmt.Errorf("insert ledger entries: %w", err)
+	}
+
+	if err := applyBalance(ctx, tx, from, -amount); err != nil {
+		return 0, fmt.Errorf("debit %s %d: %w", from.Kind, from.ID, err)
+	}
+	if err := applyBalance(ctx, tx, to, amount); err != nil {
+		return 0, fmt.Errorf("credit %s %d: %w", to.Kind, to.ID, err)
+	}
+	return txnID, nil
+}
+
+// grantStartingBalance выдаёт новому пользователю стартовые монеты.
+func grantStartingBalance(ctx context.Context, tx pgx.Tx, userID, amount int) error {
+	_, err := transfer(ctx, tx, houseAccount, userAccount(userID), amount, reasonStartingGrant, "")
+	return err
+}
+
+// createUser заводит пользователя со стартовым балансом и возвращает его id.
+// Id берётся из последовательности; занятые id (их раньше присылал клиент)
+// пропускаются.
+func createUser(ctx context.Context, tx pgx.Tx, name, profilePic string) (int, error) {
+	for attempt := 0; attempt < 10; attempt++ {
+		var userID int
+		err := tx.QueryRow(ctx, `
+			INSERT INTO public.user (user_id, balance, name, profile_pic)
+			VALUES (nextval(pg_get_serial_sequence('public."user"', 'user_id')), 0, $1, $2)
+			ON CONFLICT (user_id) DO NOTHING
+			RETURNING user_id`, name, profilePic).Scan(&userID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return 0, fmt.Errorf("insert user: %w", err)
+		}
+		if err := grantStartingBalance(ctx, tx, userID, startingBalance); err != nil {
+			return 0, err
+		}
+		return userID, nil
+	}
+	return 0, errors.New("insert user: no free user id")
+}
+
+type BalanceDrift struct {
+	Account Account `json:"account"`
+	Cached  int     `json:"cached"`
+	Ledger  int     `json:"ledger"`
+}
+
+type LedgerAudit struct {
+	UnbalancedTxns []int64        `json:"unbalancedTxns"`
+	Drift          []BalanceDrift `json:"drift"`
+}
+
+func (a *LedgerAudit) OK() bool {
+	return len(a.UnbalancedTxns) == 0 && len(a.Drift) == 0
+}
+
+// auditLedger ищет операции с ненулевой суммой и счета, чей кэшированный
+// баланс разошёлся с суммой записей журнала.
+func auditLedger(ctx context.Context) (*LedgerAudit, error) {
+	audit := &LedgerAudit{UnbalancedTxns: []int64{}, Drift: []BalanceDrift{}}
+
+	rows, err := db.Query(ctx, `
+		SELECT t.txn_id
+		  FROM ledger_txn t
+	 LEFT JOIN ledger_entry e ON e.txn_id = t.txn_id
+	  GROUP BY t.txn_id
+		HAVING COALESCE(SUM(e.amount), 0) <> 0 OR COUNT(e.entry_id) < 2
+	  ORDER BY t.txn_id`)
+	if err != nil {
+		return nil, fmt.Errorf("check txns: %w", err)
+	}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan txn: %w", err)
+		}
+		audit.UnbalancedTxns = append(audit.UnbalancedTxns, id)
+	}
+	rows.Close()
+
+	rows, err = db.Query(ctx, `
+		SELECT 'user', u.user_id, COALESCE(u.balance, 0), COALESCE(SUM(e.amount), 0)
+		  FROM "user" u
+	 LEFT JOIN ledger_entry e ON e.account_kind = 'user' AND e.account_id = u.user_id
+	  GROUP BY u.user_id
+		HAVING COALESCE(u.balance, 0) <> COALESCE(SUM(e.amount), 0)
+		 UNION ALL
+		SELECT 'team', t.team_id, t.balance, COALESCE(SUM(e.amount), 0)
+		  FROM team t
+	 LEFT JOIN ledger_entry e ON e.account_kind = 'team' AND e.account_id = t.team_id
+	  GROUP BY t.team_id
+		HAVING t.balance <> COALESCE(SUM(e.amount), 0)
+	  ORDER BY 1, 2`)
+	if err != nil {
+		return nil, fmt.Errorf("check balances: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var d BalanceDrift
+		if err := rows.Scan(&d.Account.Kind, &d.Account.ID, &d.Cached, &d.Ledger); err != nil {
+			return nil, fmt.Errorf("scan drift: %w", err)
+		}
+		audit.Drift = append(audit.Drift, d)
+	}
+	return audit, rows.Err()
+}
+
+// reconcileBalances пересчитывает кэш балансов разошедшихся счетов по журналу.
+func reconcileBalances(ctx context.Context, drift []BalanceDrift) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	for _, d := range drift {
+		if err := applyBalance(ctx, tx, d.Account, d.Ledger-d.Cached); err != nil {
+			return fmt.Errorf("reconcile %s %d: %w", d.Account.Kind, d.Account.ID, err)
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// runLedgerAudit — режим -audit: печатает отчёт, а с -reconcile выравнивает
+// кэш балансов по журналу. Код выхода 1, если журнал не сошёлся.
+func runLedgerAudit(reconcile bool) int {
+	ctx := context.Background()
+	audit, err := auditLedger(ctx)
+	if err != nil {
+		log.Println("Ledger audit failed:", err)
+		return 2
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(audit)
+
+	if audit.OK() {
+		log.Println("Ledger audit: no drift")
+		return 0
+	}
+	log.Printf("Ledger audit: %d unbalanced txns, %d accounts drifted", len(audit.UnbalancedTxns), len(audit.Drift))
+
+	if reconcile && len(audit.Drift) > 0 {
+		if err := reconcileBalances(ctx, audit.Drift); err != nil {
+			log.Println("Reconcile failed:", err)
+			return 2
+		}
+		log.Printf("Reconciled %d accounts", len(audit.Drift))
+	}
+	return 1
+}
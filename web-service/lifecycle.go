@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// Жизненный цикл комнаты: open (лобби) → in_game → finished, а брошенные
+// комнаты переходят в expired. Несостоявшиеся турниры — в cancelled. Фоновый janitor закрывает простаивающие
+// комнаты и через срок хранения архивирует и удаляет их, освобождая ключи.
+const (
+	roomOpen     = "open"
+	roomInGame   = "in_game"
+	roomFinished = "finished"
+	roomExpired  = "expired"
+)
+
+type JanitorReport struct {
+	StartedAt       time.Time     `json:"startedAt"`
+	Duration        time.Duration `json:"durationNs"`
+	ExpiredLobbies  []int         `json:"expiredLobbies"`
+	AbandonedGames  []int         `json:"abandonedGames"`
+	ArchivedRooms   []int         `json:"archivedRooms"`
+	DeletedExpired  []int         `json:"deletedExpired"`
+	DeletedBots     int64         `json:"deletedBots"`
+	DeletedSessions int64         `json:"deletedSessions"`
+	DeletedCodes    int64         `json:"deletedJoinCodes"`
+	DeletedInvites  int64         `json:"deletedInvites"`
+	Errors          []string      `json:"errors,omitempty"`
+}
+
+var (
+	janitorInterval     = getEnvDuration("ROOM_JANITOR_INTERVAL", time.Minute)
+	lobbyIdleTTL        = getEnvDuration("ROOM_LOBBY_IDLE_TTL", 30*time.Minute)
+	gameIdleTTL         = getEnvDuration("ROOM_GAME_IDLE_TTL", 2*time.Hour)
+	finishedRetention   = getEnvDuration("ROOM_FINISHED_RETENTION", 7*24*time.Hour)
+	expiredRetention    = getEnvDuration("ROOM_EXPIRED_RETENTION", 24*time.Hour)
+	lastJanitorReport   *JanitorReport
+	lastJanitorReportMu sync.Mutex
+)
+
+// touchRoom отмечает активность в комнате, чтобы janitor её не закрыл.
+func touchRoom(ctx context.Context, roomID int) {
+	if _, err := db.Exec(ctx, `UPDATE room SET last_activity_at = now() WHERE room_id = $1`, roomID); err != nil {
+		log.Println("Error touching room:", err)
+	}
+}
+
+// setRoomStatus переводит комнату в новое состояние, если она сейчас в одном
+// из from, и рассылает событие. Возвращает false, если перехода не было.
+func setRoomStatus(ctx context.Context, roomID int, status string, from ...string) (bool, error) {
+	tag, err := db.Exec(ctx, `
+		UPDATE room
+		   SET status = $2,
+		       last_activity_at = now(),
+		       finished_at = CASE WHEN $2 = 'finished' THEN now() ELSE finished_at END
+		 WHERE room_id = $1 AND status = ANY($3)`,
+		roomID, status, from)
+	if err != nil {
+		return false, fmt.Errorf("set room status: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return false, nil
+	}
+	publishRoomEvent(roomID, "room_status", map[string]string{"status": status})
+	return true, nil
+}
+
+func roomStatus(ctx context.Context, roomID int) (string, error) {
+	var status string
+	err := db.QueryRow(ctx, `SELECT status FROM room WHERE room_id = $1`, roomID).Scan(&status)
+	return status, err
+}
+
+const idleRoomCondition = `status = $1 AND last_activity_at < now() - make_interval(secs => $2)
+		   AND (scheduled_start_at IS NULL OR scheduled_start_at < now())`
+
+// expireIdleRooms переводит в expired комнаты в статусе status без
+// активности дольше ttl и возвращает их номера.
+func expireIdleRooms(ctx context.Context, status string, ttl time.Duration) ([]int, error) {
+	rows, err := db.Query(ctx, `SELECT room_id FROM room WHERE `+idleRoomCondition, status, ttl.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	var idle []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		idle = append(idle, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var ids []int
+	for _, id := range idle {
+		expired, err := expireRoom(ctx, id, status, ttl)
+		if err != nil {
+			return ids, fmt.Errorf("expire room %d: %w", id, err)
+		}
+		if expired {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// expireRoom в одной транзакции переводит комнату в expired и возвращает
+// ставки из эскроу. Условие простоя проверяется заново: комнату могли
+// оживить между выборкой и переходом.
+func expireRoom(ctx context.Context, roomID int, status string, ttl time.Duration) (bool, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return false, fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback(context.Background())
+
+	tag, err := tx.Exec(ctx, `
+		UPDATE room SET status = 'expired', expired_at = now()
+		 WHERE room_id = $3 AND `+idleRoomCondition, status, ttl.Seconds(), roomID)
+	if err != nil {
+		return false, err
+	}
+	if tag.RowsAffected() == 0 {
+		return false, nil
+	}
+	if err := refundRoomStakes(ctx, tx, roomID); err != nil {
+		return false, err
+	}
+	return true, tx.Commit(ctx)
+}
+
+// deleteRoom удаляет комнату, предварительно вернув ставки из эскроу, —
+// каскадное удаление иначе стёрло бы их вместе с деньгами.
+func deleteRoom(ctx context.Context, roomID int) error {
+	return db.BeginFunc(ctx, func(tx pgx.Tx) error {
+		if err := refundRoomStakes(ctx, tx, roomID); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx, `DELETE FROM room WHERE room_id = $1`, roomID)
+		return err
+	})
+}
+
+// archiveRoom сохраняет итоги завершённой игры в room_archive и удаляет комнату.
+func archiveRoom(ctx context.Context, roomID int) error {
+	standings, err := finalStandings(ctx, roomID)
+	if err != nil {
+		return fmt.Errorf("final standings: %w", err)
+	}
+	if len(standings) > 3 {
+		standings = standings[:3]
+	}
+	results, err := json.Marshal(standings)
+	if err != nil {
+		return fmt.Errorf("encode standings: %w", err)
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback(context.Background())
+
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO room_archive (room_id, owner_id, name, topic, players, created_at, finished_at, results)
+		SELECT r.room_id, r.owner_id, r.name, r.topic,
+		       (SELECT COUNT(*) FROM participation p WHERE p.room_id = r.room_id AND p.role = 'player'),
+		       r.created_at, r.finished_at, $2
+		  FROM room r
+		 WHERE r.room_id = $1`, roomID, results); err != nil {
+		return fmt.Errorf("insert archive: %w", err)
+	}
+	if err := refundRoomStakes(ctx, tx, roomID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM room WHERE room_id = $1`, roomID); err != nil {
+		return fmt.Errorf("delete room: %w", err)
+	}
+	return tx.Commit(ctx)
+}
+
+func roomsOlderThan(ctx context.Context, status, column string, age time.Duration) ([]int, error) {
+	rows, err := db.Query(ctx, fmt.Sprintf(`
+		SELECT room_id FROM room
+		 WHERE status = $1 AND %s < now() - make_interval(secs => $2)`, column),
+		status, age.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return ids, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func runJanitorOnce(ctx context.Context) *JanitorReport {
+	report := &JanitorReport{StartedAt: time.Now()}
+	fail := func(step string, err error) {
+		report.Errors = append(report.Errors, step+": "+err.Error())
+	}
+
+	var err error
+	if report.ExpiredLobbies, err = expireIdleRooms(ctx, roomOpen, lobbyIdleTTL); err != nil {
+		fail("expire lobbies", err)
+	}
+	if report.AbandonedGames, err = expireIdleRooms(ctx, roomInGame, gameIdleTTL); err != nil {
+		fail("expire games", err)
+	}
+	for _, id := range append(append([]int{}, report.ExpiredLobbies...), report.AbandonedGames...) {
+		publishRoomEvent(id, "room_status", map[string]string{"status": roomExpired})
+	}
+
+	finished, err := roomsOlderThan(ctx, roomFinished, "finished_at", finishedRetention)
+	if err != nil {
+		fail("select finished", err)
+	}
+	for _, id := range finished {
+		if err := archiveRoom(ctx, id); err != nil {
+			fail(fmt.Sprintf("archive room %d", id), err)
+			continue
+		}
+		report.ArchivedRooms = append(report.ArchivedRooms, id)
+	}
+
+	expired, err := roomsOlderThan(ctx, roomExpired, "expired_at", expiredRetention)
+	if err != nil {
+		fail("select expired", err)
+	}
+	cancelled, err := roomsOlderThan(ctx, roomCancelled, "cancelled_at", expiredRetention)
+	if err != nil {
+		fail("select cancelled", err)
+	}
+	expired = append(expired, cancelled...)
+	for _, id := range expired {
+		if err := deleteRoom(ctx, id); err != nil {
+			fail(fmt.Sprintf("delete room %d", id), err)
+			continue
+		}
+		report.DeletedExpired = append(report.DeletedExpired, id)
+	}
+
+	tag, err := db.Exec(ctx, `
+		DELETE FROM "user" u
+		 WHERE u.is_bot AND NOT EXISTS (SELECT 1 FROM participation p WHERE p.user_id = u.user_id)`)
+	if err != nil {
+		fail("delete bots", err)
+	} else {
+		report.DeletedBots = tag.RowsAffected()
+	}
+
+	tag, err = db.Exec(ctx, `DELETE FROM session WHERE expires_at < now()`)
+	if err != nil {
+		fail("delete sessions", err)
+	} else {
+		report.DeletedSessions = tag.RowsAffected()
+	}
+
+	tag, err = db.Exec(ctx, `
+		DELETE FROM room_join_code
+		 WHERE COALESCE(revoked_at, expires_at) < now() - make_interval(secs => $1)`, expiredRetention.Seconds())
+	if err != nil {
+		fail("delete join codes", err)
+	} else {
+		report.DeletedCodes = tag.RowsAffected()
+	}
+
+	tag, err = db.Exec(ctx, `
+		DELETE FROM room_invite
+		 WHERE COALESCE(revoked_at, expires_at) < now() - make_interval(secs => $1)`, expiredRetention.Seconds())
+	if err != nil {
+		fail("delete invites", err)
+	} else {
+		report.DeletedInvites = tag.RowsAffected()
+	}
+
+	report.Duration = time.Since(report.StartedAt)
+	return report
+}
+
+func runRoomJanitor() {
+	ticker := time.NewTicker(janitorInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), janitorInterval)
+		report := runJanitorOnce(ctx)
+		cancel()
+
+		lastJanitorReportMu.Lock()
+		lastJanitorReport = report
+		lastJanitorReportMu.Unlock()
+
+		cleaned := len(report.ExpiredLobbies) + len(report.AbandonedGames) + len(report.ArchivedRooms) +
+			len(report.DeletedExpired) + int(report.DeletedBots) + int(report.DeletedSessions) + int(report.DeletedCodes) + int(report.DeletedInvites)
+		if cleaned > 0 || len(report.Errors) > 0 {
+			log.Printf("Janitor: expired %d lobbies and %d games, archived %d, deleted %d expired rooms, %d bots, %d sessions, %d join codes, %d invites, errors: %v",
+				len(report.ExpiredLobbies), len(report.AbandonedGames), len(report.ArchivedRooms),
+				len(report.DeletedExpired), report.DeletedBots, report.DeletedSessions, report.DeletedCodes, report.DeletedInvites, report.Errors)
+		}
+	}
+}
+
+func janitorReportHandler(w http.ResponseWriter, r *http.Request) {
+	lastJanitorReportMu.Lock()
+	report := lastJanitorReport
+	lastJanitorReportMu.Unlock()
+
+	if report == nil {
+		http.Error(w, "Janitor has not run yet", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
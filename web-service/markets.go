@@ -0,0 +1,590 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// Рынки ставок поверх ставок на песню:
+//   - matchup — кто выиграет матч раунда;
+//   - margin — больше или меньше линии будет разница голосов в матче;
+//   - champion — кто выиграет турнир.
+//
+// Рынки раунда открываются, когда выбрана пара матча, и закрываются первым
+// голосом в этом раунде; рынок победителя открывается с первым матчем и
+// закрывается с первым голосом турнира. Каждый рынок рассчитывается сам по
+// себе тотализатором: банк рынка за вычетом маржи делится между угадавшими,
+// а если угадавших нет — ставки возвращаются.
+const (
+	marketMatchup  = "matchup"
+	marketMargin   = "margin"
+	marketChampion = "champion"
+
+	marketOpen    = "open"
+	marketClosed  = "closed"
+	marketSettled = "settled"
+	marketVoid    = "void"
+
+	selectionOver  = "over"
+	selectionUnder = "under"
+)
+
+var (
+	errMarketNotFound   = errors.New("market not found")
+	errMarketClosed     = errors.New("market is closed")
+	errInvalidSelection = errors.New("invalid selection for this market")
+)
+
+type BetMarket struct {
+	MarketID   int        `json:"marketId"`
+	RoomID     int        `json:"roomId"`
+	Kind       string     `json:"kind"`
+	Round      *int       `json:"round,omitempty"`
+	Song1ID    int        `json:"song1Id,omitempty"`
+	Song2ID    int        `json:"song2Id,omitempty"`
+	Line       float64    `json:"line,omitempty"`
+	Status     string     `json:"status"`
+	Selections []string   `json:"selections"`
+	OpenedAt   time.Time  `json:"openedAt"`
+	ClosedAt   *time.Time `json:"closedAt,omitempty"`
+	Result     string     `json:"result,omitempty"`
+}
+
+type MarketBet struct {
+	BetID     int    `json:"betId"`
+	MarketID  int    `json:"marketId"`
+	Selection string `json:"selection"`
+	Stake     int    `json:"stake"`
+	Balance   int    `json:"balance"`
+}
+
+func marketBetRef(betID int) string { return fmt.Sprintf("market_bet:%d", betID) }
+
+// marginLine — линия разницы голосов: около трети голосующих, всегда с
+// половинкой, чтобы не было ничьей.
+func marginLine(voters int) float64 {
+	return float64(voters/3) + 0.5
+}
+
+func marketErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errMarketNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errMarketClosed):
+		return http.StatusConflict
+	case errors.Is(err, errInvalidSelection):
+		return http.StatusBadRequest
+	default:
+		return betErrorStatus(err)
+	}
+}
+
+// openRoundMarkets открывает рынки матча round; при первом матче турнира
+// открывается и рынок победителя. Повторный вызов ничего не делает.
+func openRoundMarkets(ctx context.Context, q queryRower, roomID, round, song1, song2 int) error {
+	var voters int
+	if err := q.QueryRow(ctx, `
+		SELECT COUNT(*) FROM participation p
+		  JOIN room r ON r.room_id = p.room_id
+		 WHERE p.room_id = $1 AND p.left_at IS NULL
+		   AND (p.role = 'player' OR r.audience_vote_weight > 0)`, roomID).Scan(&voters); err != nil {
+		return fmt.Errorf("count voters: %w", err)
+	}
+
+	var opened int
+	err := q.QueryRow(ctx, `
+		WITH inserted AS (
+			INSERT INTO bet_market (room_id, kind, round, song1_id, song2_id, line)
+			VALUES ($1, 'matchup', $2, $3, $4, NULL),
+			       ($1, 'margin', $2, $3, $4, $5)
+			ON CONFLICT DO NOTHING
+			RETURNING market_id
+		), champion AS (
+			INSERT INTO bet_market (room_id, kind)
+			SELECT $1, 'champion'
+			 WHERE NOT EXISTS (SELECT 1 FROM match_settlement WHERE room_id = $1)
+			ON CONFLICT DO NOTHING
+			RETURNING market_id
+		)
+		SELECT (SELECT COUNT(*) FROM inserted) + (SELECT COUNT(*) FROM champion)`,
+		roomID, round, song1, song2, marginLine(voters)).Scan(&opened)
+	if err != nil {
+		return fmt.Errorf("open markets: %w", err)
+	}
+	if opened > 0 {
+		publishRoomEvent(roomID, "markets_opened", map[string]int{"round": round})
+	}
+	return nil
+}
+
+// closeMarkets закрывает приём ставок на рынки раунда и на победителя.
+// Вызывается с первым голосом раунда.
+func closeMarkets(ctx context.Context, roomID, round int) {
+	tag, err := db.Exec(ctx, `
+		UPDATE bet_market SET status = 'closed', closed_at = now()
+		 WHERE room_id = $1 AND status = 'open' AND (round = $2 OR kind = 'champion')`,
+		roomID, round)
+	if err != nil {
+		log.Println("Error closing markets:", err)
+		return
+	}
+	if tag.RowsAffected() > 0 {
+		publishRoomEvent(roomID, "markets_closed", map[string]int{"round": round})
+	}
+}
+
+func scanMarket(row pgx.Row) (*BetMarket, error) {
+	m := &BetMarket{}
+	var song1, song2 *int
+	var line *float64
+	var result *string
+	if err := row.Scan(&m.MarketID, &m.RoomID, &m.Kind, &m.Round, &song1, &song2, &line,
+		&m.Status, &m.OpenedAt, &m.ClosedAt, &result); err != nil {
+		return nil, err
+	}
+	if song1 != nil && song2 != nil {
+		m.Song1ID, m.Song2ID = *song1, *song2
+	}
+	if line != nil {
+		m.Line = *line
+	}
+	if result != nil {
+		m.Result = *result
+	}
+	return m, nil
+}
+
+const marketColumns = `market_id, room_id, kind, round, song1_id, song2_id, line::float8,
+	status, opened_at, closed_at, result`
+
+// marketSelections перечисляет допустимые исходы рынка.
+func marketSelections(ctx context.Context, q queryRower, m *BetMarket) ([]string, error) {
+	switch m.Kind {
+	case marketMatchup:
+		return []string{strconv.Itoa(m.Song1ID), strconv.Itoa(m.Song2ID)}, nil
+	case marketMargin:
+		return []string{selectionOver, selectionUnder}, nil
+	case marketChampion:
+		var ids []int
+		if err := q.QueryRow(ctx, `
+			SELECT COALESCE(array_agg(s.song_id ORDER BY s.song_id), '{}')
+			  FROM song s
+		 LEFT JOIN song_progress sp ON s.song_id = sp.song_id
+			 WHERE s.room_id = $1 AND (sp.eliminated IS NULL OR sp.eliminated = FALSE)`,
+			m.RoomID).Scan(&ids); err != nil {
+			return nil, fmt.Errorf("list champion selections: %w", err)
+		}
+		out := make([]string, len(ids))
+		for i, id := range ids {
+			out[i] = strconv.Itoa(id)
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("unknown market kind %q", m.Kind)
+}
+
+func validSelection(selections []string, selection string) bool {
+	for _, s := range selections {
+		if s == selection {
+			return true
+		}
+	}
+	return false
+}
+
+// placeMarketBet принимает ставку на открытый рынок по тем же правилам, что
+// и ставки на песни: блокировка счёта, лимиты комнаты, эскроу.
+func placeMarketBet(ctx context.Context, tx pgx.Tx, userID, marketID int, selection string, amount int) (*MarketBet, error) {
+	m, err := scanMarket(tx.QueryRow(ctx, `
+		SELECT `+marketColumns+` FROM bet_market WHERE market_id = $1 FOR SHARE`, marketID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errMarketNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load market: %w", err)
+	}
+	if m.Status != marketOpen {
+		return nil, errMarketClosed
+	}
+	selections, err := marketSelections(ctx, tx, m)
+	if err != nil {
+		return nil, err
+	}
+	if !validSelection(selections, selection) {
+		return nil, errInvalidSelection
+	}
+
+	from, balance, err := lockStakeAccount(ctx, tx, m.RoomID, userID)
+	if err != nil {
+		return nil, err
+	}
+	var minBet int
+	var maxBet *int
+	if err := tx.QueryRow(ctx, `SELECT min_bet, max_bet FROM room WHERE room_id = $1`, m.RoomID).Scan(&minBet, &maxBet); err != nil {
+		return nil, fmt.Errorf("fetch bet limits: %w", err)
+	}
+	switch {
+	case amount <= 0:
+		return nil, errInvalidAmount
+	case amount < minBet:
+		return nil, errBetTooSmall
+	case maxBet != nil && amount > *maxBet:
+		return nil, errBetTooLarge
+	case amount > balance:
+		return nil, errInsufficientFunds
+	}
+
+	bet := &MarketBet{MarketID: marketID, Selection: selection, Stake: amount, Balance: balance - amount}
+	var teamID *int
+	if from.Kind == accountTeam {
+		teamID = &from.ID
+	}
+	if err := tx.QueryRow(ctx, `
+		INSERT INTO market_bet (market_id, room_id, user_id, team_id, selection, stake)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING bet_id`,
+		marketID, m.RoomID, userID, teamID, selection, amount).Scan(&bet.BetID); err != nil {
+		return nil, fmt.Errorf("insert market bet: %w", err)
+	}
+	if _, err := transfer(ctx, tx, from, escrowAccount(m.RoomID), amount, reasonBetStake, marketBetRef(bet.BetID)); err != nil {
+		return nil, fmt.Errorf("stake market bet: %w", err)
+	}
+	return bet, nil
+}
+
+// settleMarket рассчитывает рынок тотализатором по исходу result.
+func settleMarket(ctx context.Context, tx pgx.Tx, marketID int, result string) error {
+	var roomID int
+	var status string
+	if err := tx.QueryRow(ctx, `
+		SELECT room_id, status FROM bet_market WHERE market_id = $1 FOR UPDATE`,
+		marketID).Scan(&roomID, &status); err != nil {
+		return fmt.Errorf("lock market: %w", err)
+	}
+	if status == marketSettled || status == marketVoid {
+		return nil
+	}
+
+	var pool, onResult int
+	if err := tx.QueryRow(ctx, `
+		SELECT COALESCE(SUM(stake), 0), COALESCE(SUM(stake) FILTER (WHERE selection = $2), 0)
+		  FROM market_bet WHERE market_id = $1 AND settled_at IS NULL`, marketID, result).Scan(&pool, &onResult); err != nil {
+		return fmt.Errorf("sum market pool: %w", err)
+	}
+	// Угадавших нет — рынок аннулируется с возвратом ставок.
+	if onResult == 0 {
+		return voidMarket(ctx, tx, marketID)
+	}
+
+	model := parimutuel{marginPercent: houseMarginPercent}
+	err := forEachMarketBet(ctx, tx, marketID, func(b marketBetRow) (int, error) {
+		payout := 0
+		if b.selection == result {
+			payout = model.Payout(BetOutcome{Stake: b.stake, Champion: true, Pool: pool, OnWinner: onResult})
+		}
+		return payout, payBet(ctx, tx, roomID, marketBetRef(b.betID), b.owner, b.stake, payout)
+	})
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(ctx, `
+		UPDATE bet_market
+		   SET status = 'settled', result = $2, settled_at = now(), closed_at = COALESCE(closed_at, now())
+		 WHERE market_id = $1`, marketID, result); err != nil {
+		return fmt.Errorf("close market: %w", err)
+	}
+	return nil
+}
+
+// voidMarket аннулирует рынок и возвращает все его ставки.
+func voidMarket(ctx context.Context, tx pgx.Tx, marketID int) error {
+	var roomID int
+	if err := tx.QueryRow(ctx, `SELECT room_id FROM bet_market WHERE market_id = $1`, marketID).Scan(&roomID); err != nil {
+		return fmt.Errorf("load market: %w", err)
+	}
+	err := forEachMarketBet(ctx, tx, marketID, func(b marketBetRow) (int, error) {
+		_, err := transfer(ctx, tx, escrowAccount(roomID), b.owner, b.stake, reasonBetRefund, marketBetRef(b.betID))
+		return b.stake, err
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE bet_market SET status = 'void', settled_at = now(), closed_at = COALESCE(closed_at, now())
+		 WHERE market_id = $1`, marketID); err != nil {
+		return fmt.Errorf("void market: %w", err)
+	}
+	return nil
+}
+
+type marketBetRow struct {
+	betID, stake int
+	selection    string
+	owner        Account
+}
+
+// forEachMarketBet проходит по нерассчитанным ставкам рынка и записывает
+// выплату, которую вернул fn.
+func forEachMarketBet(ctx context.Context, tx pgx.Tx, marketID int, fn func(marketBetRow) (int, error)) error {
+	rows, err := tx.Query(ctx, `
+		SELECT bet_id, user_id, team_id, selection, stake
+		  FROM market_bet
+		 WHERE market_id = $1 AND settled_at IS NULL
+	  ORDER BY bet_id
+		   FOR UPDATE`, marketID)
+	if err != nil {
+		return fmt.Errorf("query market bets: %w", err)
+	}
+	var bets []marketBetRow
+	for rows.Next() {
+		var b marketBetRow
+		var userID int
+		var teamID *int
+		if err := rows.Scan(&b.betID, &userID, &teamID, &b.selection, &b.stake); err != nil {
+			rows.Close()
+			return fmt.Errorf("scan market bet: %w", err)
+		}
+		b.owner = userAccount(userID)
+		if teamID != nil {
+			b.owner = teamAccount(*teamID)
+		}
+		bets = append(bets, b)
+	}
+	rows.Close()
+
+	for _, b := range bets {
+		payout, err := fn(b)
+		if err != nil {
+			return fmt.Errorf("settle market bet %d: %w", b.betID, err)
+		}
+		if _, err := tx.Exec(ctx, `
+			UPDATE market_bet SET settled_at = now(), payout = $2 WHERE bet_id = $1`, b.betID, payout); err != nil {
+			return fmt.Errorf("mark market bet %d: %w", b.betID, err)
+		}
+	}
+	return nil
+}
+
+// settleRoundMarkets рассчитывает рынки матча, а после финала — рынок победителя.
+func settleRoundMarkets(ctx context.Context, tx pgx.Tx, s *MatchSettlement) error {
+	rows, err := tx.Query(ctx, `
+		SELECT market_id, kind, line::float8 FROM bet_market
+		 WHERE room_id = $1 AND status IN ('open', 'closed')
+		   AND ((round = $2 AND song1_id = $3 AND song2_id = $4) OR (kind = 'champion' AND $5))`,
+		s.RoomID, s.Round, s.Song1ID, s.Song2ID, s.Finished)
+	if err != nil {
+		return fmt.Errorf("query round markets: %w", err)
+	}
+	type pending struct {
+		id     int
+		result string
+	}
+	var markets []pending
+	for rows.Next() {
+		var id int
+		var kind string
+		var line *float64
+		if err := rows.Scan(&id, &kind, &line); err != nil {
+			rows.Close()
+			return fmt.Errorf("scan market: %w", err)
+		}
+		p := pending{id: id, result: strconv.Itoa(s.WinnerSongID)}
+		if kind == marketMargin && line != nil {
+			margin := s.Votes1 - s.Votes2
+			if margin < 0 {
+				margin = -margin
+			}
+			p.result = selectionUnder
+			if margin > *line {
+				p.result = selectionOver
+			}
+		}
+		markets = append(markets, p)
+	}
+	rows.Close()
+
+	for _, m := range markets {
+		if err := settleMarket(ctx, tx, m.id, m.result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// voidRoundMarkets аннулирует рынки сброшенного матча, а ставки на победителя
+// за снятые песни возвращает.
+func voidRoundMarkets(ctx context.Context, tx pgx.Tx, roomID int, withdrawn []int) error {
+	rows, err := tx.Query(ctx, `
+		SELECT market_id FROM bet_market
+		 WHERE room_id = $1 AND status IN ('open', 'closed') AND kind <> 'champion'
+		   AND (song1_id = ANY($2) OR song2_id = ANY($2))`, roomID, withdrawn)
+	if err != nil {
+		return fmt.Errorf("query markets to void: %w", err)
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return fmt.Errorf("scan market: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	for _, id := range ids {
+		if err := voidMarket(ctx, tx, id); err != nil {
+			return err
+		}
+	}
+
+	selections := make([]string, len(withdrawn))
+	for i, id := range withdrawn {
+		selections[i] = strconv.Itoa(id)
+	}
+	rows, err = tx.Query(ctx, `
+		UPDATE market_bet b SET settled_at = now(), payout = b.stake
+		  FROM bet_market m
+		 WHERE m.market_id = b.market_id AND m.room_id = $1 AND m.kind = 'champion'
+		   AND b.settled_at IS NULL AND b.selection = ANY($2)
+		RETURNING b.bet_id, b.user_id, b.team_id, b.stake`, roomID, selections)
+	if err != nil {
+		return fmt.Errorf("refund champion bets: %w", err)
+	}
+	var refunds []marketBetRow
+	for rows.Next() {
+		var b marketBetRow
+		var userID int
+		var teamID *int
+		if err := rows.Scan(&b.betID, &userID, &teamID, &b.stake); err != nil {
+			rows.Close()
+			return fmt.Errorf("scan champion bet: %w", err)
+		}
+		b.owner = userAccount(userID)
+		if teamID != nil {
+			b.owner = teamAccount(*teamID)
+		}
+		refunds = append(refunds, b)
+	}
+	rows.Close()
+	for _, b := range refunds {
+		if _, err := transfer(ctx, tx, escrowAccount(roomID), b.owner, b.stake, reasonBetRefund, marketBetRef(b.betID)); err != nil {
+			return fmt.Errorf("refund champion bet %d: %w", b.betID, err)
+		}
+	}
+	return nil
+}
+
+func listMarketsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	roomID, err := strconv.Atoi(r.URL.Query().Get("roomId"))
+	if err != nil {
+		http.Error(w, "roomId is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	rows, err := db.Query(ctx, `
+		SELECT `+marketColumns+` FROM bet_market
+		 WHERE room_id = $1 AND status <> 'void'
+		   AND ($2 OR status IN ('open', 'closed'))
+	  ORDER BY market_id`, roomID, r.URL.Query().Get("all") == "true")
+	if err != nil {
+		log.Println("Error listing markets:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	markets := []*BetMarket{}
+	for rows.Next() {
+		m, err := scanMarket(rows)
+		if err != nil {
+			log.Println("Error scanning market:", err)
+			continue
+		}
+		markets = append(markets, m)
+	}
+	rows.Close()
+
+	for _, m := range markets {
+		if m.Selections, err = marketSelections(ctx, db, m); err != nil {
+			log.Println("Error listing selections:", err)
+			m.Selections = []string{}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(markets)
+}
+
+func placeMarketBetHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	var data struct {
+		MarketId  int    `json:"marketId"`
+		Selection string `json:"selection"`
+		Amount    int    `json:"amount"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	var roomID int
+	if err := db.QueryRow(r.Context(), `SELECT room_id FROM bet_market WHERE market_id = $1`, data.MarketId).Scan(&roomID); err != nil {
+		http.Error(w, errMarketNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	if !requirePlayer(w, r.Context(), roomID, userID) {
+		return
+	}
+
+	ctx := r.Context()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		log.Println("Error starting transaction:", err)
+		http.Error(w, "Database transaction error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(context.Background())
+
+	bet, err := placeMarketBet(ctx, tx, userID, data.MarketId, data.Selection, data.Amount)
+	if err != nil {
+		if status := marketErrorStatus(err); status != http.StatusInternalServerError {
+			http.Error(w, err.Error(), status)
+			return
+		}
+		log.Println("Error placing market bet:", err)
+		http.Error(w, "Failed to place bet", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		log.Println("Error committing market bet:", err)
+		http.Error(w, "Failed to place bet", http.StatusInternalServerError)
+		return
+	}
+
+	touchRoom(ctx, roomID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(bet)
+}
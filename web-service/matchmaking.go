@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// Публичные комнаты видны в общем списке /rooms/public, а быстрая игра
+// сажает игрока в подходящее открытое лобби или создаёт новое и запускает
+// его, как только набирается минимум игроков.
+
+type PublicRoom struct {
+	RoomID     int       `json:"roomId"`
+	Name       string    `json:"name"`
+	Topic      string    `json:"topic,omitempty"`
+	Language   string    `json:"language,omitempty"`
+	Players    int       `json:"players"`
+	MaxPlayers int       `json:"maxPlayers"`
+	QuickPlay  bool      `json:"quickPlay"`
+	JoinCode   string    `json:"joinCode"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+type PublicRoomPage struct {
+	Rooms      []PublicRoom `json:"rooms"`
+	NextOffset int          `json:"nextOffset,omitempty"`
+}
+
+type QuickPlayResult struct {
+	RoomID   int    `json:"roomId"`
+	JoinCode string `json:"joinCode"`
+	Created  bool   `json:"created"`
+	Started  bool   `json:"started"`
+	Players  int    `json:"players"`
+}
+
+var quickPlayMinPlayers = getEnvInt("QUICKPLAY_MIN_PLAYERS", minPlayers)
+
+func normalizeLanguage(lang string) string {
+	return strings.ToLower(strings.TrimSpace(lang))
+}
+
+func setRoomVisibilityHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	callerID, ok := requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	var data struct {
+		RoomId   int    `json:"roomId"`
+		Public   bool   `json:"public"`
+		Topic    string `json:"topic"`
+		Language string `json:"language"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if data.Topic != "" && !isGameTopic(data.Topic) {
+		http.Error(w, "Unknown topic", http.StatusBadRequest)
+		return
+	}
+
+	tag, err := db.Exec(r.Context(), `
+		UPDATE room
+		   SET is_public = $2,
+		       topic = COALESCE(NULLIF($3, ''), topic),
+		       language = NULLIF($4, '')
+		 WHERE room_id = $1 AND owner_id = $5 AND status = 'open'`,
+		data.RoomId, data.Public, data.Topic, normalizeLanguage(data.Language), callerID)
+	if err != nil {
+		log.Println("Error updating room visibility:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		http.Error(w, "Room not found, already started, or you are not the owner", http.StatusForbidden)
+		return
+	}
+
+	publishRoomEvent(data.RoomId, "room_visibility", map[string]interface{}{
+		"public":   data.Public,
+		"topic":    data.Topic,
+		"language": normalizeLanguage(data.Language),
+	})
+	w.WriteHeader(http.StatusOK)
+}
+
+func listPublicRoomsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	limit, err := strconv.Atoi(q.Get("limit"))
+	if err != nil || limit <= 0 || limit > 50 {
+		limit = 20
+	}
+	offset, err := strconv.Atoi(q.Get("offset"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	rows, err := db.Query(r.Context(), `
+		SELECT r.room_id, r.name, COALESCE(r.topic, ''), COALESCE(r.language, ''),
+		       COUNT(p.user_id) FILTER (WHERE p.role = 'player'),
+		       r.quick_play, COALESCE(c.code, ''), r.created_at
+		  FROM room r
+	 LEFT JOIN participation p ON p.room_id = r.room_id
+	 LEFT JOIN room_join_code c ON c.room_id = r.room_id AND c.revoked_at IS NULL AND c.expires_at > now()
+		 WHERE r.is_public AND r.status = 'open'
+		   AND ($1 = '' OR r.topic = $1 OR r.topic IS NULL)
+		   AND ($2 = '' OR r.language = $2)
+	  GROUP BY r.room_id, c.code
+		HAVING COUNT(p.user_id) FILTER (WHERE p.role = 'player') < $3
+	  ORDER BY COUNT(p.user_id) FILTER (WHERE p.role = 'player') DESC, r.created_at DESC, r.room_id
+		 LIMIT $4 OFFSET $5`,
+		q.Get("topic"), normalizeLanguage(q.Get("language")), maxPlayers, limit+1, offset)
+	if err != nil {
+		log.Println("Error listing public rooms:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	page := PublicRoomPage{Rooms: []PublicRoom{}}
+	for rows.Next() {
+		room := PublicRoom{MaxPlayers: maxPlayers}
+		if err := rows.Scan(&room.RoomID, &room.Name, &room.Topic, &room.Language, &room.Players,
+			&room.QuickPlay, &room.JoinCode, &room.CreatedAt); err != nil {
+			log.Println("Error scanning public room:", err)
+			continue
+		}
+		page.Rooms = append(page.Rooms, room)
+	}
+	if len(page.Rooms) > limit {
+		page.Rooms = page.Rooms[:limit]
+		page.NextOffset = offset + limit
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// findQuickPlayLobby ищет открытое публичное лобби быстрой игры со свободным
+// местом и блокирует его; из подходящих выбирается самое заполненное.
+func findQuickPlayLobby(ctx context.Context, tx pgx.Tx, userID int, topic, language string) (int, error) {
+	var roomID int
+	err := tx.QueryRow(ctx, `
+		SELECT r.room_id
+		  FROM room r
+		 WHERE r.quick_play AND r.is_public AND r.status = 'open'
+		   AND ($1 = '' OR r.topic = $1)
+		   AND ($2 = '' OR r.language = $2)
+		   AND NOT EXISTS (SELECT 1 FROM room_ban b WHERE b.room_id = r.room_id AND b.user_id = $3)
+		   AND NOT EXISTS (SELECT 1 FROM participation p WHERE p.room_id = r.room_id AND p.user_id = $3)
+		   AND (SELECT COUNT(*) FROM participation p WHERE p.room_id = r.room_id AND p.role = 'player') < $4
+		 ORDER BY (SELECT COUNT(*) FROM participation p WHERE p.room_id = r.room_id AND p.role = 'player') DESC,
+		          r.created_at
+		 LIMIT 1
+		   FOR UPDATE SKIP LOCKED`,
+		topic, language, userID, maxPlayers).Scan(&roomID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("find lobby: %w", err)
+	}
+	return roomID, nil
+}
+
+func quickPlayHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	var data struct {
+		Topic    string `json:"topic"`
+		Language string `json:"language"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if data.Topic != "" && !isGameTopic(data.Topic) {
+		http.Error(w, "Unknown topic", http.StatusBadRequest)
+		return
+	}
+	language := normalizeLanguage(data.Language)
+
+	ctx := r.Context()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		log.Println("Error starting transaction:", err)
+		http.Error(w, "Database transaction error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(context.Background())
+
+	result := QuickPlayResult{}
+	result.RoomID, err = findQuickPlayLobby(ctx, tx, userID, data.Topic, language)
+	if err != nil {
+		log.Println("Error finding quick play lobby:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	if result.RoomID == 0 {
+		if result.RoomID, err = newRoomID(ctx); err == nil {
+			_, err = tx.Exec(ctx, `
+				INSERT INTO room (room_id, owner_id, name, topic, language, is_public, quick_play)
+				VALUES ($1, $2, 'Quick play', NULLIF($3, ''), NULLIF($4, ''), TRUE, TRUE)`,
+				result.RoomID, userID, data.Topic, language)
+		}
+		if err != nil {
+			log.Println("Error creating quick play room:", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		result.Created = true
+	}
+
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO participation (user_id, room_id, role) VALUES ($1, $2, 'player')`,
+		userID, result.RoomID); err != nil {
+		log.Println("Error joining quick play room:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	var code string
+	err = tx.QueryRow(ctx, `
+		SELECT code FROM room_join_code
+		 WHERE room_id = $1 AND revoked_at IS NULL AND expires_at > now()
+		 LIMIT 1`, result.RoomID).Scan(&code)
+	if errors.Is(err, pgx.ErrNoRows) {
+		var jc *JoinCode
+		if jc, err = issueJoinCode(ctx, tx, result.RoomID); err == nil {
+			code = jc.Code
+		}
+	}
+	if err != nil {
+		log.Println("Error fetching join code:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	result.JoinCode = code
+
+	if err := tx.QueryRow(ctx, `
+		SELECT COUNT(*) FROM participation WHERE room_id = $1 AND role = 'player'`,
+		result.RoomID).Scan(&result.Players); err != nil {
+		log.Println("Error counting players:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		log.Println("Error committing transaction:", err)
+		http.Error(w, "Error committing transaction", http.StatusInternalServerError)
+		return
+	}
+	touchRoom(ctx, result.RoomID)
+
+	publishRoomEvent(result.RoomID, "user_joined", map[string]interface{}{"userId": userID, "quickPlay": true})
+
+	if result.Players >= quickPlayMinPlayers {
+		err := startGame(ctx, result.RoomID)
+		switch {
+		case err == nil:
+			result.Started = true
+			publishRoomEvent(result.RoomID, "game_started", map[string]int{"players": result.Players})
+		case errors.Is(err, errRoomClosed), errors.Is(err, errNotEnoughPlayers):
+		default:
+			log.Println("Error auto-starting quick play room:", err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,467 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// Модерация комнаты: выгнать и забанить участника может только владелец,
+// действующий от своей сессии. Если владелец уходит, комната переходит
+// к игроку, который дольше всех в ней находится.
+
+var (
+	errNotOwner     = errors.New("only the owner can do this")
+	errRoomNotFound = errors.New("room not found")
+)
+
+type RoomBan struct {
+	UserId    int       `json:"userId"`
+	Name      string    `json:"name"`
+	BannedBy  int       `json:"bannedBy"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func moderationErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errRoomNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errNotOwner):
+		return http.StatusForbidden
+	case errors.Is(err, errNotParticipant):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// lockRoomOwner блокирует строку комнаты до конца транзакции и возвращает владельца.
+func lockRoomOwner(ctx context.Context, tx pgx.Tx, roomID int) (int, error) {
+	var ownerID *int
+	err := tx.QueryRow(ctx, `SELECT owner_id FROM room WHERE room_id = $1 FOR UPDATE`, roomID).Scan(&ownerID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, errRoomNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("lock room: %w", err)
+	}
+	if ownerID == nil {
+		return 0, nil
+	}
+	return *ownerID, nil
+}
+
+// nextOwner выбирает нового владельца: сначала люди (игроки раньше зрителей),
+// потом боты, среди равных — кто раньше зашёл. 0 — в комнате никого не осталось.
+func nextOwner(ctx context.Context, tx pgx.Tx, roomID int) (int, error) {
+	var userID int
+	err := tx.QueryRow(ctx, `
+		SELECT p.user_id
+		  FROM participation p
+		  JOIN "user" u ON u.user_id = p.user_id
+		 WHERE p.room_id = $1 AND p.left_at IS NULL
+		 ORDER BY u.is_bot, p.role = 'player' DESC, p.joined_at, p.user_id
+		 LIMIT 1`, roomID).Scan(&userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("pick next owner: %w", err)
+	}
+	return userID, nil
+}
+
+// removeParticipant удаляет участника из комнаты и, если это был владелец,
+// передаёт комнату следующему. Во время игры к ушедшему применяется политика
+// выхода комнаты, а при добровольном выходе за ним резервируется место.
+// Возвращает нового владельца (0, если владелец не менялся) и итог выхода.
+func removeParticipant(ctx context.Context, tx pgx.Tx, roomID, userID int, voluntary bool) (int, *LeaveOutcome, error) {
+	ownerID, err := lockRoomOwner(ctx, tx, roomID)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var active bool
+	err = tx.QueryRow(ctx, `
+		SELECT left_at IS NULL FROM participation WHERE room_id = $1 AND user_id = $2 FOR UPDATE`,
+		roomID, userID).Scan(&active)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, nil, errNotParticipant
+	}
+	if err != nil {
+		return 0, nil, fmt.Errorf("lock participation: %w", err)
+	}
+
+	outcome := &LeaveOutcome{UserId: userID}
+	inGame, err := gameInProgress(ctx, tx, roomID)
+	if err != nil {
+		return 0, nil, err
+	}
+	if inGame && active {
+		policy, err := loadLeavePolicy(ctx, tx, roomID)
+		if err != nil {
+			return 0, nil, err
+		}
+		if outcome, err = applyLeavePolicy(ctx, tx, roomID, userID, policy); err != nil {
+			return 0, nil, err
+		}
+		if voluntary && policy.SeatReserveMinutes > 0 {
+			until, err := reserveSeat(ctx, tx, roomID, userID, policy.SeatReserveMinutes)
+			if err != nil {
+				return 0, nil, err
+			}
+			outcome.ReservedUntil = &until
+		}
+	}
+
+	if outcome.ReservedUntil == nil {
+		if _, err := tx.Exec(ctx, `DELETE FROM participation WHERE room_id = $1 AND user_id = $2`, roomID, userID); err != nil {
+			return 0, nil, fmt.Errorf("delete participation: %w", err)
+		}
+	}
+	if userID != ownerID {
+		return 0, outcome, nil
+	}
+
+	newOwner, err := nextOwner(ctx, tx, roomID)
+	if err != nil {
+		return 0, nil, err
+	}
+	if _, err := tx.Exec(ctx, `UPDATE room SET owner_id = NULLIF($2, 0) WHERE room_id = $1`, roomID, newOwner); err != nil {
+		return 0, nil, fmt.Errorf("transfer ownership: %w", err)
+	}
+	return newOwner, outcome, nil
+}
+
+func publishOwnerChanged(roomID, oldOwner, newOwner int, reason string) {
+	publishRoomEvent(roomID, "owner_changed", map[string]interface{}{
+		"previousOwnerId": oldOwner,
+		"ownerId":         newOwner,
+		"reason":          reason,
+	})
+}
+
+// removeUserFromRoomHandler: участник может выйти сам, выгнать другого может только владелец.
+func removeUserFromRoomHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "Invalid method, use POST or DELETE", http.StatusMethodNotAllowed)
+		return
+	}
+
+	callerID, ok := requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	roomID, err1 := strconv.Atoi(r.URL.Query().Get("roomId"))
+	userID, err2 := strconv.Atoi(r.URL.Query().Get("userId"))
+	if err1 != nil || err2 != nil {
+		http.Error(w, "roomId and userId are required", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		log.Println("Error starting transaction:", err)
+		http.Error(w, "Database transaction error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(context.Background())
+
+	ownerID, err := lockRoomOwner(ctx, tx, roomID)
+	if err != nil {
+		http.Error(w, err.Error(), moderationErrorStatus(err))
+		return
+	}
+	if callerID != userID && callerID != ownerID {
+		http.Error(w, "Only the owner can remove other participants", http.StatusForbidden)
+		return
+	}
+
+	newOwner, outcome, err := removeParticipant(ctx, tx, roomID, userID, callerID == userID)
+	if err != nil {
+		if moderationErrorStatus(err) == http.StatusInternalServerError {
+			log.Printf("removeUserFromRoom error: %v", err)
+		}
+		http.Error(w, "Failed to remove user from room", moderationErrorStatus(err))
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		log.Println("Error committing transaction:", err)
+		http.Error(w, "Error committing transaction", http.StatusInternalServerError)
+		return
+	}
+
+	if callerID == userID {
+		publishRoomEvent(roomID, "user_left", outcome)
+	} else {
+		publishRoomEvent(roomID, "user_kicked", map[string]interface{}{"userId": userID, "byUserId": callerID, "outcome": outcome})
+	}
+	if newOwner != 0 {
+		publishOwnerChanged(roomID, ownerID, newOwner, "owner_left")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "removed", "ownerId": newOwner, "outcome": outcome})
+}
+
+func banUserHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	callerID, ok := requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	var data struct {
+		RoomId int    `json:"roomId"`
+		UserId int    `json:"userId"`
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if data.UserId == callerID {
+		http.Error(w, "You cannot ban yourself", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		log.Println("Error starting transaction:", err)
+		http.Error(w, "Database transaction error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(context.Background())
+
+	ownerID, err := lockRoomOwner(ctx, tx, data.RoomId)
+	if err == nil && ownerID != callerID {
+		err = errNotOwner
+	}
+	if err != nil {
+		if moderationErrorStatus(err) == http.StatusInternalServerError {
+			log.Println("Error banning user:", err)
+		}
+		http.Error(w, err.Error(), moderationErrorStatus(err))
+		return
+	}
+
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO room_ban (room_id, user_id, banned_by, reason)
+		VALUES ($1, $2, $3, NULLIF($4, ''))
+		ON CONFLICT (room_id, user_id) DO UPDATE
+		   SET banned_by = EXCLUDED.banned_by, reason = EXCLUDED.reason, created_at = now()`,
+		data.RoomId, data.UserId, callerID, data.Reason); err != nil {
+		log.Println("Error inserting ban:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	wasInRoom := true
+	if _, _, err := removeParticipant(ctx, tx, data.RoomId, data.UserId, false); errors.Is(err, errNotParticipant) {
+		wasInRoom = false
+	} else if err != nil {
+		log.Println("Error removing banned user:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		log.Println("Error committing transaction:", err)
+		http.Error(w, "Error committing transaction", http.StatusInternalServerError)
+		return
+	}
+
+	publishRoomEvent(data.RoomId, "user_banned", map[string]interface{}{
+		"userId":    data.UserId,
+		"byUserId":  callerID,
+		"wasInRoom": wasInRoom,
+	})
+	w.WriteHeader(http.StatusOK)
+}
+
+func unbanUserHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	callerID, ok := requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	var data struct {
+		RoomId int `json:"roomId"`
+		UserId int `json:"userId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	tag, err := db.Exec(r.Context(), `
+		DELETE FROM room_ban b
+		 USING room r
+		 WHERE b.room_id = r.room_id AND b.room_id = $1 AND b.user_id = $2 AND r.owner_id = $3`,
+		data.RoomId, data.UserId, callerID)
+	if err != nil {
+		log.Println("Error deleting ban:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		http.Error(w, "Ban not found or you are not the owner", http.StatusNotFound)
+		return
+	}
+
+	publishRoomEvent(data.RoomId, "user_unbanned", map[string]int{"userId": data.UserId, "byUserId": callerID})
+	w.WriteHeader(http.StatusOK)
+}
+
+func listBansHandler(w http.ResponseWriter, r *http.Request) {
+	callerID, ok := requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	roomID, err := strconv.Atoi(r.URL.Query().Get("roomId"))
+	if err != nil {
+		http.Error(w, "roomId is required", http.StatusBadRequest)
+		return
+	}
+
+	var ownerID *int
+	err = db.QueryRow(r.Context(), "SELECT owner_id FROM room WHERE room_id = $1", roomID).Scan(&ownerID)
+	if err != nil {
+		http.Error(w, "Room not found", http.StatusNotFound)
+		return
+	}
+	if ownerID == nil || *ownerID != callerID {
+		http.Error(w, "Only the owner can see the ban list", http.StatusForbidden)
+		return
+	}
+
+	rows, err := db.Query(r.Context(), `
+		SELECT b.user_id, COALESCE(u.name, ''), b.banned_by, COALESCE(b.reason, ''), b.created_at
+		  FROM room_ban b
+	 LEFT JOIN "user" u ON u.user_id = b.user_id
+		 WHERE b.room_id = $1
+		 ORDER BY b.created_at DESC`, roomID)
+	if err != nil {
+		log.Println("Error fetching bans:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	bans := []RoomBan{}
+	for rows.Next() {
+		var b RoomBan
+		if err := rows.Scan(&b.UserId, &b.Name, &b.BannedBy, &b.Reason, &b.CreatedAt); err != nil {
+			log.Println("Error scanning ban:", err)
+			continue
+		}
+		bans = append(bans, b)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(bans)
+}
+
+func isBanned(ctx context.Context, roomID, userID int) (bool, error) {
+	var banned bool
+	err := db.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM room_ban WHERE room_id = $1 AND user_id = $2)`,
+		roomID, userID).Scan(&banned)
+	return banned, err
+}
+
+func transferOwnershipHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	callerID, ok := requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	var data struct {
+		RoomId int `json:"roomId"`
+		UserId int `json:"userId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		log.Println("Error starting transaction:", err)
+		http.Error(w, "Database transaction error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(context.Background())
+
+	ownerID, err := lockRoomOwner(ctx, tx, data.RoomId)
+	if err == nil && ownerID != callerID {
+		err = errNotOwner
+	}
+	if err != nil {
+		if moderationErrorStatus(err) == http.StatusInternalServerError {
+			log.Println("Error transferring ownership:", err)
+		}
+		http.Error(w, err.Error(), moderationErrorStatus(err))
+		return
+	}
+
+	var role string
+	var isBot bool
+	err = tx.QueryRow(ctx, `
+		SELECT p.role, u.is_bot
+		  FROM participation p
+		  JOIN "user" u ON u.user_id = p.user_id
+		 WHERE p.room_id = $1 AND p.user_id = $2`, data.RoomId, data.UserId).Scan(&role, &isBot)
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.Error(w, "User is not in the room", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Println("Error fetching new owner:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if isBot {
+		http.Error(w, "Ownership cannot be given to a bot", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := tx.Exec(ctx, `UPDATE room SET owner_id = $2 WHERE room_id = $1`, data.RoomId, data.UserId); err != nil {
+		log.Println("Error updating owner:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		log.Println("Error committing transaction:", err)
+		http.Error(w, "Error committing transaction", http.StatusInternalServerError)
+		return
+	}
+
+	publishOwnerChanged(data.RoomId, callerID, data.UserId, "transferred")
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// Коэффициенты на победителя турнира считаются по живым песням комнаты из
+// двух оценок вероятности: доли поставленных монет и (по желанию) истории
+// трека — как часто та же композиция выигрывала завершённые турниры.
+// Оценки сглажены, поэтому без ставок и без истории коэффициенты равные.
+// Коэффициент — десятичный: столько за единицу ставки на победителя платит
+// модель выплат комнаты, за вычетом маржи house.
+var (
+	oddsHistoryWeight = getEnvInt("ODDS_HISTORY_WEIGHT_PERCENT", 30)
+	oddsPriorStake    = getEnvInt("ODDS_PRIOR_STAKE", 50)
+)
+
+type SongOdds struct {
+	SongID      int      `json:"songId"`
+	TrackName   string   `json:"trackName"`
+	Staked      int      `json:"staked"`
+	StakeShare  float64  `json:"stakeShare"`
+	WinRate     *float64 `json:"historicalWinRate,omitempty"`
+	Probability float64  `json:"probability"`
+	Odds        float64  `json:"odds"`
+}
+
+type RoomOdds struct {
+	RoomID    int        `json:"roomId"`
+	Model     string     `json:"model"`
+	Pool      int        `json:"pool"`
+	History   bool       `json:"history"`
+	Songs     []SongOdds `json:"songs"`
+	UpdatedAt time.Time  `json:"updatedAt"`
+}
+
+// oddsInput — ставки и история одной песни для расчёта.
+type oddsInput struct {
+	Staked int
+	Wins   int
+	Games  int
+}
+
+// oddsScale — условная ставка, на которой модель считает коэффициент, чтобы
+// целочисленные выплаты не теряли точность.
+const oddsScale = 10000
+
+// computeOdds возвращает вероятности и коэффициенты модели model в порядке
+// inputs. historyPercent — вес истории в процентах (0 — только ставки).
+func computeOdds(inputs []oddsInput, model PayoutModel, priorStake, historyPercent int) (probs, odds []float64) {
+	n := len(inputs)
+	probs = make([]float64, n)
+	odds = make([]float64, n)
+	if n == 0 {
+		return probs, odds
+	}
+
+	pool := 0
+	hasHistory := false
+	for _, in := range inputs {
+		pool += in.Staked
+		hasHistory = hasHistory || in.Games > 0
+	}
+	if !hasHistory {
+		historyPercent = 0
+	}
+
+	// Доля рейтинга по истории: сглаженная частота побед, нормированная по комнате.
+	rates := make([]float64, n)
+	rateSum := 0.0
+	for i, in := range inputs {
+		rates[i] = float64(in.Wins+1) / float64(in.Games+2)
+		rateSum += rates[i]
+	}
+
+	w := float64(historyPercent) / 100
+	for i, in := range inputs {
+		stakeProb := float64(in.Staked+priorStake) / float64(pool+n*priorStake)
+		if pool+n*priorStake == 0 {
+			stakeProb = 1 / float64(n)
+		}
+		probs[i] = (1-w)*stakeProb + w*rates[i]/rateSum
+		odds[i] = modelOdds(model, probs[i], n)
+	}
+	return probs, odds
+}
+
+// modelOdds переводит выплату модели за победителя в коэффициент. Фиксированная
+// модель и survival платят по числу песен, не глядя на ставки; в parimutuel
+// выплата зависит от доли ставок на песню, поэтому коэффициент — обратная
+// оценка вероятности. Для песни с нулевой вероятностью коэффициент не
+// определён и равен 0.
+func modelOdds(model PayoutModel, prob float64, songs int) float64 {
+	payout := model.Payout(BetOutcome{Stake: oddsScale, Placement: 1, Songs: songs, Champion: true,
+		Pool: oddsScale, OnWinner: oddsScale})
+	odds := float64(payout) / oddsScale
+	if _, ok := model.(parimutuel); ok {
+		if prob <= 0 {
+			return 0
+		}
+		odds /= prob
+	}
+	return math.Round(odds*100) / 100
+}
+
+// roomOdds считает коэффициенты на живые песни комнаты по её модели выплат.
+func roomOdds(ctx context.Context, roomID int, history bool) (*RoomOdds, error) {
+	model, err := roomPayoutModel(ctx, db, roomID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errRoomNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(ctx, `
+		SELECT s.song_id, s.track_name,
+		       COALESCE((SELECT SUM(b.bet_amount) FROM bets b WHERE b.song_id = s.song_id AND b.voided_at IS NULL), 0),
+		       COUNT(h.song_id),
+		       COUNT(h.song_id) FILTER (WHERE hp.eliminated IS NULL OR hp.eliminated = FALSE)
+		  FROM song s
+	 LEFT JOIN song_progress sp ON s.song_id = sp.song_id
+	 LEFT JOIN song h ON $2 AND h.room_id <> s.room_id
+	                  AND h.provider = s.provider AND h.provider_track_id = s.provider_track_id
+	                  AND EXISTS (SELECT 1 FROM room hr WHERE hr.room_id = h.room_id AND hr.status = 'finished')
+	 LEFT JOIN song_progress hp ON h.song_id = hp.song_id
+		 WHERE s.room_id = $1 AND (sp.eliminated IS NULL OR sp.eliminated = FALSE)
+	  GROUP BY s.song_id
+	  ORDER BY s.song_id`, roomID, history)
+	if err != nil {
+		return nil, fmt.Errorf("query odds inputs: %w", err)
+	}
+	defer rows.Close()
+
+	out := &RoomOdds{RoomID: roomID, Model: model.Name(), Songs: []SongOdds{}, UpdatedAt: time.Now().UTC()}
+	var inputs []oddsInput
+	for rows.Next() {
+		var s SongOdds
+		var in oddsInput
+		if err := rows.Scan(&s.SongID, &s.TrackName, &in.Staked, &in.Games, &in.Wins); err != nil {
+			return nil, fmt.Errorf("scan odds input: %w", err)
+		}
+		if in.Games > 0 {
+			rate := float64(in.Wins) / float64(in.Games)
+			s.WinRate = &rate
+			out.History = true
+		}
+		s.Staked = in.Staked
+		out.Pool += in.Staked
+		out.Songs = append(out.Songs, s)
+		inputs = append(inputs, in)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	weight := 0
+	if history {
+		weight = oddsHistoryWeight
+	}
+	probs, odds := computeOdds(inputs, model, oddsPriorStake, weight)
+	for i := range out.Songs {
+		if out.Pool > 0 {
+			out.Songs[i].StakeShare = float64(out.Songs[i].Staked) / float64(out.Pool)
+		}
+		out.Songs[i].Probability = probs[i]
+		out.Songs[i].Odds = odds[i]
+	}
+	return out, nil
+}
+
+// publishOdds пересчитывает коэффициенты и рассылает их в поток комнаты.
+func publishOdds(ctx context.Context, roomID int) {
+	odds, err := roomOdds(ctx, roomID, oddsHistoryWeight > 0)
+	if err != nil {
+		log.Println("Error calculating odds:", err)
+		return
+	}
+	publishRoomEvent(roomID, "odds", odds)
+}
+
+func oddsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	roomID, err := strconv.Atoi(r.URL.Query().Get("roomId"))
+	if err != nil {
+		http.Error(w, "roomId is required", http.StatusBadRequest)
+		return
+	}
+	history := oddsHistoryWeight > 0 && r.URL.Query().Get("history") != "false"
+
+	odds, err := roomOdds(r.Context(), roomID, history)
+	if errors.Is(err, errRoomNotFound) {
+		http.Error(w, "Room not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println("Error calculating odds:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(odds)
+}
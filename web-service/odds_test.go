@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func TestComputeOdds(t *testing.T) {
+	noBets := []oddsInput{{}, {}, {}, {}}
+	tests := []struct {
+		name      string
+		inputs    []oddsInput
+		model     PayoutModel
+		prior     int
+		history   int
+		wantProbs []float64
+		wantOdds  []float64
+	}{
+		{"fixed ignores stakes", []oddsInput{{Staked: 300}, {}, {}, {}}, fixedOdds{5}, 50, 0,
+			[]float64{0.7, 0.1, 0.1, 0.1}, []float64{3.8, 3.8, 3.8, 3.8}},
+		{"survival pays twice the stake", noBets, survival{5}, 50, 0,
+			[]float64{0.25, 0.25, 0.25, 0.25}, []float64{1.9, 1.9, 1.9, 1.9}},
+		{"parimutuel without bets", noBets, parimutuel{5}, 50, 0,
+			[]float64{0.25, 0.25, 0.25, 0.25}, []float64{3.8, 3.8, 3.8, 3.8}},
+		{"parimutuel unstaked song without prior", []oddsInput{{Staked: 300}, {Staked: 100}, {}}, parimutuel{5}, 0, 0,
+			[]float64{0.75, 0.25, 0}, []float64{1.27, 3.8, 0}},
+		{"parimutuel from history", []oddsInput{{Wins: 3, Games: 4}, {Games: 4}}, parimutuel{5}, 0, 100,
+			[]float64{0.8, 0.2}, []float64{1.19, 4.75}},
+		{"empty room", nil, fixedOdds{5}, 50, 0, []float64{}, []float64{}},
+	}
+	for _, tt := range tests {
+		probs, odds := computeOdds(tt.inputs, tt.model, tt.prior, tt.history)
+		if len(probs) != len(tt.wantProbs) || len(odds) != len(tt.wantOdds) {
+			t.Fatalf("%s: got %d probabilities and %d odds", tt.name, len(probs), len(odds))
+		}
+		for i := range probs {
+			if math.Abs(probs[i]-tt.wantProbs[i]) > 1e-9 {
+				t.Errorf("%s: probability[%d] = %v, want %v", tt.name, i, probs[i], tt.wantProbs[i])
+			}
+			if odds[i] != tt.wantOdds[i] {
+				t.Errorf("%s: odds[%d] = %v, want %v", tt.name, i, odds[i], tt.wantOdds[i])
+			}
+		}
+		// Коэффициенты уходят клиентам в JSON, бесконечность там не кодируется.
+		if _, err := json.Marshal(odds); err != nil {
+			t.Errorf("%s: encode odds: %v", tt.name, err)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// Модель выплат решает, сколько получает ставка на песню, когда песня
+// выбывает или становится победителем. Модель выбирается для комнаты.
+// Расчёт чистый и не трогает базу: деньги двигает resolveBets. Ставка
+// оценивается по полю песен на момент, когда её сделали.
+
+// BetOutcome — всё, что нужно модели, чтобы посчитать выплату по ставке.
+type BetOutcome struct {
+	Stake     int  // сумма ставки
+	Placement int  // место песни: 1 — победитель, Songs — выбыла первой
+	Songs     int  // песен в игре на момент ставки
+	Champion  bool // песня выиграла турнир
+	Pool      int  // сумма всех ставок комнаты
+	OnWinner  int  // сумма ставок на победителя
+}
+
+type PayoutModel interface {
+	Name() string
+	Payout(o BetOutcome) int
+}
+
+// fixedOdds платит только за победителя по фиксированному коэффициенту:
+// число песен за вычетом маржи, так что при случайном исходе ставка в
+// среднем возвращает (1 − margin).
+type fixedOdds struct{ marginPercent int }
+
+func (fixedOdds) Name() string { return "fixed" }
+
+func (m fixedOdds) Payout(o BetOutcome) int {
+	if !o.Champion || o.Songs < 2 {
+		return 0
+	}
+	return o.Stake * o.Songs * (100 - m.marginPercent) / 100
+}
+
+// parimutuel делит весь банк комнаты за вычетом маржи между ставками на
+// победителя пропорционально ставкам.
+type parimutuel struct{ marginPercent int }
+
+func (parimutuel) Name() string { return "parimutuel" }
+
+func (m parimutuel) Payout(o BetOutcome) int {
+	if !o.Champion || o.OnWinner <= 0 {
+		return 0
+	}
+	return o.Pool * (100 - m.marginPercent) / 100 * o.Stake / o.OnWinner
+}
+
+// survival платит тем больше, чем дольше продержалась песня: от нуля за
+// выбывшую первой до 2 × (1 − margin) ставки за победителя.
+type survival struct{ marginPercent int }
+
+func (survival) Name() string { return "survival" }
+
+func (m survival) Payout(o BetOutcome) int {
+	if o.Songs < 2 || o.Placement < 1 || o.Placement > o.Songs {
+		return 0
+	}
+	return 2 * o.Stake * (100 - m.marginPercent) * (o.Songs - o.Placement) / (100 * (o.Songs - 1))
+}
+
+var (
+	houseMarginPercent = getEnvInt("HOUSE_MARGIN_PERCENT", 5)
+	defaultPayoutModel = "fixed"
+
+	payoutModels = map[string]PayoutModel{
+		"fixed":      fixedOdds{marginPercent: houseMarginPercent},
+		"parimutuel": parimutuel{marginPercent: houseMarginPercent},
+		"survival":   survival{marginPercent: houseMarginPercent},
+	}
+)
+
+func roomPayoutModel(ctx context.Context, q queryRower, roomID int) (PayoutModel, error) {
+	var name string
+	if err := q.QueryRow(ctx, `SELECT payout_model FROM room WHERE room_id = $1`, roomID).Scan(&name); err != nil {
+		return nil, fmt.Errorf("fetch payout model: %w", err)
+	}
+	if m, ok := payoutModels[name]; ok {
+		return m, nil
+	}
+	log.Printf("Unknown payout model %q in room %d, using %s", name, roomID, defaultPayoutModel)
+	return payoutModels[defaultPayoutModel], nil
+}
+
+// resolveBets закрывает ставки на песню, занявшую место placement, и платит
+// по модели комнаты.
+func resolveBets(ctx context.Context, tx pgx.Tx, roomID, songID, placement, songs int) ([]BetPayout, error) {
+	model, err := roomPayoutModel(ctx, tx, roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	outcome := BetOutcome{Placement: placement, Songs: songs, Champion: placement == 1}
+	if err := tx.QueryRow(ctx, `
+		SELECT COALESCE(SUM(bet_amount), 0),
+		       COALESCE(SUM(bet_amount) FILTER (WHERE song_id = $2), 0)
+		  FROM bets WHERE room_id = $1 AND voided_at IS NULL`, roomID, songID).Scan(&outcome.Pool, &outcome.OnWinner); err != nil {
+		return nil, fmt.Errorf("sum pool: %w", err)
+	}
+
+	rows, err := tx.Query(ctx, `
+		SELECT bet_id, user_id, team_id, bet_amount, COALESCE(songs_in_play, $3)
+		  FROM bets
+		 WHERE room_id = $1 AND song_id = $2 AND bet_amount > 0 AND settled_at IS NULL
+		   FOR UPDATE`, roomID, songID, songs)
+	if err != nil {
+		return nil, fmt.Errorf("query bets: %w", err)
+	}
+	var payouts []BetPayout
+	inPlay := map[int]int{}
+	for rows.Next() {
+		var p BetPayout
+		var n int
+		if err := rows.Scan(&p.BetID, &p.UserID, &p.TeamID, &p.Stake, &n); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan bet: %w", err)
+		}
+		payouts = append(payouts, p)
+		inPlay[p.BetID] = n
+	}
+	rows.Close()
+	sort.Slice(payouts, func(i, j int) bool { return payouts[i].BetID < payouts[j].BetID })
+
+	for i := range payouts {
+		p := &payouts[i]
+		p.Payout = model.Payout(pricedOutcome(outcome, p.Stake, inPlay[p.BetID]))
+
+		to := userAccount(p.UserID)
+		if p.TeamID != nil {
+			to = teamAccount(*p.TeamID)
+		}
+		if err := payBet(ctx, tx, roomID, betRef(p.BetID), to, p.Stake, p.Payout); err != nil {
+			return nil, fmt.Errorf("pay bet %d: %w", p.BetID, err)
+		}
+		if _, err := tx.Exec(ctx, `
+			UPDATE bets SET settled_at = now(), payout = $2 WHERE bet_id = $1`, p.BetID, p.Payout); err != nil {
+			return nil, fmt.Errorf("settle bet %d: %w", p.BetID, err)
+		}
+	}
+	return payouts, nil
+}
+
+// pricedOutcome приводит исход к полю песен, по которому была сделана ставка:
+// песни, добавленные после ставки, не поднимают коэффициент, а место за
+// пределами того поля считается последним.
+func pricedOutcome(o BetOutcome, stake, songsInPlay int) BetOutcome {
+	o.Stake = stake
+	if songsInPlay > 0 && songsInPlay < o.Songs {
+		o.Songs = songsInPlay
+		if o.Placement > o.Songs {
+			o.Placement = o.Songs
+		}
+	}
+	return o
+}
+
+// payBet рассчитывает закрытую ставку: из эскроу возвращается не больше
+// самой ставки, остаток ставки уходит на house, выигрыш сверх ставки
+// доплачивает house.
+func payBet(ctx context.Context, tx pgx.Tx, roomID int, ref string, to Account, stake, payout int) error {
+	released := stake
+	if payout < released {
+		released = payout
+	}
+	if released > 0 {
+		if _, err := transfer(ctx, tx, escrowAccount(roomID), to, released, reasonBetPayout, ref); err != nil {
+			return fmt.Errorf("release stake: %w", err)
+		}
+	}
+	if lost := stake - released; lost > 0 {
+		if _, err := transfer(ctx, tx, escrowAccount(roomID), houseAccount, lost, reasonBetLoss, ref); err != nil {
+			return fmt.Errorf("close stake: %w", err)
+		}
+	}
+	if won := payout - stake; won > 0 {
+		if _, err := transfer(ctx, tx, houseAccount, to, won, reasonBetPayout, ref); err != nil {
+			return fmt.Errorf("pay winnings: %w", err)
+		}
+	}
+	return nil
+}
+
+func setPayoutModelHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	callerID, ok := requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	var data struct {
+		RoomId int    `json:"roomId"`
+		Model  string `json:"model"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if _, ok := payoutModels[data.Model]; !ok {
+		http.Error(w, "Unknown payout model", http.StatusBadRequest)
+		return
+	}
+
+	// Модель меняется только в лобби и пока нет ни одной ставки.
+	tag, err := db.Exec(r.Context(), `
+		UPDATE room SET payout_model = $2
+		 WHERE room_id = $1 AND owner_id = $3 AND status = 'open'
+		   AND NOT EXISTS (SELECT 1 FROM bets WHERE room_id = $1 AND voided_at IS NULL)`,
+		data.RoomId, data.Model, callerID)
+	if err != nil {
+		log.Println("Error updating payout model:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		http.Error(w, "Room not found, bets already placed, or you are not the owner", http.StatusForbidden)
+		return
+	}
+
+	publishRoomEvent(data.RoomId, "payout_model", map[string]string{"model": data.Model})
+	w.WriteHeader(http.StatusOK)
+}
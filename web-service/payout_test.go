@@ -0,0 +1,61 @@
+package main
+
+import "testing"
+
+func TestPayoutModels(t *testing.T) {
+	tests := []struct {
+		name  string
+		model PayoutModel
+		o     BetOutcome
+		want  int
+	}{
+		{"fixed champion", fixedOdds{5}, BetOutcome{Stake: 100, Placement: 1, Songs: 8, Champion: true}, 760},
+		{"fixed loser", fixedOdds{5}, BetOutcome{Stake: 100, Placement: 2, Songs: 8}, 0},
+		{"fixed single song", fixedOdds{5}, BetOutcome{Stake: 100, Placement: 1, Songs: 1, Champion: true}, 0},
+		{"fixed no margin", fixedOdds{0}, BetOutcome{Stake: 10, Placement: 1, Songs: 2, Champion: true}, 20},
+
+		{"parimutuel share", parimutuel{5}, BetOutcome{Stake: 100, Champion: true, Pool: 1000, OnWinner: 200}, 475},
+		{"parimutuel only winner bets", parimutuel{5}, BetOutcome{Stake: 100, Champion: true, Pool: 100, OnWinner: 100}, 95},
+		{"parimutuel nothing on winner", parimutuel{5}, BetOutcome{Stake: 100, Champion: true, Pool: 1000, OnWinner: 0}, 0},
+		{"parimutuel loser", parimutuel{5}, BetOutcome{Stake: 100, Pool: 1000, OnWinner: 200}, 0},
+
+		{"survival winner", survival{5}, BetOutcome{Stake: 100, Placement: 1, Songs: 8, Champion: true}, 190},
+		{"survival middle", survival{5}, BetOutcome{Stake: 100, Placement: 4, Songs: 8}, 108},
+		{"survival first out", survival{5}, BetOutcome{Stake: 100, Placement: 8, Songs: 8}, 0},
+		{"survival placement zero", survival{5}, BetOutcome{Stake: 100, Placement: 0, Songs: 8}, 0},
+		{"survival placement past field", survival{5}, BetOutcome{Stake: 100, Placement: 9, Songs: 8}, 0},
+		{"survival single song", survival{5}, BetOutcome{Stake: 100, Placement: 1, Songs: 1, Champion: true}, 0},
+	}
+	for _, tt := range tests {
+		if got := tt.model.Payout(tt.o); got != tt.want {
+			t.Errorf("%s: payout = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPricedOutcome(t *testing.T) {
+	settled := BetOutcome{Placement: 6, Songs: 8}
+	tests := []struct {
+		name          string
+		songsInPlay   int
+		wantSongs     int
+		wantPlacement int
+	}{
+		{"priced on the full field", 8, 8, 6},
+		{"legacy bet without price", 0, 8, 6},
+		{"songs added after the bet", 4, 4, 4},
+		{"placement inside the priced field", 7, 7, 6},
+	}
+	for _, tt := range tests {
+		o := pricedOutcome(settled, 100, tt.songsInPlay)
+		if o.Stake != 100 || o.Songs != tt.wantSongs || o.Placement != tt.wantPlacement {
+			t.Errorf("%s: got stake %d, songs %d, placement %d", tt.name, o.Stake, o.Songs, o.Placement)
+		}
+	}
+
+	// Коэффициент фиксированной модели не растёт от песен, добавленных после ставки.
+	champion := pricedOutcome(BetOutcome{Placement: 1, Songs: 8, Champion: true}, 100, 4)
+	if got := (fixedOdds{5}).Payout(champion); got != 380 {
+		t.Errorf("fixed payout priced on 4 songs = %d, want 380", got)
+	}
+}
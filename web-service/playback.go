@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// PlaybackSlot — когда и какой фрагмент песни играть. Время указано по часам
+// сервера в миллисекундах Unix; клиенты переводят его через /time.
+type PlaybackSlot struct {
+	Position   int    `json:"position"`
+	SongID     int    `json:"songId"`
+	TrackName  string `json:"trackName"`
+	ArtistName string `json:"artistName"`
+	Provider   string `json:"provider"`
+	TrackID    string `json:"trackId,omitempty"`
+	StartAt    int64  `json:"startAt"`
+	OffsetMs   int    `json:"offsetMs"`
+	DurationMs int    `json:"durationMs"`
+}
+
+type PlaybackSchedule struct {
+	RoomID        int            `json:"roomId"`
+	Round         int            `json:"round"`
+	SpeakerUserID *int           `json:"speakerUserId,omitempty"`
+	Slots         []PlaybackSlot `json:"slots"`
+	VotingOpensAt int64          `json:"votingOpensAt"`
+	ServerTime    int64          `json:"serverTime"`
+}
+
+var (
+	playbackClipMs = getEnvInt("PLAYBACK_CLIP_MS", 30000)
+	playbackGapMs  = getEnvInt("PLAYBACK_GAP_MS", 1000)
+	playbackLeadMs = getEnvInt("PLAYBACK_LEAD_MS", 3000)
+	errNoMatchup   = errors.New("not enough songs remaining for a matchup")
+	errNoSchedule  = errors.New("no playback schedule for the current matchup")
+)
+
+func unixMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// currentMatchup возвращает раунд и пару песен текущего матча, ничего не
+// меняя. Если пара ещё не выбрана, возвращает errNoMatchup.
+func currentMatchup(ctx context.Context, roomID int) (int, int, int, error) {
+	var round, song1, song2 int
+	err := db.QueryRow(ctx, `
+        SELECT current_round, COALESCE(current_song1, 0), COALESCE(current_song2, 0)
+          FROM room
+         WHERE room_id = $1
+    `, roomID).Scan(&round, &song1, &song2)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, 0, 0, errRoomNotFound
+	}
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("fetch current matchup: %w", err)
+	}
+	if song1 == 0 || song2 == 0 {
+		return round, 0, 0, errNoMatchup
+	}
+	return round, song1, song2, nil
+}
+
+// selectMatchup возвращает пару текущего матча, а если она ещё не выбрана
+// (первый раунд), выбирает её из невыбывших песен, сохраняет и открывает
+// рынки раунда. Вызывается только на переходах состояния (POST), чтобы
+// GET-запросы ничего не меняли.
+func selectMatchup(ctx context.Context, roomID int) (int, int, int, error) {
+	round, song1, song2, err := currentMatchup(ctx, roomID)
+	if !errors.Is(err, errNoMatchup) {
+		return round, song1, song2, err
+	}
+
+	rows, err := db.Query(ctx, `
+        SELECT s.song_id
+          FROM song s
+     LEFT JOIN song_progress sp ON s.song_id = sp.song_id
+         WHERE s.room_id = $1
+           AND (sp.eliminated IS NULL OR sp.eliminated = FALSE)
+      ORDER BY random()
+         LIMIT 2
+    `, roomID)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("select matchup songs: %w", err)
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, 0, 0, fmt.Errorf("scan song_id: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if len(ids) < 2 {
+		return 0, 0, 0, errNoMatchup
+	}
+
+	// Условие на NULL защищает от гонки двух одновременных запросов.
+	tag, err := db.Exec(ctx, `
+        UPDATE room
+           SET current_song1 = $2,
+               current_song2 = $3
+         WHERE room_id = $1
+           AND (current_song1 IS NULL OR current_song2 IS NULL)
+    `, roomID, ids[0], ids[1])
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("store matchup: %w", err)
+	}
+	if tag.RowsAffected() > 0 {
+		if err := openRoundMarkets(ctx, db, roomID, round, ids[0], ids[1]); err != nil {
+			log.Println("Error opening markets:", err)
+		}
+	}
+	return currentMatchup(ctx, roomID)
+}
+
+// selectMatchupWhenSubmitted выбирает первую пару, как только все игроки
+// комнаты отправили песни.
+func selectMatchupWhenSubmitted(ctx context.Context, roomID int) {
+	var allSubmitted bool
+	err := db.QueryRow(ctx, `
+		SELECT COALESCE(BOOL_AND(is_submitted), FALSE)
+		  FROM participation WHERE room_id = $1 AND role = 'player' AND left_at IS NULL`,
+		roomID).Scan(&allSubmitted)
+	if err != nil {
+		log.Println("Error checking submissions:", err)
+		return
+	}
+	if !allSubmitted {
+		return
+	}
+	if _, _, _, err := selectMatchup(ctx, roomID); err != nil && !errors.Is(err, errNoMatchup) {
+		log.Println("Error selecting first matchup:", err)
+	}
+}
+
+func loadPlaybackSchedule(ctx context.Context, roomID, round int) (*PlaybackSchedule, error) {
+	rows, err := db.Query(ctx, `
+        SELECT ps.position, ps.song_id, s.track_name, COALESCE(s.artist_name, ''),
+               COALESCE(s.provider, 'spotify'), COALESCE(s.provider_track_id, ''),
+               ps.start_at, ps.offset_ms, ps.duration_ms, ps.speaker_user_id
+          FROM playback_schedule ps
+          JOIN song s ON s.song_id = ps.song_id
+         WHERE ps.room_id = $1 AND ps.round = $2
+      ORDER BY ps.position
+    `, roomID, round)
+	if err != nil {
+		return nil, fmt.Errorf("query playback schedule: %w", err)
+	}
+	defer rows.Close()
+
+	schedule := &PlaybackSchedule{RoomID: roomID, Round: round}
+	var votingOpensAt time.Time
+	for rows.Next() {
+		var slot PlaybackSlot
+		var startAt time.Time
+		if err := rows.Scan(&slot.Position, &slot.SongID, &slot.TrackName, &slot.ArtistName,
+			&slot.Provider, &slot.TrackID, &startAt, &slot.OffsetMs, &slot.DurationMs, &schedule.SpeakerUserID); err != nil {
+			return nil, fmt.Errorf("scan playback slot: %w", err)
+		}
+		slot.StartAt = unixMillis(startAt)
+		schedule.Slots = append(schedule.Slots, slot)
+
+		if end := startAt.Add(time.Duration(slot.DurationMs) * time.Millisecond); end.After(votingOpensAt) {
+			votingOpensAt = end
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(schedule.Slots) == 0 {
+		return nil, errNoSchedule
+	}
+	schedule.VotingOpensAt = unixMillis(votingOpensAt)
+	schedule.ServerTime = unixMillis(time.Now())
+	return schedule, nil
+}
+
+func schedulePlaybackHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var data struct {
+		UserId        int  `json:"userId"`
+		RoomId        int  `json:"roomId"`
+		SpeakerUserId *int `json:"speakerUserId"`
+		Restart       bool `json:"restart"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+
+	var ownerId int
+	err := db.QueryRow(ctx, "SELECT owner_id FROM room WHERE room_id = $1", data.RoomId).Scan(&ownerId)
+	if err != nil {
+		log.Println("Error fetching room:", err)
+		http.Error(w, "Room not found", http.StatusNotFound)
+		return
+	}
+	if data.UserId != ownerId {
+		http.Error(w, "Only the owner can start playback", http.StatusForbidden)
+		return
+	}
+
+	round, song1, song2, err := selectMatchup(ctx, data.RoomId)
+	if errors.Is(err, errNoMatchup) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Println("Error fetching current matchup:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	if !data.Restart {
+		schedule, err := loadPlaybackSchedule(ctx, data.RoomId, round)
+		if err == nil {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(schedule)
+			return
+		}
+		if !errors.Is(err, errNoSchedule) {
+			log.Println("Error loading playback schedule:", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	startA := time.Now().Add(time.Duration(playbackLeadMs) * time.Millisecond)
+	startB := startA.Add(time.Duration(playbackClipMs+playbackGapMs) * time.Millisecond)
+
+	batch := &pgx.Batch{}
+	batch.Queue(`DELETE FROM playback_schedule WHERE room_id = $1 AND round = $2`, data.RoomId, round)
+	for i, slot := range []struct {
+		songID  int
+		startAt time.Time
+	}{{song1, startA}, {song2, startB}} {
+		batch.Queue(`
+			INSERT INTO playback_schedule (room_id, round, position, song_id, start_at, offset_ms, duration_ms, speaker_user_id)
+			VALUES ($1, $2, $3, $4, $5, 0, $6, $7)`,
+			data.RoomId, round, i, slot.songID, slot.startAt, playbackClipMs, data.SpeakerUserId)
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		log.Println("Error starting transaction:", err)
+		http.Error(w, "Database transaction error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(context.Background())
+
+	if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+		log.Println("Error saving playback schedule:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		log.Println("Error committing transaction:", err)
+		http.Error(w, "Error committing transaction", http.StatusInternalServerError)
+		return
+	}
+
+	schedule, err := loadPlaybackSchedule(ctx, data.RoomId, round)
+	if err != nil {
+		log.Println("Error loading playback schedule:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	publishRoomEvent(data.RoomId, "playback_schedule", schedule)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(schedule)
+}
+
+func getPlaybackHandler(w http.ResponseWriter, r *http.Request) {
+	roomID, err := strconv.Atoi(r.URL.Query().Get("roomId"))
+	if err != nil {
+		http.Error(w, "roomId is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	round, _, _, err := currentMatchup(ctx, roomID)
+	if errors.Is(err, errRoomNotFound) {
+		http.Error(w, "Room not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, errNoMatchup) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Println("Error fetching current matchup:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	schedule, err := loadPlaybackSchedule(ctx, roomID, round)
+	if errors.Is(err, errNoSchedule) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println("Error loading playback schedule:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(schedule)
+}
+
+// serverTimeHandler — синхронизация часов в стиле NTP: клиент передаёт время
+// отправки t0, получает время приёма и ответа сервера и сам считает смещение
+// как ((receivedAt - t0) + (sentAt - t3)) / 2, где t3 — время получения ответа.
+func serverTimeHandler(w http.ResponseWriter, r *http.Request) {
+	receivedAt := unixMillis(time.Now())
+	clientSentAt, _ := strconv.ParseInt(r.URL.Query().Get("t0"), 10, 64)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]int64{
+		"clientSentAt":     clientSentAt,
+		"serverReceivedAt": receivedAt,
+		"serverSentAt":     unixMillis(time.Now()),
+	})
+}
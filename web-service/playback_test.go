@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func getRequest(handler http.HandlerFunc, target string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	return rec
+}
+
+// roomMatchupState возвращает сохранённую пару и число открытых рынков комнаты.
+func roomMatchupState(t *testing.T, roomID int) (song1, song2 *int, markets int) {
+	t.Helper()
+	err := db.QueryRow(context.Background(), `
+		SELECT current_song1, current_song2,
+		       (SELECT COUNT(*) FROM bet_market WHERE room_id = $1)
+		  FROM room WHERE room_id = $1`, roomID).Scan(&song1, &song2, &markets)
+	if err != nil {
+		t.Fatalf("load room state: %v", err)
+	}
+	return song1, song2, markets
+}
+
+func TestMatchupGetsAreReadOnly(t *testing.T) {
+	openTestDB(t)
+	owner := mustCreateUser(t, "Owner")
+	guest := mustCreateUser(t, "Guest")
+	roomID := mustCreateRoom(t, owner, "Room")
+	for _, userID := range []int{owner, guest} {
+		mustExec(t, `INSERT INTO participation (user_id, room_id, role) VALUES ($1, $2, 'player')`, userID, roomID)
+	}
+	mustAddSong(t, roomID, owner, "A", "X", "")
+	mustAddSong(t, roomID, guest, "B", "Y", "")
+	mustAddSong(t, roomID, guest, "C", "Z", "")
+	room := strconv.Itoa(roomID)
+
+	if rec := getRequest(getCurrentRoundHandler, "/currentRound?roomId="+room); rec.Code != http.StatusOK {
+		t.Fatalf("currentRound: status %d", rec.Code)
+	}
+	if rec := getRequest(getPlaybackHandler, "/room/playback?roomId="+room); rec.Code != http.StatusConflict {
+		t.Fatalf("playback before matchup: status %d, want 409", rec.Code)
+	}
+	if song1, song2, markets := roomMatchupState(t, roomID); song1 != nil || song2 != nil || markets != 0 {
+		t.Fatalf("GET requests changed the room: pair %v/%v, %d markets", song1, song2, markets)
+	}
+
+	for _, handler := range []http.HandlerFunc{getCurrentRoundHandler, getPlaybackHandler} {
+		if rec := getRequest(handler, "/?roomId=999999"); rec.Code != http.StatusNotFound {
+			t.Fatalf("missing room: status %d, want 404", rec.Code)
+		}
+	}
+
+	// Пара выбирается, когда песни отправил последний игрок.
+	for i, userID := range []int{owner, guest} {
+		body := `{"userId": ` + strconv.Itoa(userID) + `, "roomId": ` + room + `}`
+		rec := httptest.NewRecorder()
+		markSubmissionDoneHandler(rec, httptest.NewRequest(http.MethodPost, "/room/submission-done", strings.NewReader(body)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("submission-done: status %d", rec.Code)
+		}
+		song1, _, markets := roomMatchupState(t, roomID)
+		if last := i == 1; (song1 != nil) != last || (markets > 0) != last {
+			t.Fatalf("after %d submissions: pair selected = %v, %d markets", i+1, song1 != nil, markets)
+		}
+	}
+}
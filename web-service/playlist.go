@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// finalStandings возвращает песни комнаты в итоговом порядке: победитель,
+// затем выбывшие от последнего раунда к первому, при равенстве — по голосам.
+func finalStandings(ctx context.Context, roomID int) ([]Track, error) {
+	rows, err := db.Query(ctx, `
+        SELECT s.song_id, s.track_name, s.artist_name, s.album_url,
+               COALESCE(s.provider, 'spotify'), COALESCE(s.provider_track_id, '')
+          FROM song s
+     LEFT JOIN song_progress sp ON s.song_id = sp.song_id
+     LEFT JOIN (SELECT song_id, COUNT(*) AS votes
+                  FROM votes
+                 WHERE room_id = $1
+              GROUP BY song_id) v ON s.song_id = v.song_id
+         WHERE s.room_id = $1
+      ORDER BY COALESCE(sp.eliminated, FALSE),
+               sp.round DESC NULLS FIRST,
+               COALESCE(v.votes, 0) DESC,
+               s.song_id
+    `, roomID)
+	if err != nil {
+		return nil, fmt.Errorf("query standings: %w", err)
+	}
+	defer rows.Close()
+
+	var standings []Track
+	for rows.Next() {
+		var t Track
+		if err := rows.Scan(&t.SongID, &t.TrackName, &t.ArtistName, &t.AlbumURL, &t.Provider, &t.TrackID); err != nil {
+			return nil, fmt.Errorf("scan standings: %w", err)
+		}
+		standings = append(standings, t)
+	}
+	return standings, rows.Err()
+}
+
+func isRoomFinished(ctx context.Context, roomID int) (bool, error) {
+	var total, remaining int
+	err := db.QueryRow(ctx, `
+        SELECT COUNT(*),
+               COUNT(*) FILTER (WHERE sp.eliminated IS NULL OR sp.eliminated = FALSE)
+          FROM song s
+     LEFT JOIN song_progress sp ON s.song_id = sp.song_id
+         WHERE s.room_id = $1
+    `, roomID).Scan(&total, &remaining)
+	if err != nil {
+		return false, err
+	}
+	return total >= 2 && remaining <= 1, nil
+}
+
+func exportPlaylistHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("Received request to /room/export-playlist")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	roomID, err := strconv.Atoi(r.URL.Query().Get("roomId"))
+	if err != nil {
+		http.Error(w, "roomId is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+
+	var roomName, topic string
+	err = db.QueryRow(ctx, `
+		SELECT COALESCE(name, ''), COALESCE(topic, '') FROM room WHERE room_id = $1`,
+		roomID).Scan(&roomName, &topic)
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.Error(w, "Room not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println("Error fetching room:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	finished, err := isRoomFinished(ctx, roomID)
+	if err != nil {
+		log.Println("Error checking room state:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if !finished {
+		http.Error(w, "Game is not finished yet", http.StatusConflict)
+		return
+	}
+
+	standings, err := finalStandings(ctx, roomID)
+	if err != nil {
+		log.Println("Error fetching standings:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	provider, err := providerByName(r.URL.Query().Get("provider"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	accessToken, err := providerAccessToken(r, provider, userID)
+	if errors.Is(err, errNotConnected) {
+		http.Error(w, "Spotify account is not connected", http.StatusPreconditionFailed)
+		return
+	}
+	if err != nil {
+		log.Println("Error getting provider token:", err)
+		http.Error(w, "Failed to get provider token", http.StatusBadGateway)
+		return
+	}
+	if accessToken == "" {
+		http.Error(w, "X-Provider-Token is required", http.StatusUnauthorized)
+		return
+	}
+
+	name := roomName
+	if topic != "" {
+		name = fmt.Sprintf("%s — %s", roomName, topic)
+	}
+
+	export := PlaylistExport{
+		Name:        name,
+		Description: "King of the Beat results, from champion to first knocked out",
+		TrackIDs:    songProviderTrackIDs(ctx, provider, accessToken, standings),
+	}
+	err = db.QueryRow(ctx, `
+		SELECT playlist_id FROM room_playlist WHERE room_id = $1 AND user_id = $2 AND provider = $3`,
+		roomID, userID, provider.Name()).Scan(&export.ExistingID)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		log.Println("Error fetching exported playlist:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	playlist, err := provider.ExportPlaylist(ctx, accessToken, export)
+	if err != nil {
+		log.Println("Error exporting playlist:", err)
+		http.Error(w, "Failed to export playlist to "+provider.Name(), http.StatusBadGateway)
+		return
+	}
+
+	_, err = db.Exec(ctx, `
+		INSERT INTO room_playlist (room_id, user_id, provider, playlist_id, playlist_url)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (room_id, user_id, provider) DO UPDATE
+		   SET playlist_id  = EXCLUDED.playlist_id,
+		       playlist_url = EXCLUDED.playlist_url,
+		       updated_at   = now()`,
+		roomID, userID, provider.Name(), playlist.ID, playlist.URL)
+	if err != nil {
+		log.Println("Error saving exported playlist:", err)
+	}
+
+	log.Printf("Exported room %d to %s playlist %s for user %d\n", roomID, provider.Name(), playlist.ID, userID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"provider":    provider.Name(),
+		"playlistId":  playlist.ID,
+		"playlistUrl": playlist.URL,
+		"name":        name,
+		"tracks":      len(export.TrackIDs),
+		"skipped":     len(standings) - len(export.TrackIDs),
+	})
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestSpotifyExportPlaylist(t *testing.T) {
+	fake := newFakeSpotify(t)
+	fake.addProfile("alice", "Alice")
+	ctx := context.Background()
+
+	// 150 треков не помещаются в один запрос: первые 100 заменяют содержимое,
+	// остальные дописываются.
+	var ids, uris []string
+	for i := 0; i < 150; i++ {
+		ids = append(ids, fmt.Sprintf("t%03d", i))
+		uris = append(uris, fmt.Sprintf("spotify:track:t%03d", i))
+	}
+	created, err := spotify.ExportPlaylist(ctx, "access-alice", PlaylistExport{Name: "Room", Description: "d", TrackIDs: ids})
+	if err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	p := fake.getPlaylist(created.ID)
+	if p == nil || p.Owner != "alice" || p.Name != "Room" {
+		t.Fatalf("unexpected playlist %+v", p)
+	}
+	if !reflect.DeepEqual(p.URIs, uris) {
+		t.Fatalf("playlist has %d tracks, want %d in order", len(p.URIs), len(uris))
+	}
+	if created.URL == "" {
+		t.Fatalf("exported playlist has no url")
+	}
+
+	// Повторная выгрузка обновляет тот же плейлист.
+	updated, err := spotify.ExportPlaylist(ctx, "access-alice", PlaylistExport{
+		Name: "Room — final", TrackIDs: ids[:2], ExistingID: created.ID,
+	})
+	if err != nil {
+		t.Fatalf("re-export: %v", err)
+	}
+	if updated.ID != created.ID {
+		t.Fatalf("re-export created playlist %s, want update of %s", updated.ID, created.ID)
+	}
+	p = fake.getPlaylist(created.ID)
+	if p.Name != "Room — final" || !reflect.DeepEqual(p.URIs, uris[:2]) {
+		t.Fatalf("playlist not updated: %+v", p)
+	}
+
+	// Удалённый пользователем плейлист создаётся заново.
+	recreated, err := spotify.ExportPlaylist(ctx, "access-alice", PlaylistExport{Name: "Room", TrackIDs: ids[:1], ExistingID: "gone"})
+	if err != nil {
+		t.Fatalf("export with missing playlist: %v", err)
+	}
+	if recreated.ID == created.ID || recreated.ID == "gone" {
+		t.Fatalf("missing playlist was not recreated: %s", recreated.ID)
+	}
+	if n := fake.countRequests("POST /v1/users/alice/playlists"); n != 2 {
+		t.Fatalf("created %d playlists, want 2", n)
+	}
+}
+
+// seedStandingsRoom заводит комнату из четырёх песен: A — чемпион, B выбыла
+// в финале, C и D — в первом раунде, причём у D больше голосов.
+func seedStandingsRoom(t *testing.T, ownerID int) (roomID int, songs map[string]int) {
+	t.Helper()
+	roomID = mustCreateRoom(t, ownerID, "Friday")
+	mustExec(t, `UPDATE room SET topic = 'Indie' WHERE room_id = $1`, roomID)
+	songs = map[string]int{
+		"A": mustAddSong(t, roomID, ownerID, "Song A", "Artist A", "trackA"),
+		"B": mustAddSong(t, roomID, ownerID, "Song B", "Artist B", "trackB"),
+		"C": mustAddSong(t, roomID, ownerID, "Song C", "Artist C", "trackC"),
+		"D": mustAddSong(t, roomID, ownerID, "Song D", "Artist D", "trackD"),
+	}
+
+	voter := mustCreateUser(t, "Voter")
+	for _, vote := range []struct {
+		song  string
+		round int
+	}{{"D", 1}, {"D", 1}, {"C", 1}, {"A", 1}, {"B", 1}, {"A", 2}} {
+		mustExec(t, `INSERT INTO votes (user_id, song_id, room_id, round) VALUES ($1, $2, $3, $4)`,
+			voter, songs[vote.song], roomID, vote.round)
+	}
+	return roomID, songs
+}
+
+func eliminate(t *testing.T, songID, round int) {
+	t.Helper()
+	mustExec(t, `
+		INSERT INTO song_progress (song_id, eliminated, round) VALUES ($1, TRUE, $2)
+		ON CONFLICT (song_id) DO UPDATE SET eliminated = TRUE, round = EXCLUDED.round`, songID, round)
+}
+
+func TestFinalStandings(t *testing.T) {
+	openTestDB(t)
+	owner := mustCreateUser(t, "Owner")
+	roomID, songs := seedStandingsRoom(t, owner)
+	eliminate(t, songs["C"], 1)
+	eliminate(t, songs["D"], 1)
+	eliminate(t, songs["B"], 2)
+	mustExec(t, `INSERT INTO song_progress (song_id, eliminated, round) VALUES ($1, FALSE, 2)`, songs["A"])
+
+	standings, err := finalStandings(context.Background(), roomID)
+	if err != nil {
+		t.Fatalf("finalStandings: %v", err)
+	}
+	var got []int
+	for _, s := range standings {
+		got = append(got, s.SongID)
+	}
+	want := []int{songs["A"], songs["B"], songs["D"], songs["C"]}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("standings = %v, want %v (A, B, D, C)", got, want)
+	}
+	if standings[0].TrackID != "trackA" || standings[0].Provider != "spotify" {
+		t.Fatalf("champion track = %+v", standings[0])
+	}
+}
+
+func postExportPlaylist(t *testing.T, session, roomID string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/room/export-playlist?roomId="+roomID, nil)
+	if session != "" {
+		req.Header.Set("Authorization", "Bearer "+session)
+	}
+	rec := httptest.NewRecorder()
+	exportPlaylistHandler(rec, req)
+	return rec
+}
+
+func TestExportPlaylistHandler(t *testing.T) {
+	openTestDB(t)
+	fake := newFakeSpotify(t)
+	fake.addProfile("alice", "Alice")
+
+	_, alice := postExchange(t, `{"code": "code-alice"}`)
+	if alice.SessionToken == "" {
+		t.Fatalf("sign-in failed")
+	}
+	roomID, songs := seedStandingsRoom(t, alice.UserID)
+	room := strconv.Itoa(roomID)
+
+	if rec := postExportPlaylist(t, "", room); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("no session: status %d, want 401", rec.Code)
+	}
+	if rec := postExportPlaylist(t, alice.SessionToken, "999999"); rec.Code != http.StatusNotFound {
+		t.Fatalf("unknown room: status %d, want 404", rec.Code)
+	}
+	if rec := postExportPlaylist(t, alice.SessionToken, room); rec.Code != http.StatusConflict {
+		t.Fatalf("unfinished room: status %d, want 409", rec.Code)
+	}
+
+	eliminate(t, songs["C"], 1)
+	eliminate(t, songs["D"], 1)
+	eliminate(t, songs["B"], 2)
+
+	var first struct {
+		PlaylistID string `json:"playlistId"`
+		Name       string `json:"name"`
+		Tracks     int    `json:"tracks"`
+	}
+	rec := postExportPlaylist(t, alice.SessionToken, room)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("export: status %d: %s", rec.Code, rec.Body)
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &first); err != nil {
+		t.Fatalf("decode export response: %v", err)
+	}
+	if first.Name != "Friday — Indie" || first.Tracks != 4 {
+		t.Fatalf("unexpected export response %+v", first)
+	}
+	p := fake.getPlaylist(first.PlaylistID)
+	want := []string{"spotify:track:trackA", "spotify:track:trackB", "spotify:track:trackD", "spotify:track:trackC"}
+	if p == nil || p.Owner != "alice" || !reflect.DeepEqual(p.URIs, want) {
+		t.Fatalf("exported playlist = %+v, want tracks %v", p, want)
+	}
+
+	// Повторная выгрузка обновляет сохранённый плейлист, а не создаёт новый.
+	if rec := postExportPlaylist(t, alice.SessionToken, room); rec.Code != http.StatusOK {
+		t.Fatalf("re-export: status %d: %s", rec.Code, rec.Body)
+	}
+	if n := fake.countRequests("POST /v1/users/alice/playlists"); n != 1 {
+		t.Fatalf("created %d playlists, want 1", n)
+	}
+	var saved string
+	if err := db.QueryRow(context.Background(), `
+		SELECT playlist_id FROM room_playlist WHERE room_id = $1 AND user_id = $2`, roomID, alice.UserID).Scan(&saved); err != nil {
+		t.Fatalf("load room_playlist: %v", err)
+	}
+	if saved != first.PlaylistID {
+		t.Fatalf("room_playlist = %s, want %s", saved, first.PlaylistID)
+	}
+}
+
+func TestExportPlaylistRequiresSpotify(t *testing.T) {
+	openTestDB(t)
+	newFakeSpotify(t)
+	userID := mustCreateUser(t, "Offline")
+	roomID := mustCreateRoom(t, userID, "Room")
+	mustAddSong(t, roomID, userID, "A", "X", "a")
+	b := mustAddSong(t, roomID, userID, "B", "Y", "b")
+	eliminate(t, b, 1)
+
+	session, _, err := createSession(context.Background(), userID)
+	if err != nil {
+		t.Fatalf("create session: %v", err)
+	}
+	if rec := postExportPlaylist(t, session, strconv.Itoa(roomID)); rec.Code != http.StatusPreconditionFailed {
+		t.Fatalf("no linked account: status %d, want 412", rec.Code)
+	}
+}
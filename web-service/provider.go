@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// ProviderTrack описывает трек в каталоге конкретного музыкального сервиса.
+type ProviderTrack struct {
+	Provider    string `json:"provider"`
+	ID          string `json:"trackId"`
+	Title       string `json:"trackName"`
+	Artist      string `json:"artistName"`
+	AlbumArtURL string `json:"albumUrl"`
+	ISRC        string `json:"isrc,omitempty"`
+	DurationMs  int    `json:"durationMs,omitempty"`
+	PreviewURL  string `json:"previewUrl,omitempty"`
+}
+
+// PlaylistExport — что нужно записать в плейлист пользователя. Если ExistingID
+// указан и плейлист ещё существует, он обновляется, иначе создаётся новый.
+type PlaylistExport struct {
+	ExistingID  string
+	Name        string
+	Description string
+	TrackIDs    []string
+}
+
+type ExportedPlaylist struct {
+	ID  string `json:"playlistId"`
+	URL string `json:"playlistUrl"`
+}
+
+// MusicProvider — общий интерфейс музыкальных сервисов. accessToken — токен
+// пользователя у провайдера; для публичных операций он может быть пустым.
+type MusicProvider interface {
+	Name() string
+	Search(ctx context.Context, accessToken, query string, limit int) ([]ProviderTrack, error)
+	Track(ctx context.Context, accessToken, id string) (*ProviderTrack, error)
+	FindByISRC(ctx context.Context, accessToken, isrc string) (*ProviderTrack, error)
+	ExportPlaylist(ctx context.Context, accessToken string, export PlaylistExport) (*ExportedPlaylist, error)
+}
+
+var (
+	musicProviders = map[string]MusicProvider{}
+
+	errUnknownProvider = errors.New("unknown music provider")
+	errTrackNotFound   = errors.New("track not found")
+)
+
+const defaultProvider = "spotify"
+
+func registerProvider(p MusicProvider) {
+	musicProviders[p.Name()] = p
+}
+
+func providerByName(name string) (MusicProvider, error) {
+	if name == "" {
+		name = defaultProvider
+	}
+	p, ok := musicProviders[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", errUnknownProvider, name)
+	}
+	return p, nil
+}
+
+// minMatchScore — порог похожести для нечёткого сопоставления по названию и исполнителю.
+const minMatchScore = 0.8
+
+// matchTrack ищет в каталоге провайдера трек, соответствующий source: сначала
+// по ISRC, затем по нечёткому совпадению названия и исполнителя.
+func matchTrack(ctx context.Context, p MusicProvider, accessToken string, source ProviderTrack) (*ProviderTrack, error) {
+	if source.Provider == p.Name() && source.ID != "" {
+		return &source, nil
+	}
+
+	if source.ISRC != "" {
+		found, err := p.FindByISRC(ctx, accessToken, source.ISRC)
+		if err == nil {
+			return found, nil
+		}
+		if !errors.Is(err, errTrackNotFound) {
+			return nil, err
+		}
+	}
+
+	candidates, err := p.Search(ctx, accessToken, source.Artist+" "+source.Title, 10)
+	if err != nil {
+		return nil, err
+	}
+
+	var best *ProviderTrack
+	bestScore := 0.0
+	for i := range candidates {
+		score := matchScore(source, candidates[i])
+		if score > bestScore {
+			best, bestScore = &candidates[i], score
+		}
+	}
+	if best == nil || bestScore < minMatchScore {
+		return nil, errTrackNotFound
+	}
+	return best, nil
+}
+
+func matchScore(a, b ProviderTrack) float64 {
+	score := 0.6*similarity(normalizeTitle(a.Title), normalizeTitle(b.Title)) +
+		0.4*similarity(normalizeText(a.Artist), normalizeText(b.Artist))
+	if a.DurationMs > 0 && b.DurationMs > 0 {
+		diff := a.DurationMs - b.DurationMs
+		if diff < 0 {
+			diff = -diff
+		}
+		if diff > 10000 {
+			score -= 0.1
+		}
+	}
+	return score
+}
+
+// normalizeTitle отбрасывает пометки вроде "(feat. ...)" и "- Remastered 2011".
+func normalizeTitle(s string) string {
+	if i := strings.IndexAny(s, "(["); i > 0 {
+		s = s[:i]
+	}
+	if i := strings.Index(s, " - "); i > 0 {
+		s = s[:i]
+	}
+	return normalizeText(s)
+}
+
+func normalizeText(s string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range strings.ToLower(s) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			b.WriteRune(r)
+			space = false
+		case !space && b.Len() > 0:
+			b.WriteRune(' ')
+			space = true
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// similarity возвращает 1 - нормированное расстояние Левенштейна.
+func similarity(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	if len(ra) == 0 && len(rb) == 0 {
+		return 1
+	}
+
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min3(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+
+	longest := len(ra)
+	if len(rb) > longest {
+		longest = len(rb)
+	}
+	return 1 - float64(prev[len(rb)])/float64(longest)
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// songProviderTrackIDs возвращает id песен комнаты в каталоге провайдера p,
+// сопоставляя их при необходимости и кешируя результат в track_match.
+func songProviderTrackIDs(ctx context.Context, p MusicProvider, accessToken string, songs []Track) []string {
+	var ids []string
+	for _, song := range songs {
+		source := ProviderTrack{
+			Provider: song.Provider,
+			ID:       song.TrackID,
+			Title:    song.TrackName,
+			Artist:   song.ArtistName,
+		}
+
+		var cached string
+		err := db.QueryRow(ctx, `
+			SELECT track_id FROM track_match WHERE song_id = $1 AND provider = $2`,
+			song.SongID, p.Name()).Scan(&cached)
+		if err == nil {
+			ids = append(ids, cached)
+			continue
+		}
+
+		if err := db.QueryRow(ctx, `SELECT COALESCE(isrc, '') FROM song WHERE song_id = $1`, song.SongID).Scan(&source.ISRC); err != nil {
+			log.Println("Error fetching song isrc:", err)
+		}
+
+		found, err := matchTrack(ctx, p, accessToken, source)
+		if err != nil {
+			log.Printf("Could not match song %d (%s - %s) on %s: %v\n", song.SongID, song.ArtistName, song.TrackName, p.Name(), err)
+			continue
+		}
+
+		if _, err := db.Exec(ctx, `
+			INSERT INTO track_match (song_id, provider, track_id) VALUES ($1, $2, $3)
+			ON CONFLICT (song_id, provider) DO UPDATE SET track_id = EXCLUDED.track_id`,
+			song.SongID, p.Name(), found.ID); err != nil {
+			log.Println("Error saving track match:", err)
+		}
+		ids = append(ids, found.ID)
+	}
+	return ids
+}
+
+// providerAccessToken возвращает токен пользователя у провайдера: для Spotify —
+// сохранённый на сервере, для остальных — из заголовка X-Provider-Token.
+func providerAccessToken(r *http.Request, p MusicProvider, userID int) (string, error) {
+	if p.Name() == "spotify" {
+		token, _, err := spotifyAccessToken(r.Context(), userID)
+		return token, err
+	}
+	return r.Header.Get("X-Provider-Token"), nil
+}
+
+func trackMatchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	songID, err := strconv.Atoi(r.URL.Query().Get("songId"))
+	if err != nil {
+		http.Error(w, "songId is required", http.StatusBadRequest)
+		return
+	}
+	provider, err := providerByName(r.URL.Query().Get("provider"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	var song Track
+	err = db.QueryRow(ctx, `
+		SELECT song_id, track_name, artist_name, album_url, COALESCE(provider, 'spotify'), COALESCE(provider_track_id, '')
+		  FROM song
+		 WHERE song_id = $1`, songID).Scan(&song.SongID, &song.TrackName, &song.ArtistName, &song.AlbumURL, &song.Provider, &song.TrackID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.Error(w, "Song not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println("Error fetching song:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	accessToken, err := providerAccessToken(r, provider, userID)
+	if err != nil {
+		log.Println("Error getting provider token:", err)
+		http.Error(w, "Failed to get provider token", http.StatusBadGateway)
+		return
+	}
+
+	ids := songProviderTrackIDs(ctx, provider, accessToken, []Track{song})
+	if len(ids) == 0 {
+		http.Error(w, "No matching track found", http.StatusNotFound)
+		return
+	}
+
+	match, err := provider.Track(ctx, accessToken, ids[0])
+	if err != nil {
+		log.Println("Error fetching matched track:", err)
+		http.Error(w, "Failed to fetch matched track", http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(match)
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+var bohemianRhapsody = ProviderTrack{
+	Provider:    "spotify",
+	ID:          "7tFiyTwD0nx5a1eklYtX2J",
+	Title:       "Bohemian Rhapsody - Remastered 2011",
+	Artist:      "Queen",
+	AlbumArtURL: "https://i.scdn.co/image/ab67616d0000b273ce4f1737bc8a646c8c4bd25a",
+	ISRC:        "GBUM71029604",
+	DurationMs:  354320,
+	PreviewURL:  "https://p.scdn.co/mp3-preview/1f3bd078c7ad27b427fa210f6efd957fc5eecea0",
+}
+
+func TestSpotifyProviderCatalog(t *testing.T) {
+	fake := newFakeSpotify(t)
+	fake.addProfile("alice", "Alice")
+	ctx := context.Background()
+
+	found, err := spotify.Search(ctx, "access-alice", "Queen Bohemian Rhapsody", 10)
+	if err != nil {
+		t.Fatalf("search: %v", err)
+	}
+	if len(found) != 3 || !reflect.DeepEqual(found[0], bohemianRhapsody) {
+		t.Fatalf("search returned %+v", found)
+	}
+	if found[2].Artist != "The Muppets, Animal" || found[2].AlbumArtURL != "" {
+		t.Fatalf("multi-artist track = %+v", found[2])
+	}
+
+	track, err := spotify.Track(ctx, "access-alice", bohemianRhapsody.ID)
+	if err != nil || !reflect.DeepEqual(*track, bohemianRhapsody) {
+		t.Fatalf("track = %+v, %v", track, err)
+	}
+	if _, err := spotify.Track(ctx, "access-alice", "doesNotExist"); !errors.Is(err, errTrackNotFound) {
+		t.Fatalf("unknown track: got %v, want errTrackNotFound", err)
+	}
+
+	byISRC, err := spotify.FindByISRC(ctx, "access-alice", "GBUM71029604")
+	if err != nil || byISRC.ID != bohemianRhapsody.ID {
+		t.Fatalf("find by isrc = %+v, %v", byISRC, err)
+	}
+	if _, err := spotify.FindByISRC(ctx, "access-alice", "XX0000000000"); !errors.Is(err, errTrackNotFound) {
+		t.Fatalf("unknown isrc: got %v, want errTrackNotFound", err)
+	}
+
+	var apiErr *spotifyError
+	if _, err := spotify.Search(ctx, "access-nobody", "Queen", 10); !errors.As(err, &apiErr) || apiErr.StatusCode != 401 {
+		t.Fatalf("search with bad token: got %v, want 401", err)
+	}
+}
+
+func TestDeezerProviderCatalog(t *testing.T) {
+	deezer := newFakeDeezer(t).client
+	ctx := context.Background()
+
+	want := ProviderTrack{
+		Provider:    "deezer",
+		ID:          "1109731",
+		Title:       "Bohemian Rhapsody (Remastered 2011)",
+		Artist:      "Queen",
+		AlbumArtURL: "https://e-cdns-images.dzcdn.net/images/cover/4ddb5eb1d8faafb17ba8ad8dea6d2e35/250x250-000000-80-0-0.jpg",
+		ISRC:        "GBUM71029604",
+		DurationMs:  354000,
+		PreviewURL:  "https://cdns-preview-6.dzcdn.net/stream/c-6da8f2b2c5c2d52bc1d6a2b4c4ab0bb3-8.mp3",
+	}
+
+	track, err := deezer.Track(ctx, "", "1109731")
+	if err != nil || !reflect.DeepEqual(*track, want) {
+		t.Fatalf("track = %+v, %v", track, err)
+	}
+	byISRC, err := deezer.FindByISRC(ctx, "", "GBUM71029604")
+	if err != nil || byISRC.ID != want.ID {
+		t.Fatalf("find by isrc = %+v, %v", byISRC, err)
+	}
+	if _, err := deezer.Track(ctx, "", "42"); !errors.Is(err, errTrackNotFound) {
+		t.Fatalf("unknown track: got %v, want errTrackNotFound", err)
+	}
+
+	found, err := deezer.Search(ctx, "", "Queen Bohemian Rhapsody", 10)
+	if err != nil || len(found) != 1 || found[0].ID != want.ID {
+		t.Fatalf("search = %+v, %v", found, err)
+	}
+	if found, err := deezer.Search(ctx, "", "nothing recorded", 10); err != nil || len(found) != 0 {
+		t.Fatalf("empty search = %+v, %v", found, err)
+	}
+}
+
+func TestDeezerExportPlaylist(t *testing.T) {
+	fake := newFakeDeezer(t)
+	ctx := context.Background()
+
+	created, err := fake.client.ExportPlaylist(ctx, fakeDeezerToken, PlaylistExport{
+		Name: "Friday", Description: "results", TrackIDs: []string{"1109731", "1109737"},
+	})
+	if err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	p := fake.getPlaylist(created.ID)
+	if p == nil || p.Name != "Friday" || p.Description != "results" || !reflect.DeepEqual(p.URIs, []string{"1109731", "1109737"}) {
+		t.Fatalf("created playlist = %+v", p)
+	}
+
+	// Повторная выгрузка очищает существующий плейлист и записывает новый порядок.
+	updated, err := fake.client.ExportPlaylist(ctx, fakeDeezerToken, PlaylistExport{
+		ExistingID: created.ID, Name: "Friday — final", TrackIDs: []string{"1109737"},
+	})
+	if err != nil {
+		t.Fatalf("re-export: %v", err)
+	}
+	p = fake.getPlaylist(created.ID)
+	if updated.ID != created.ID || p.Name != "Friday — final" || !reflect.DeepEqual(p.URIs, []string{"1109737"}) {
+		t.Fatalf("updated playlist %s = %+v", updated.ID, p)
+	}
+
+	recreated, err := fake.client.ExportPlaylist(ctx, fakeDeezerToken, PlaylistExport{ExistingID: "1", Name: "Again"})
+	if err != nil || recreated.ID == created.ID {
+		t.Fatalf("export over a deleted playlist = %+v, %v", recreated, err)
+	}
+
+	var dzErr *deezerError
+	if _, err := fake.client.ExportPlaylist(ctx, "bad", PlaylistExport{Name: "x"}); !errors.As(err, &dzErr) || dzErr.Code != 300 {
+		t.Fatalf("export with bad token: got %v, want OAuth error", err)
+	}
+}
+
+func TestMatchTrack(t *testing.T) {
+	fake := newFakeSpotify(t)
+	fake.addProfile("alice", "Alice")
+	deezer := newFakeDeezer(t).client
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		provider MusicProvider
+		token    string
+		source   ProviderTrack
+		wantID   string
+		wantErr  error
+	}{
+		{
+			name:     "same provider keeps the id",
+			provider: spotify,
+			source:   ProviderTrack{Provider: "spotify", ID: "abc"},
+			wantID:   "abc",
+		},
+		{
+			name:     "spotify to deezer by isrc",
+			provider: deezer,
+			source:   bohemianRhapsody,
+			wantID:   "1109731",
+		},
+		{
+			name:     "deezer to spotify by isrc",
+			provider: spotify,
+			token:    "access-alice",
+			source:   ProviderTrack{Provider: "deezer", ID: "1109731", Title: "Bohemian Rhapsody (Remastered 2011)", Artist: "Queen", ISRC: "GBUM71029604"},
+			wantID:   "7tFiyTwD0nx5a1eklYtX2J",
+		},
+		{
+			// Живая версия совпадает по названию, но отличается длительностью.
+			name:     "fuzzy match prefers the studio version",
+			provider: deezer,
+			source:   ProviderTrack{Provider: "spotify", ID: "5T8EDUDqKcs6OSOwEsfqG7", Title: "Don't Stop Me Now - Remastered 2011", Artist: "Queen", DurationMs: 209413},
+			wantID:   "1109737",
+		},
+		{
+			name:     "unknown isrc falls back to title search",
+			provider: spotify,
+			token:    "access-alice",
+			source:   ProviderTrack{Provider: "deezer", ID: "1", Title: "Bohemian Rhapsody", Artist: "Queen", ISRC: "XX0000000000"},
+			wantID:   "7tFiyTwD0nx5a1eklYtX2J",
+		},
+		{
+			name:     "no close candidate",
+			provider: deezer,
+			source:   ProviderTrack{Provider: "spotify", ID: "x", Title: "Demo Tape", Artist: "Nobody"},
+			wantErr:  errTrackNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := matchTrack(ctx, tt.provider, tt.token, tt.source)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("got %+v, %v; want %v", got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got.ID != tt.wantID {
+				t.Fatalf("got %+v, %v; want id %s", got, err, tt.wantID)
+			}
+		})
+	}
+}
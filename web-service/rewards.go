@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// Поощрения, чтобы проигравшийся игрок мог вернуться в игру:
+//   - ежедневная выдача монет по запросу, раз в календарный день;
+//   - пополнение до минимального баланса, если монет почти не осталось (с
+//     учётом ставок, ещё лежащих в эскроу), не чаще раза за кулдаун;
+//   - бонус за серию дней подряд, в которые игрок доиграл турнир.
+//
+// Все начисления — проводки с house на счёт игрока.
+var (
+	dailyAllowance     = getEnvInt("DAILY_ALLOWANCE", 100)
+	topUpThreshold     = getEnvInt("TOPUP_THRESHOLD", 50)
+	topUpTarget        = getEnvInt("TOPUP_TARGET", 200)
+	topUpCooldown      = getEnvDuration("TOPUP_COOLDOWN", 24*time.Hour)
+	streakBonus        = getEnvInt("STREAK_BONUS", 25)
+	streakBonusMaxDays = getEnvInt("STREAK_BONUS_MAX_DAYS", 7)
+)
+
+var (
+	errAlreadyClaimed  = errors.New("daily allowance already claimed today")
+	errNotBroke        = errors.New("balance is above the top-up threshold")
+	errTopUpCooldown   = errors.New("top-up is on cooldown")
+	errUnknownRewardee = errors.New("user not found")
+)
+
+type RewardStatus struct {
+	Balance          int        `json:"balance"`
+	Escrowed         int        `json:"escrowed"`
+	DailyAllowance   int        `json:"dailyAllowance"`
+	DailyClaimable   bool       `json:"dailyClaimable"`
+	TopUpAvailable   bool       `json:"topUpAvailable"`
+	TopUpAvailableAt *time.Time `json:"topUpAvailableAt,omitempty"`
+	Streak           int        `json:"streak"`
+	LastPlayedOn     *time.Time `json:"lastPlayedOn,omitempty"`
+}
+
+type RewardGrant struct {
+	Amount  int `json:"amount"`
+	Balance int `json:"balance"`
+}
+
+func rewardErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errAlreadyClaimed), errors.Is(err, errNotBroke), errors.Is(err, errTopUpCooldown):
+		return http.StatusConflict
+	case errors.Is(err, errUnknownRewardee):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// lockRewards блокирует пользователя и его строку поощрений и возвращает баланс.
+func lockRewards(ctx context.Context, tx pgx.Tx, userID int) (int, error) {
+	var balance int
+	err := tx.QueryRow(ctx, `SELECT COALESCE(balance, 0) FROM "user" WHERE user_id = $1 FOR UPDATE`, userID).Scan(&balance)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, errUnknownRewardee
+	}
+	if err != nil {
+		return 0, fmt.Errorf("lock user: %w", err)
+	}
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO user_reward (user_id) VALUES ($1) ON CONFLICT DO NOTHING`, userID); err != nil {
+		return 0, fmt.Errorf("init rewards: %w", err)
+	}
+	return balance, nil
+}
+
+// openStakes возвращает сумму ставок пользователя, которые ещё лежат в эскроу:
+// нерассчитанные ставки на песни и на рынках. Ставки из банка команды —
+// не его монеты и не учитываются.
+func openStakes(ctx context.Context, q queryRower, userID int) (int, error) {
+	var staked int
+	err := q.QueryRow(ctx, `
+		SELECT COALESCE((SELECT SUM(bet_amount) FROM bets
+		                  WHERE user_id = $1 AND team_id IS NULL AND settled_at IS NULL), 0)
+		     + COALESCE((SELECT SUM(stake) FROM market_bet
+		                  WHERE user_id = $1 AND team_id IS NULL AND settled_at IS NULL), 0)`,
+		userID).Scan(&staked)
+	if err != nil {
+		return 0, fmt.Errorf("sum open stakes: %w", err)
+	}
+	return staked, nil
+}
+
+func rewardStatus(ctx context.Context, userID int) (*RewardStatus, error) {
+	s := &RewardStatus{DailyAllowance: dailyAllowance}
+	var lastTopUp *time.Time
+	err := db.QueryRow(ctx, `
+		SELECT COALESCE(u.balance, 0),
+		       r.last_daily_claim IS NULL OR r.last_daily_claim < CURRENT_DATE,
+		       r.last_topup_at,
+		       CASE WHEN r.last_played_on >= CURRENT_DATE - 1 THEN COALESCE(r.streak_days, 0) ELSE 0 END,
+		       r.last_played_on::timestamptz
+		  FROM "user" u
+	 LEFT JOIN user_reward r ON r.user_id = u.user_id
+		 WHERE u.user_id = $1`, userID).Scan(&s.Balance, &s.DailyClaimable, &lastTopUp, &s.Streak, &s.LastPlayedOn)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errUnknownRewardee
+	}
+	if err != nil {
+		return nil, fmt.Errorf("fetch rewards: %w", err)
+	}
+	if s.Escrowed, err = openStakes(ctx, db, userID); err != nil {
+		return nil, err
+	}
+	if lastTopUp != nil {
+		at := lastTopUp.Add(topUpCooldown)
+		if time.Now().Before(at) {
+			s.TopUpAvailableAt = &at
+		}
+	}
+	s.TopUpAvailable = topUpAmount(s.Balance+s.Escrowed) > 0 && s.TopUpAvailableAt == nil
+	return s, nil
+}
+
+// claimDailyAllowance выдаёт ежедневные монеты, если сегодня их ещё не брали.
+func claimDailyAllowance(ctx context.Context, userID int) (*RewardGrant, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(context.Background())
+
+	balance, err := lockRewards(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	tag, err := tx.Exec(ctx, `
+		UPDATE user_reward SET last_daily_claim = CURRENT_DATE
+		 WHERE user_id = $1 AND (last_daily_claim IS NULL OR last_daily_claim < CURRENT_DATE)`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("mark daily claim: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, errAlreadyClaimed
+	}
+	if _, err := transfer(ctx, tx, houseAccount, userAccount(userID), dailyAllowance, reasonDailyAllowance, ""); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return &RewardGrant{Amount: dailyAllowance, Balance: balance + dailyAllowance}, nil
+}
+
+// topUpAmount — сколько монет доплатить игроку, у которого holdings монет на
+// счёте и в эскроу. 0 — игрок не разорён, в том числе когда порог пополнения
+// задан не ниже цели.
+func topUpAmount(holdings int) int {
+	if holdings >= topUpThreshold || holdings >= topUpTarget {
+		return 0
+	}
+	return topUpTarget - holdings
+}
+
+// claimTopUp пополняет баланс разорившегося игрока до topUpTarget. Монеты в
+// эскроу считаются его: игрок, поставивший всё, не разорён, пока ставки не
+// рассчитаны.
+func claimTopUp(ctx context.Context, userID int) (*RewardGrant, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(context.Background())
+
+	balance, err := lockRewards(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	escrowed, err := openStakes(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	amount := topUpAmount(balance + escrowed)
+	if amount <= 0 {
+		return nil, errNotBroke
+	}
+	tag, err := tx.Exec(ctx, `
+		UPDATE user_reward SET last_topup_at = now()
+		 WHERE user_id = $1 AND (last_topup_at IS NULL OR last_topup_at <= now() - make_interval(secs => $2))`,
+		userID, topUpCooldown.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("mark top-up: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, errTopUpCooldown
+	}
+	if _, err := transfer(ctx, tx, houseAccount, userAccount(userID), amount, reasonTopUp, ""); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return &RewardGrant{Amount: amount, Balance: balance + amount}, nil
+}
+
+// recordPlayStreaks отмечает игровой день игроков доигранного турнира и
+// платит бонус за серию: streakBonus за каждый день серии после первого, но
+// не больше чем за streakBonusMaxDays дней. Второй турнир за день серию не
+// продлевает.
+func recordPlayStreaks(ctx context.Context, tx pgx.Tx, roomID int) error {
+	rows, err := tx.Query(ctx, `
+		INSERT INTO user_reward (user_id, streak_days, last_played_on)
+		SELECT p.user_id, 1, CURRENT_DATE
+		  FROM participation p
+		  JOIN "user" u ON u.user_id = p.user_id
+		 WHERE p.room_id = $1 AND p.role = 'player' AND p.left_at IS NULL AND NOT u.is_bot
+		ON CONFLICT (user_id) DO UPDATE
+		   SET streak_days = CASE WHEN user_reward.last_played_on = CURRENT_DATE - 1
+		                          THEN user_reward.streak_days + 1 ELSE 1 END,
+		       last_played_on = CURRENT_DATE
+		 WHERE user_reward.last_played_on IS DISTINCT FROM CURRENT_DATE
+		RETURNING user_id, streak_days`, roomID)
+	if err != nil {
+		return fmt.Errorf("record play days: %w", err)
+	}
+	streaks := map[int]int{}
+	for rows.Next() {
+		var userID, days int
+		if err := rows.Scan(&userID, &days); err != nil {
+			rows.Close()
+			return fmt.Errorf("scan streak: %w", err)
+		}
+		streaks[userID] = days
+	}
+	rows.Close()
+
+	for userID, days := range streaks {
+		if days < 2 {
+			continue
+		}
+		paid := days
+		if paid > streakBonusMaxDays {
+			paid = streakBonusMaxDays
+		}
+		bonus := streakBonus * (paid - 1)
+		if _, err := transfer(ctx, tx, houseAccount, userAccount(userID), bonus, reasonBonus, fmt.Sprintf("streak:%d", days)); err != nil {
+			return fmt.Errorf("pay streak bonus to %d: %w", userID, err)
+		}
+	}
+	return nil
+}
+
+func rewardsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	status, err := rewardStatus(r.Context(), userID)
+	if err != nil {
+		if code := rewardErrorStatus(err); code != http.StatusInternalServerError {
+			http.Error(w, err.Error(), code)
+			return
+		}
+		log.Println("Error fetching rewards:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// rewardClaimHandler оборачивает выдачу монет: claim — daily или top-up.
+func rewardClaimHandler(claim func(context.Context, int) (*RewardGrant, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		userID, ok := requireSession(w, r)
+		if !ok {
+			return
+		}
+
+		grant, err := claim(r.Context(), userID)
+		if err != nil {
+			if code := rewardErrorStatus(err); code != http.StatusInternalServerError {
+				http.Error(w, err.Error(), code)
+				return
+			}
+			log.Println("Error granting reward:", err)
+			http.Error(w, "Failed to grant coins", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(grant)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgx/v4"
+)
+
+func TestTopUpAmount(t *testing.T) {
+	defer func(threshold, target int) { topUpThreshold, topUpTarget = threshold, target }(topUpThreshold, topUpTarget)
+
+	tests := []struct {
+		threshold, target, holdings, want int
+	}{
+		{50, 200, 10, 190},
+		{50, 200, 50, 0},
+		{300, 200, 100, 100},
+		{300, 200, 250, 0},
+		{200, 200, 199, 1},
+	}
+	for _, tt := range tests {
+		topUpThreshold, topUpTarget = tt.threshold, tt.target
+		if got := topUpAmount(tt.holdings); got != tt.want {
+			t.Errorf("threshold %d, target %d, holdings %d: amount = %d, want %d",
+				tt.threshold, tt.target, tt.holdings, got, tt.want)
+		}
+	}
+}
+
+func TestTopUpCountsEscrow(t *testing.T) {
+	openTestDB(t)
+	owner := mustCreateUser(t, "Owner")
+	guest := mustCreateUser(t, "Guest")
+	roomID := mustCreateRoom(t, owner, "Room")
+	for _, userID := range []int{owner, guest} {
+		mustExec(t, `INSERT INTO participation (user_id, room_id, role) VALUES ($1, $2, 'player')`, userID, roomID)
+	}
+	song := mustAddSong(t, roomID, guest, "A", "X", "")
+
+	// Всё, кроме 10 монет, поставлено: на счёте мало, но игрок не разорён.
+	if err := placeTestBets(t, roomID, owner, BetRequest{SongId: song, BetAmount: startingBalance - 10}); err != nil {
+		t.Fatalf("place bets: %v", err)
+	}
+	ctx := context.Background()
+	status, err := rewardStatus(ctx, owner)
+	if err != nil {
+		t.Fatalf("reward status: %v", err)
+	}
+	if status.TopUpAvailable || status.Escrowed != startingBalance-10 {
+		t.Fatalf("status with open stakes: %+v", status)
+	}
+	if _, err := claimTopUp(ctx, owner); !errors.Is(err, errNotBroke) {
+		t.Fatalf("top-up with open stakes: %v, want errNotBroke", err)
+	}
+
+	// Ставка проиграна: теперь игрок разорён и пополняется до topUpTarget.
+	if err := db.BeginFunc(ctx, func(tx pgx.Tx) error { return settleLostBets(ctx, tx, roomID) }); err != nil {
+		t.Fatalf("settle lost bets: %v", err)
+	}
+	grant, err := claimTopUp(ctx, owner)
+	if err != nil {
+		t.Fatalf("top-up after the loss: %v", err)
+	}
+	if grant.Amount != topUpTarget-10 || grant.Balance != topUpTarget || userBalance(t, owner) != topUpTarget {
+		t.Fatalf("grant %+v, balance %d", grant, userBalance(t, owner))
+	}
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// Запланированные турниры: владелец назначает время старта и окончание
+// регистрации, игроки заходят в комнату заранее. В назначенное время
+// планировщик запускает игру, если набралось не меньше min_players, иначе
+// отменяет турнир и сообщает об этом участникам.
+const roomCancelled = "cancelled"
+
+var schedulerInterval = getEnvDuration("TOURNAMENT_SCHEDULER_INTERVAL", 15*time.Second)
+
+type TournamentSchedule struct {
+	RoomID               int        `json:"roomId"`
+	Name                 string     `json:"name"`
+	Status               string     `json:"status"`
+	StartAt              *time.Time `json:"startAt"`
+	RegistrationClosesAt *time.Time `json:"registrationClosesAt"`
+	MinPlayers           int        `json:"minPlayers"`
+	Registered           int        `json:"registered"`
+	JoinCode             string     `json:"joinCode,omitempty"`
+}
+
+// registrationClosed сообщает, что регистрация в запланированный турнир уже закончилась.
+func registrationClosed(ctx context.Context, roomID int) (bool, error) {
+	var closed bool
+	err := db.QueryRow(ctx, `
+		SELECT COALESCE(registration_closes_at <= now(), FALSE) FROM room WHERE room_id = $1`,
+		roomID).Scan(&closed)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	return closed, err
+}
+
+func loadSchedule(ctx context.Context, roomID int) (*TournamentSchedule, error) {
+	s := &TournamentSchedule{}
+	err := db.QueryRow(ctx, `
+		SELECT r.room_id, r.name, r.status, r.scheduled_start_at, r.registration_closes_at,
+		       COALESCE(r.min_players, $2),
+		       (SELECT COUNT(*) FROM participation p
+		         WHERE p.room_id = r.room_id AND p.role = 'player' AND p.left_at IS NULL)
+		  FROM room r
+		 WHERE r.room_id = $1`, roomID, minPlayers).Scan(
+		&s.RoomID, &s.Name, &s.Status, &s.StartAt, &s.RegistrationClosesAt, &s.MinPlayers, &s.Registered)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// roomScheduleHandler: GET — расписание комнаты, POST — назначить или снять расписание.
+func roomScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		getScheduleHandler(w, r)
+	case http.MethodPost:
+		setScheduleHandler(w, r)
+	default:
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+	}
+}
+
+func getScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	roomID, err := strconv.Atoi(r.URL.Query().Get("roomId"))
+	if err != nil {
+		http.Error(w, "roomId is required", http.StatusBadRequest)
+		return
+	}
+
+	s, err := loadSchedule(r.Context(), roomID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.Error(w, "Room not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println("Error loading schedule:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s)
+}
+
+func setScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	callerID, ok := requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	// Пустой startAt снимает расписание.
+	var data struct {
+		RoomId               int        `json:"roomId"`
+		StartAt              *time.Time `json:"startAt"`
+		RegistrationClosesAt *time.Time `json:"registrationClosesAt"`
+		MinPlayers           int        `json:"minPlayers"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	var minCount *int
+	if data.StartAt != nil {
+		if !data.StartAt.After(time.Now()) {
+			http.Error(w, "startAt must be in the future", http.StatusBadRequest)
+			return
+		}
+		if data.RegistrationClosesAt == nil {
+			data.RegistrationClosesAt = data.StartAt
+		}
+		if data.RegistrationClosesAt.After(*data.StartAt) {
+			http.Error(w, "Registration must close before the start", http.StatusBadRequest)
+			return
+		}
+		if data.MinPlayers != 0 {
+			if data.MinPlayers < minPlayers || data.MinPlayers > maxPlayers {
+				http.Error(w, fmt.Sprintf("minPlayers must be between %d and %d", minPlayers, maxPlayers), http.StatusBadRequest)
+				return
+			}
+			minCount = &data.MinPlayers
+		}
+	} else {
+		data.RegistrationClosesAt = nil
+	}
+
+	tag, err := db.Exec(r.Context(), `
+		UPDATE room
+		   SET scheduled_start_at = $2,
+		       registration_closes_at = $3,
+		       min_players = $4,
+		       last_activity_at = now()
+		 WHERE room_id = $1 AND owner_id = $5 AND status = 'open'`,
+		data.RoomId, data.StartAt, data.RegistrationClosesAt, minCount, callerID)
+	if err != nil {
+		log.Println("Error scheduling room:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		http.Error(w, "Room not found, already started, or you are not the owner", http.StatusForbidden)
+		return
+	}
+
+	s, err := loadSchedule(r.Context(), data.RoomId)
+	if err != nil {
+		log.Println("Error loading schedule:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	publishRoomEvent(data.RoomId, "tournament_scheduled", s)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s)
+}
+
+// listScheduledRoomsHandler показывает предстоящие публичные турниры с открытой регистрацией.
+func listScheduledRoomsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	rows, err := db.Query(r.Context(), `
+		SELECT r.room_id, r.name, r.status, r.scheduled_start_at, r.registration_closes_at,
+		       COALESCE(r.min_players, $1),
+		       (SELECT COUNT(*) FROM participation p
+		         WHERE p.room_id = r.room_id AND p.role = 'player' AND p.left_at IS NULL),
+		       COALESCE(c.code, '')
+		  FROM room r
+	 LEFT JOIN room_join_code c ON c.room_id = r.room_id AND c.revoked_at IS NULL AND c.expires_at > now()
+		 WHERE r.is_public AND r.status = 'open'
+		   AND r.scheduled_start_at IS NOT NULL AND r.registration_closes_at > now()
+	  ORDER BY r.scheduled_start_at, r.room_id
+		 LIMIT 50`, minPlayers)
+	if err != nil {
+		log.Println("Error listing scheduled rooms:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	rooms := []TournamentSchedule{}
+	for rows.Next() {
+		var s TournamentSchedule
+		if err := rows.Scan(&s.RoomID, &s.Name, &s.Status, &s.StartAt, &s.RegistrationClosesAt,
+			&s.MinPlayers, &s.Registered, &s.JoinCode); err != nil {
+			log.Println("Error scanning scheduled room:", err)
+			continue
+		}
+		rooms = append(rooms, s)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rooms)
+}
+
+// cancelTournament отменяет турнир, который не набрал игроков к старту.
+func cancelTournament(ctx context.Context, roomID, registered, needed int) error {
+	cancelled := false
+	err := db.BeginFunc(ctx, func(tx pgx.Tx) error {
+		tag, err := tx.Exec(ctx, `
+			UPDATE room
+			   SET status = 'cancelled', cancelled_at = now(), last_activity_at = now()
+			 WHERE room_id = $1 AND status = 'open'`, roomID)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return nil
+		}
+		cancelled = true
+		// Ставки, сделанные в лобби, возвращаются вместе с отменой.
+		return refundRoomStakes(ctx, tx, roomID)
+	})
+	if err != nil {
+		return fmt.Errorf("cancel tournament: %w", err)
+	}
+	if !cancelled {
+		return nil
+	}
+	publishRoomEvent(roomID, "room_status", map[string]string{"status": roomCancelled})
+	publishRoomEvent(roomID, "tournament_cancelled", map[string]interface{}{
+		"reason":     "not_enough_players",
+		"registered": registered,
+		"minPlayers": needed,
+	})
+	return nil
+}
+
+// runScheduledStarts запускает или отменяет турниры, время старта которых наступило.
+func runScheduledStarts(ctx context.Context) {
+	rows, err := db.Query(ctx, `
+		SELECT room_id FROM room
+		 WHERE status = 'open' AND scheduled_start_at <= now()
+		 ORDER BY scheduled_start_at`)
+	if err != nil {
+		log.Println("Error selecting scheduled rooms:", err)
+		return
+	}
+	var due []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			log.Println("Error scanning scheduled room:", err)
+			continue
+		}
+		due = append(due, id)
+	}
+	rows.Close()
+
+	for _, roomID := range due {
+		err := startGame(ctx, roomID)
+		switch {
+		case err == nil:
+			log.Printf("Scheduled tournament %d started", roomID)
+			publishRoomEvent(roomID, "game_started", map[string]bool{"scheduled": true})
+		case errors.Is(err, errNotEnoughPlayers):
+			s, err := loadSchedule(ctx, roomID)
+			if err != nil {
+				log.Println("Error loading schedule:", err)
+				continue
+			}
+			if err := cancelTournament(ctx, roomID, s.Registered, s.MinPlayers); err != nil {
+				log.Println("Error cancelling tournament:", err)
+				continue
+			}
+			log.Printf("Scheduled tournament %d cancelled: %d of %d players", roomID, s.Registered, s.MinPlayers)
+		case errors.Is(err, errRoomClosed):
+		default:
+			log.Println("Error starting scheduled tournament:", err)
+		}
+	}
+}
+
+func runTournamentScheduler() {
+	ticker := time.NewTicker(schedulerInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), schedulerInterval)
+		runScheduledStarts(ctx)
+		cancel()
+	}
+}
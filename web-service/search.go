@@ -0,0 +1,273 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// searchCache — LRU-кеш результатов поиска с ограниченным временем жизни записей.
+type searchCache struct {
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	order    *list.List
+	items    map[string]*list.Element
+}
+
+type searchCacheEntry struct {
+	key       string
+	tracks    []Track
+	expiresAt time.Time
+}
+
+func newSearchCache(capacity int, ttl time.Duration) *searchCache {
+	return &searchCache{
+		capacity: capacity,
+		ttl:      ttl,
+		order:    list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+func (c *searchCache) get(key string) ([]Track, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	entry := el.Value.(*searchCacheEntry)
+	if time.Now().After(entry.expiresAt) {
+		c.order.Remove(el)
+		delete(c.items, key)
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return entry.tracks, true
+}
+
+func (c *searchCache) set(key string, tracks []Track) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		entry := el.Value.(*searchCacheEntry)
+		entry.tracks = tracks
+		entry.expiresAt = time.Now().Add(c.ttl)
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.items[key] = c.order.PushFront(&searchCacheEntry{key: key, tracks: tracks, expiresAt: time.Now().Add(c.ttl)})
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*searchCacheEntry).key)
+	}
+}
+
+// searchGroup объединяет одновременные одинаковые запросы: к провайдеру уходит
+// только первый, остальные ждут его результата.
+type searchGroup struct {
+	mu    sync.Mutex
+	calls map[string]*searchCall
+}
+
+type searchCall struct {
+	done   chan struct{}
+	tracks []Track
+	err    error
+}
+
+func (g *searchGroup) do(key string, fn func() ([]Track, error)) ([]Track, error) {
+	g.mu.Lock()
+	if call, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		<-call.done
+		return call.tracks, call.err
+	}
+	call := &searchCall{done: make(chan struct{})}
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	call.tracks, call.err = fn()
+	close(call.done)
+
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+	return call.tracks, call.err
+}
+
+// rateLimiter — token bucket на ключ (пользователя или адрес клиента).
+// Ведро, не тронутое дольше idle, успевает наполниться заново и ничем не
+// отличается от нового, поэтому такие вёдра периодически выбрасываются.
+type rateLimiter struct {
+	mu        sync.Mutex
+	rate      float64
+	burst     float64
+	idle      time.Duration
+	lastSweep time.Time
+	buckets   map[string]*rateBucket
+}
+
+type rateBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(perMinute, burst int) *rateLimiter {
+	rate := float64(perMinute) / 60
+	return &rateLimiter{
+		rate:      rate,
+		burst:     float64(burst),
+		idle:      time.Duration(float64(burst) / rate * float64(time.Second)),
+		lastSweep: time.Now(),
+		buckets:   make(map[string]*rateBucket),
+	}
+}
+
+func (l *rateLimiter) allow(key string) bool {
+	return l.allowAt(key, time.Now())
+}
+
+func (l *rateLimiter) allowAt(key string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) >= l.idle {
+		l.sweep(now)
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &rateBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * l.rate
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// sweep удаляет вёдра, простаивающие дольше idle. Вызывается не чаще раза
+// за idle, так что обход всей карты амортизируется по запросам.
+func (l *rateLimiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= l.idle {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+var (
+	trackSearchCache   *searchCache
+	trackSearchGroup   = &searchGroup{calls: make(map[string]*searchCall)}
+	trackSearchLimiter *rateLimiter
+)
+
+func initTrackSearch() {
+	trackSearchCache = newSearchCache(getEnvInt("SEARCH_CACHE_SIZE", 500), getEnvDuration("SEARCH_CACHE_TTL", 10*time.Minute))
+	trackSearchLimiter = newRateLimiter(getEnvInt("SEARCH_RATE_PER_MINUTE", 30), getEnvInt("SEARCH_RATE_BURST", 10))
+}
+
+func searchTracksHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		http.Error(w, "q is required", http.StatusBadRequest)
+		return
+	}
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 || limit > 50 {
+		limit = 20
+	}
+	provider, err := providerByName(r.URL.Query().Get("provider"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !trackSearchLimiter.allow(strconv.Itoa(userID)) {
+		w.Header().Set("Retry-After", "5")
+		http.Error(w, "Too many search requests", http.StatusTooManyRequests)
+		return
+	}
+
+	key := provider.Name() + "|" + strconv.Itoa(limit) + "|" + strings.ToLower(query)
+	if tracks, ok := trackSearchCache.get(key); ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Cache", "HIT")
+		json.NewEncoder(w).Encode(tracks)
+		return
+	}
+
+	accessToken, err := providerAccessToken(r, provider, userID)
+	if errors.Is(err, errNotConnected) {
+		http.Error(w, "Spotify account is not connected", http.StatusPreconditionFailed)
+		return
+	}
+	if err != nil {
+		log.Println("Error getting provider token:", err)
+		http.Error(w, "Failed to get provider token", http.StatusBadGateway)
+		return
+	}
+
+	tracks, err := trackSearchGroup.do(key, func() ([]Track, error) {
+		// Запрос общий для всех ожидающих, поэтому не привязан к контексту одного клиента.
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		found, err := provider.Search(ctx, accessToken, query, limit)
+		if err != nil {
+			return nil, err
+		}
+		tracks := make([]Track, 0, len(found))
+		for _, t := range found {
+			tracks = append(tracks, Track{
+				TrackName:  t.Title,
+				ArtistName: t.Artist,
+				AlbumURL:   t.AlbumArtURL,
+				Provider:   t.Provider,
+				TrackID:    t.ID,
+			})
+		}
+		trackSearchCache.set(key, tracks)
+		return tracks, nil
+	})
+	if err != nil {
+		log.Println("Error searching tracks:", err)
+		http.Error(w, "Failed to search tracks", http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Cache", "MISS")
+	json.NewEncoder(w).Encode(tracks)
+}
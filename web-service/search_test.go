@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterEvictsIdleBuckets(t *testing.T) {
+	// 60 в минуту и запас 2: пустое ведро наполняется за две секунды.
+	l := newRateLimiter(60, 2)
+	start := l.lastSweep
+
+	if !l.allowAt("a", start) || !l.allowAt("a", start) {
+		t.Fatalf("burst requests were limited")
+	}
+	if l.allowAt("a", start) {
+		t.Fatalf("request over the burst was allowed")
+	}
+	if !l.allowAt("a", start.Add(time.Second)) {
+		t.Fatalf("bucket did not refill")
+	}
+
+	for i := 0; i < 1000; i++ {
+		l.allowAt("ip-"+strconv.Itoa(i), start.Add(time.Second))
+	}
+	if len(l.buckets) != 1001 {
+		t.Fatalf("buckets = %d, want 1001", len(l.buckets))
+	}
+
+	// Через idle все вёдра полны и выбрасываются, остаётся только новое.
+	later := start.Add(time.Second + l.idle)
+	if !l.allowAt("b", later) {
+		t.Fatalf("fresh key was limited")
+	}
+	if len(l.buckets) != 1 {
+		t.Fatalf("buckets after sweep = %d, want 1", len(l.buckets))
+	}
+
+	// Выброшенное ведро ведёт себя как полное.
+	if !l.allowAt("a", later) || !l.allowAt("a", later) || l.allowAt("a", later) {
+		t.Fatalf("evicted bucket did not come back with a full burst")
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// Итог каждого матча фиксируется в match_settlement. Выбывание песни,
+// выплаты по ставкам и переход к следующему раунду проводятся в одной
+// транзакции вместе с этой записью, поэтому матч рассчитывается ровно один
+// раз, а повторный вызов возвращает сохранённый итог.
+
+var (
+	errRoundMismatch   = errors.New("round has not started yet")
+	errMatchNotSettled = errors.New("no settlement for this round")
+)
+
+type BetPayout struct {
+	BetID  int  `json:"betId"`
+	UserID int  `json:"userId"`
+	TeamID *int `json:"teamId,omitempty"`
+	Stake  int  `json:"stake"`
+	Payout int  `json:"payout"`
+}
+
+type MatchSettlement struct {
+	RoomID       int         `json:"roomId"`
+	Round        int         `json:"round"`
+	Song1ID      int         `json:"song1Id"`
+	Song2ID      int         `json:"song2Id"`
+	Votes1       float64     `json:"votes1"`
+	Votes2       float64     `json:"votes2"`
+	WinnerSongID int         `json:"winnerSongId"`
+	LoserSongID  int         `json:"loserSongId"`
+	Payouts      []BetPayout `json:"payouts"`
+	Finished     bool        `json:"finished"`
+	NextSong1ID  int         `json:"nextSong1Id,omitempty"`
+	NextSong2ID  int         `json:"nextSong2Id,omitempty"`
+	SettledAt    time.Time   `json:"settledAt"`
+	Replayed     bool        `json:"replayed"`
+}
+
+func settlementErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errRoundMismatch):
+		return http.StatusConflict
+	case errors.Is(err, errMatchNotSettled), errors.Is(err, errNoMatchup), errors.Is(err, errRoomNotFound):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// loadSettlement возвращает сохранённый итог матча; nil — матч ещё не рассчитан.
+func loadSettlement(ctx context.Context, q queryRower, roomID, round int) (*MatchSettlement, error) {
+	var outcome []byte
+	err := q.QueryRow(ctx, `
+		SELECT outcome FROM match_settlement WHERE room_id = $1 AND round = $2`,
+		roomID, round).Scan(&outcome)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load settlement: %w", err)
+	}
+	var s MatchSettlement
+	if err := json.Unmarshal(outcome, &s); err != nil {
+		return nil, fmt.Errorf("decode settlement: %w", err)
+	}
+	s.Replayed = true
+	return &s, nil
+}
+
+// settleMatch рассчитывает матч раунда round. Раунд задаёт клиент явно:
+// повторный вызов для уже рассчитанного раунда возвращает его итог без
+// изменений и не может закрыть следующий матч.
+func settleMatch(ctx context.Context, roomID, round int) (*MatchSettlement, error) {
+	// Пара первого матча выбирается до блокировки комнаты.
+	_, _, _, err := selectMatchup(ctx, roomID)
+	if errors.Is(err, errNoMatchup) {
+		if s, err := loadSettlement(ctx, db, roomID, round); s != nil || err != nil {
+			return s, err
+		}
+		return nil, errMatchNotSettled
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(context.Background())
+
+	s := &MatchSettlement{RoomID: roomID, Payouts: []BetPayout{}}
+	var song1, song2 *int
+	if err := tx.QueryRow(ctx, `
+		SELECT current_round, current_song1, current_song2 FROM room WHERE room_id = $1 FOR UPDATE`,
+		roomID).Scan(&s.Round, &song1, &song2); err != nil {
+		return nil, fmt.Errorf("lock room: %w", err)
+	}
+
+	// Пока ждали блокировку, матч мог рассчитать параллельный запрос.
+	if round != s.Round {
+		if round > s.Round {
+			return nil, errRoundMismatch
+		}
+		prev, err := loadSettlement(ctx, tx, roomID, round)
+		if err == nil && prev == nil {
+			err = errMatchNotSettled
+		}
+		return prev, err
+	}
+	if song1 == nil || song2 == nil {
+		return nil, errNoMatchup
+	}
+	s.Song1ID, s.Song2ID = *song1, *song2
+
+	if s.Votes1, err = getSongVotes(ctx, tx, s.Song1ID, roomID, s.Round); err != nil {
+		return nil, fmt.Errorf("count votes for %d: %w", s.Song1ID, err)
+	}
+	if s.Votes2, err = getSongVotes(ctx, tx, s.Song2ID, roomID, s.Round); err != nil {
+		return nil, fmt.Errorf("count votes for %d: %w", s.Song2ID, err)
+	}
+
+	s.WinnerSongID, s.LoserSongID = s.Song1ID, s.Song2ID
+	if s.Votes2 > s.Votes1 || (s.Votes1 == s.Votes2 && rand.Intn(2) == 0) {
+		s.WinnerSongID, s.LoserSongID = s.Song2ID, s.Song1ID
+	}
+
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO song_progress (song_id, eliminated, round)
+		VALUES ($1, TRUE, $2 + 1)
+		ON CONFLICT (song_id) DO UPDATE SET eliminated = TRUE, round = EXCLUDED.round`,
+		s.LoserSongID, s.Round); err != nil {
+		return nil, fmt.Errorf("mark eliminated: %w", err)
+	}
+
+	var songs, remaining int
+	if err := tx.QueryRow(ctx, `
+		SELECT COUNT(*), COUNT(*) FILTER (WHERE sp.eliminated IS NULL OR sp.eliminated = FALSE)
+		  FROM song s
+	 LEFT JOIN song_progress sp ON s.song_id = sp.song_id
+		 WHERE s.room_id = $1`,
+		roomID).Scan(&songs, &remaining); err != nil {
+		return nil, fmt.Errorf("check finished: %w", err)
+	}
+
+	// Выбывшая песня занимает место сразу за оставшимися.
+	payouts, err := resolveBets(ctx, tx, roomID, s.LoserSongID, remaining+1, songs)
+	if err != nil {
+		return nil, fmt.Errorf("resolve bets: %w", err)
+	}
+	s.Payouts = append(s.Payouts, payouts...)
+
+	if remaining <= 1 {
+		s.Finished = true
+		if _, err := tx.Exec(ctx, `
+			UPDATE room
+			   SET current_round = current_round + 1,
+			       current_song1 = NULL,
+			       current_song2 = NULL,
+			       status = CASE WHEN status IN ('open', 'in_game') THEN 'finished' ELSE status END,
+			       finished_at = now(),
+			       last_activity_at = now()
+			 WHERE room_id = $1`, roomID); err != nil {
+			return nil, fmt.Errorf("finish tournament: %w", err)
+		}
+		payouts, err := resolveBets(ctx, tx, roomID, s.WinnerSongID, 1, songs)
+		if err != nil {
+			return nil, fmt.Errorf("resolve champion bets: %w", err)
+		}
+		s.Payouts = append(s.Payouts, payouts...)
+		if err := settleLostBets(ctx, tx, roomID); err != nil {
+			return nil, fmt.Errorf("settle lost bets: %w", err)
+		}
+		if err := recordPlayStreaks(ctx, tx, roomID); err != nil {
+			return nil, fmt.Errorf("record streaks: %w", err)
+		}
+	} else {
+		if s.NextSong1ID, s.NextSong2ID, err = initializeNextRound(ctx, tx, roomID); err != nil {
+			return nil, fmt.Errorf("init next round: %w", err)
+		}
+		if err := openRoundMarkets(ctx, tx, roomID, s.Round+1, s.NextSong1ID, s.NextSong2ID); err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec(ctx, `UPDATE room SET last_activity_at = now() WHERE room_id = $1`, roomID); err != nil {
+			return nil, fmt.Errorf("touch room: %w", err)
+		}
+	}
+
+	if err := settleRoundMarkets(ctx, tx, s); err != nil {
+		return nil, fmt.Errorf("settle markets: %w", err)
+	}
+	if s.Finished {
+		if err := recordGameStats(ctx, tx, s); err != nil {
+			return nil, fmt.Errorf("record game stats: %w", err)
+		}
+	}
+
+	s.SettledAt = time.Now().UTC()
+	outcome, err := json.Marshal(s)
+	if err != nil {
+		return nil, fmt.Errorf("encode settlement: %w", err)
+	}
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO match_settlement (room_id, round, winner_song_id, loser_song_id, outcome)
+		VALUES ($1, $2, $3, $4, $5)`,
+		roomID, s.Round, s.WinnerSongID, s.LoserSongID, outcome); err != nil {
+		return nil, fmt.Errorf("record settlement: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	publishRoomEvent(roomID, "match_settled", s)
+	if s.Finished {
+		publishRoomEvent(roomID, "room_status", map[string]string{"status": roomFinished})
+	} else {
+		publishOdds(ctx, roomID)
+	}
+	return s, nil
+}
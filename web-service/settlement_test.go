@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDetermineWinnerRequiresRound(t *testing.T) {
+	rec := httptest.NewRecorder()
+	determineWinnerHandler(rec, httptest.NewRequest(http.MethodPost, "/room/determine-winner?roomId=1", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status %d, want 400", rec.Code)
+	}
+}
+
+func TestSettleMatchTwiceReplaysRound(t *testing.T) {
+	openTestDB(t)
+	owner := mustCreateUser(t, "Owner")
+	guest := mustCreateUser(t, "Guest")
+	roomID := mustCreateRoom(t, owner, "Room")
+	for _, userID := range []int{owner, guest} {
+		mustExec(t, `INSERT INTO participation (user_id, room_id, role, is_submitted) VALUES ($1, $2, 'player', true)`, userID, roomID)
+	}
+	mustAddSong(t, roomID, owner, "A", "X", "")
+	mustAddSong(t, roomID, guest, "B", "Y", "")
+	mustAddSong(t, roomID, guest, "C", "Z", "")
+
+	ctx := context.Background()
+	first, err := settleMatch(ctx, roomID, 0)
+	if err != nil {
+		t.Fatalf("settle round 0: %v", err)
+	}
+	// Повторное нажатие с тем же раундом не закрывает следующий матч.
+	again, err := settleMatch(ctx, roomID, 0)
+	if err != nil {
+		t.Fatalf("settle round 0 again: %v", err)
+	}
+	if !again.Replayed || again.WinnerSongID != first.WinnerSongID {
+		t.Fatalf("second call settled a new match: %+v", again)
+	}
+
+	var round int
+	if err := db.QueryRow(ctx, `SELECT current_round FROM room WHERE room_id = $1`, roomID).Scan(&round); err != nil {
+		t.Fatalf("load round: %v", err)
+	}
+	if round != 1 {
+		t.Fatalf("current round %d, want 1", round)
+	}
+	if _, err := settleMatch(ctx, roomID, 2); settlementErrorStatus(err) != http.StatusConflict {
+		t.Fatalf("future round: %v, want errRoundMismatch", err)
+	}
+}
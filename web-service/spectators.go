@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// Роли в participation. Зрители не занимают места игроков и не участвуют
+// в проверках готовности, но получают события комнаты и могут голосовать,
+// если владелец включил голосование зрителей.
+const (
+	rolePlayer    = "player"
+	roleSpectator = "spectator"
+
+	maxPlayers    = 6
+	maxSpectators = 50
+)
+
+var errNotParticipant = errors.New("user is not in the room")
+
+func participantRole(ctx context.Context, roomID, userID int) (string, error) {
+	var role string
+	err := db.QueryRow(ctx, `
+		SELECT role FROM participation WHERE room_id = $1 AND user_id = $2 AND left_at IS NULL`,
+		roomID, userID).Scan(&role)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", errNotParticipant
+	}
+	return role, err
+}
+
+// requirePlayer пишет ошибку и возвращает false, если пользователь не игрок комнаты.
+func requirePlayer(w http.ResponseWriter, ctx context.Context, roomID, userID int) bool {
+	role, err := participantRole(ctx, roomID, userID)
+	if errors.Is(err, errNotParticipant) {
+		http.Error(w, "User is not in the room", http.StatusForbidden)
+		return false
+	}
+	if err != nil {
+		log.Println("Error fetching participant role:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return false
+	}
+	if role != rolePlayer {
+		http.Error(w, "Spectators cannot do this", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+func setAudienceVoteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var data struct {
+		UserId int     `json:"userId"`
+		RoomId int     `json:"roomId"`
+		Weight float64 `json:"weight"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if data.Weight < 0 || data.Weight > 1 {
+		http.Error(w, "weight must be between 0 and 1", http.StatusBadRequest)
+		return
+	}
+
+	var ownerId int
+	err := db.QueryRow(r.Context(), "SELECT owner_id FROM room WHERE room_id = $1", data.RoomId).Scan(&ownerId)
+	if err != nil {
+		log.Println("Error fetching room:", err)
+		http.Error(w, "Room not found", http.StatusNotFound)
+		return
+	}
+	if data.UserId != ownerId {
+		http.Error(w, "Only the owner can change audience voting", http.StatusForbidden)
+		return
+	}
+
+	if _, err := db.Exec(r.Context(), `
+		UPDATE room SET audience_vote_weight = $1 WHERE room_id = $2`,
+		data.Weight, data.RoomId); err != nil {
+		log.Println("Error updating audience vote weight:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	publishRoomEvent(data.RoomId, "audience_vote_changed", map[string]float64{"weight": data.Weight})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]float64{"weight": data.Weight})
+}
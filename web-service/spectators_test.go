@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSongVotesWeights(t *testing.T) {
+	openTestDB(t)
+	owner := mustCreateUser(t, "Owner")
+	spectator := mustCreateUser(t, "Spectator")
+	kicked := mustCreateUser(t, "Kicked")
+	roomID := mustCreateRoom(t, owner, "Room")
+	songID := mustAddSong(t, roomID, owner, "A", "X", "")
+
+	mustExec(t, `INSERT INTO participation (user_id, room_id, role) VALUES ($1, $2, 'player')`, owner, roomID)
+	mustExec(t, `INSERT INTO participation (user_id, room_id, role) VALUES ($1, $2, 'spectator')`, spectator, roomID)
+	for _, userID := range []int{owner, spectator, kicked} {
+		mustExec(t, `INSERT INTO votes (user_id, song_id, room_id, round) VALUES ($1, $2, $3, 0)`, userID, songID, roomID)
+	}
+
+	tests := []struct {
+		weight float64
+		want   float64
+	}{
+		// Голоса зрителя и пользователя без участия в комнате не считаются.
+		{0, 1},
+		{0.5, 2},
+	}
+	for _, tt := range tests {
+		mustExec(t, `UPDATE room SET audience_vote_weight = $1 WHERE room_id = $2`, tt.weight, roomID)
+		got, err := getSongVotes(context.Background(), db, songID, roomID, 0)
+		if err != nil {
+			t.Fatalf("getSongVotes: %v", err)
+		}
+		if got != tt.want {
+			t.Fatalf("audience weight %v: votes = %v, want %v", tt.weight, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// spotifyClient ходит в Spotify Accounts и Web API. Базовые адреса берутся из
+// окружения, чтобы локальный фейковый сервер мог подменить настоящий Spotify.
+type spotifyClient struct {
+	accountsURL  string
+	apiURL       string
+	clientID     string
+	clientSecret string
+	redirectURI  string
+	httpClient   *http.Client
+}
+
+var spotify *spotifyClient
+
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func getEnvInt(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	d, err := time.ParseDuration(getEnv(key, fallback.String()))
+	if err != nil {
+		log.Printf("Invalid %s, using %s: %v", key, fallback, err)
+		return fallback
+	}
+	return d
+}
+
+func newSpotifyClientFromEnv() *spotifyClient {
+	return &spotifyClient{
+		accountsURL:  strings.TrimRight(getEnv("SPOTIFY_ACCOUNTS_URL", "https://accounts.spotify.com"), "/"),
+		apiURL:       strings.TrimRight(getEnv("SPOTIFY_API_URL", "https://api.spotify.com/v1"), "/"),
+		clientID:     os.Getenv("SPOTIFY_CLIENT_ID"),
+		clientSecret: os.Getenv("SPOTIFY_CLIENT_SECRET"),
+		redirectURI:  os.Getenv("SPOTIFY_REDIRECT_URI"),
+		httpClient:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+type spotifyTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	Scope        string `json:"scope"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+type spotifyProfile struct {
+	ID          string `json:"id"`
+	DisplayName string `json:"display_name"`
+	Images      []struct {
+		URL string `json:"url"`
+	} `json:"images"`
+}
+
+// spotifyError оборачивает неуспешный ответ Spotify вместе с кодом статуса.
+type spotifyError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *spotifyError) Error() string {
+	return fmt.Sprintf("spotify responded with %d: %s", e.StatusCode, e.Body)
+}
+
+func (c *spotifyClient) exchangeCode(ctx context.Context, code, redirectURI string) (*spotifyTokenResponse, error) {
+	if redirectURI == "" {
+		redirectURI = c.redirectURI
+	}
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", redirectURI)
+	return c.requestToken(ctx, form)
+}
+
+func (c *spotifyClient) refreshToken(ctx context.Context, refreshToken string) (*spotifyTokenResponse, error) {
+	form := url.Values{}
+	form.Set("grant_type", "refresh_token")
+	form.Set("refresh_token", refreshToken)
+	return c.requestToken(ctx, form)
+}
+
+func (c *spotifyClient) requestToken(ctx context.Context, form url.Values) (*spotifyTokenResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.accountsURL+"/api/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(c.clientID, c.clientSecret)
+
+	var token spotifyTokenResponse
+	if err := c.do(req, &token); err != nil {
+		return nil, fmt.Errorf("token request: %w", err)
+	}
+	if token.AccessToken == "" {
+		return nil, fmt.Errorf("token request: empty access_token")
+	}
+	return &token, nil
+}
+
+func (c *spotifyClient) me(ctx context.Context, accessToken string) (*spotifyProfile, error) {
+	var profile spotifyProfile
+	if err := c.apiRequest(ctx, accessToken, http.MethodGet, "/me", nil, &profile); err != nil {
+		return nil, fmt.Errorf("fetch profile: %w", err)
+	}
+	return &profile, nil
+}
+
+func (c *spotifyClient) apiRequest(ctx context.Context, accessToken, method, path string, payload interface{}, out interface{}) error {
+	var body io.Reader
+	if payload != nil {
+		encoded, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		body = strings.NewReader(string(encoded))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.apiURL+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return c.do(req, out)
+}
+
+func (c *spotifyClient) do(req *http.Request, out interface{}) error {
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &spotifyError{StatusCode: resp.StatusCode, Body: string(data)}
+	}
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+type spotifyPlaylist struct {
+	ID           string `json:"id"`
+	ExternalURLs struct {
+		Spotify string `json:"spotify"`
+	} `json:"external_urls"`
+}
+
+func (c *spotifyClient) createPlaylist(ctx context.Context, accessToken, spotifyUserID, name, description string) (*spotifyPlaylist, error) {
+	var playlist spotifyPlaylist
+	payload := map[string]interface{}{
+		"name":        name,
+		"description": description,
+		"public":      false,
+	}
+	path := "/users/" + url.PathEscape(spotifyUserID) + "/playlists"
+	if err := c.apiRequest(ctx, accessToken, http.MethodPost, path, payload, &playlist); err != nil {
+		return nil, fmt.Errorf("create playlist: %w", err)
+	}
+	return &playlist, nil
+}
+
+func (c *spotifyClient) getPlaylist(ctx context.Context, accessToken, playlistID string) (*spotifyPlaylist, error) {
+	var playlist spotifyPlaylist
+	path := "/playlists/" + url.PathEscape(playlistID) + "?fields=id,external_urls"
+	if err := c.apiRequest(ctx, accessToken, http.MethodGet, path, nil, &playlist); err != nil {
+		return nil, fmt.Errorf("get playlist: %w", err)
+	}
+	return &playlist, nil
+}
+
+func (c *spotifyClient) updatePlaylistDetails(ctx context.Context, accessToken, playlistID, name, description string) error {
+	payload := map[string]interface{}{
+		"name":        name,
+		"description": description,
+	}
+	if err := c.apiRequest(ctx, accessToken, http.MethodPut, "/playlists/"+url.PathEscape(playlistID), payload, nil); err != nil {
+		return fmt.Errorf("update playlist details: %w", err)
+	}
+	return nil
+}
+
+// replacePlaylistItems заменяет содержимое плейлиста. Spotify принимает не больше
+// 100 URI за запрос, поэтому остаток дописывается отдельными запросами.
+func (c *spotifyClient) replacePlaylistItems(ctx context.Context, accessToken, playlistID string, uris []string) error {
+	const chunk = 100
+	path := "/playlists/" + url.PathEscape(playlistID) + "/tracks"
+
+	first := uris
+	if len(first) > chunk {
+		first = first[:chunk]
+	}
+	if err := c.apiRequest(ctx, accessToken, http.MethodPut, path, map[string]interface{}{"uris": first}, nil); err != nil {
+		return fmt.Errorf("replace playlist items: %w", err)
+	}
+
+	for start := chunk; start < len(uris); start += chunk {
+		end := start + chunk
+		if end > len(uris) {
+			end = len(uris)
+		}
+		if err := c.apiRequest(ctx, accessToken, http.MethodPost, path, map[string]interface{}{"uris": uris[start:end]}, nil); err != nil {
+			return fmt.Errorf("add playlist items: %w", err)
+		}
+	}
+	return nil
+}
+
+type spotifyTrack struct {
+	ID         string `json:"id"`
+	URI        string `json:"uri"`
+	Name       string `json:"name"`
+	DurationMs int    `json:"duration_ms"`
+	PreviewURL string `json:"preview_url"`
+	Artists    []struct {
+		Name string `json:"name"`
+	} `json:"artists"`
+	Album struct {
+		Images []struct {
+			URL string `json:"url"`
+		} `json:"images"`
+	} `json:"album"`
+	ExternalIDs struct {
+		ISRC string `json:"isrc"`
+	} `json:"external_ids"`
+}
+
+func (t spotifyTrack) toProviderTrack() ProviderTrack {
+	track := ProviderTrack{
+		Provider:   "spotify",
+		ID:         t.ID,
+		Title:      t.Name,
+		ISRC:       t.ExternalIDs.ISRC,
+		DurationMs: t.DurationMs,
+		PreviewURL: t.PreviewURL,
+	}
+	var artists []string
+	for _, a := range t.Artists {
+		artists = append(artists, a.Name)
+	}
+	track.Artist = strings.Join(artists, ", ")
+	if len(t.Album.Images) > 0 {
+		track.AlbumArtURL = t.Album.Images[0].URL
+	}
+	return track
+}
+
+func (c *spotifyClient) searchTracks(ctx context.Context, accessToken, query string, limit int) ([]spotifyTrack, error) {
+	var result struct {
+		Tracks struct {
+			Items []spotifyTrack `json:"items"`
+		} `json:"tracks"`
+	}
+	params := url.Values{}
+	params.Set("q", query)
+	params.Set("type", "track")
+	params.Set("limit", fmt.Sprint(limit))
+	if err := c.apiRequest(ctx, accessToken, http.MethodGet, "/search?"+params.Encode(), nil, &result); err != nil {
+		return nil, fmt.Errorf("search tracks: %w", err)
+	}
+	return result.Tracks.Items, nil
+}
+
+func (c *spotifyClient) getTrack(ctx context.Context, accessToken, id string) (*spotifyTrack, error) {
+	var track spotifyTrack
+	if err := c.apiRequest(ctx, accessToken, http.MethodGet, "/tracks/"+url.PathEscape(id), nil, &track); err != nil {
+		return nil, fmt.Errorf("get track: %w", err)
+	}
+	return &track, nil
+}
+
+func (c *spotifyClient) Name() string {
+	return "spotify"
+}
+
+func (c *spotifyClient) Search(ctx context.Context, accessToken, query string, limit int) ([]ProviderTrack, error) {
+	found, err := c.searchTracks(ctx, accessToken, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	tracks := make([]ProviderTrack, 0, len(found))
+	for _, t := range found {
+		tracks = append(tracks, t.toProviderTrack())
+	}
+	return tracks, nil
+}
+
+func (c *spotifyClient) Track(ctx context.Context, accessToken, id string) (*ProviderTrack, error) {
+	found, err := c.getTrack(ctx, accessToken, id)
+	var apiErr *spotifyError
+	if errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusNotFound || apiErr.StatusCode == http.StatusBadRequest) {
+		return nil, errTrackNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	track := found.toProviderTrack()
+	return &track, nil
+}
+
+func (c *spotifyClient) FindByISRC(ctx context.Context, accessToken, isrc string) (*ProviderTrack, error) {
+	found, err := c.Search(ctx, accessToken, "isrc:"+isrc, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(found) == 0 {
+		return nil, errTrackNotFound
+	}
+	return &found[0], nil
+}
+
+func (c *spotifyClient) ExportPlaylist(ctx context.Context, accessToken string, export PlaylistExport) (*ExportedPlaylist, error) {
+	var playlist *spotifyPlaylist
+	if export.ExistingID != "" {
+		existing, err := c.getPlaylist(ctx, accessToken, export.ExistingID)
+		var apiErr *spotifyError
+		switch {
+		case errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound:
+		case err != nil:
+			return nil, err
+		default:
+			if err := c.updatePlaylistDetails(ctx, accessToken, existing.ID, export.Name, export.Description); err != nil {
+				return nil, err
+			}
+			playlist = existing
+		}
+	}
+
+	if playlist == nil {
+		profile, err := c.me(ctx, accessToken)
+		if err != nil {
+			return nil, err
+		}
+		playlist, err = c.createPlaylist(ctx, accessToken, profile.ID, export.Name, export.Description)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	uris := make([]string, 0, len(export.TrackIDs))
+	for _, id := range export.TrackIDs {
+		uris = append(uris, "spotify:track:"+id)
+	}
+	if err := c.replacePlaylistItems(ctx, accessToken, playlist.ID, uris); err != nil {
+		return nil, err
+	}
+	return &ExportedPlaylist{ID: playlist.ID, URL: playlist.ExternalURLs.Spotify}, nil
+}
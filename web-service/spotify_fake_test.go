@@ -0,0 +1,297 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const (
+	fakeClientID     = "test-client"
+	fakeClientSecret = "test-secret"
+)
+
+// fakeSpotify — локальная замена Spotify Accounts и Web API. Код авторизации
+// "code-<id>" выдаёт токен аккаунта <id>, токен "access-<id>" открывает его
+// профиль и плейлисты.
+type fakeSpotify struct {
+	*httptest.Server
+	t *testing.T
+
+	mu        sync.Mutex
+	profiles  map[string]string // spotify id → display name
+	playlists map[string]*fakePlaylist
+	requests  []string
+}
+
+type fakePlaylist struct {
+	Owner       string
+	Name        string
+	Description string
+	URIs        []string
+}
+
+func newFakeSpotify(t *testing.T) *fakeSpotify {
+	t.Helper()
+	f := &fakeSpotify{t: t, profiles: map[string]string{}, playlists: map[string]*fakePlaylist{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/token", f.token)
+	mux.HandleFunc("/v1/me", f.me)
+	mux.HandleFunc("/v1/users/", f.createPlaylist)
+	mux.HandleFunc("/v1/playlists/", f.playlist)
+	mux.HandleFunc("/v1/search", f.search)
+	mux.HandleFunc("/v1/tracks/", f.track)
+	f.Server = httptest.NewServer(f.record(mux))
+	t.Cleanup(f.Close)
+
+	prev := spotify
+	spotify = &spotifyClient{
+		accountsURL:  f.URL,
+		apiURL:       f.URL + "/v1",
+		clientID:     fakeClientID,
+		clientSecret: fakeClientSecret,
+		redirectURI:  "kotb://callback",
+		httpClient:   f.Client(),
+	}
+	prevProviders := musicProviders
+	musicProviders = map[string]MusicProvider{}
+	registerProvider(spotify)
+	t.Cleanup(func() {
+		spotify = prev
+		musicProviders = prevProviders
+	})
+	return f
+}
+
+func (f *fakeSpotify) addProfile(id, name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.profiles[id] = name
+}
+
+func (f *fakeSpotify) record(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		f.requests = append(f.requests, r.Method+" "+r.URL.Path)
+		f.mu.Unlock()
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (f *fakeSpotify) token(w http.ResponseWriter, r *http.Request) {
+	id, secret, ok := r.BasicAuth()
+	if !ok || id != fakeClientID || secret != fakeClientSecret {
+		http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, `{"error":"invalid_request"}`, http.StatusBadRequest)
+		return
+	}
+
+	var account string
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		account = strings.TrimPrefix(r.PostForm.Get("code"), "code-")
+	case "refresh_token":
+		account = strings.TrimPrefix(r.PostForm.Get("refresh_token"), "refresh-")
+	}
+	f.mu.Lock()
+	_, known := f.profiles[account]
+	f.mu.Unlock()
+	if !known {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"access_token":  "access-" + account,
+		"token_type":    "Bearer",
+		"scope":         "user-read-private playlist-modify-private",
+		"expires_in":    3600,
+		"refresh_token": "refresh-" + account,
+	})
+}
+
+// account возвращает аккаунт по Bearer-токену или пишет 401.
+func (f *fakeSpotify) account(w http.ResponseWriter, r *http.Request) (string, bool) {
+	account := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer access-")
+	f.mu.Lock()
+	_, known := f.profiles[account]
+	f.mu.Unlock()
+	if !known {
+		http.Error(w, `{"error":{"status":401,"message":"Invalid access token"}}`, http.StatusUnauthorized)
+		return "", false
+	}
+	return account, true
+}
+
+func (f *fakeSpotify) me(w http.ResponseWriter, r *http.Request) {
+	account, ok := f.account(w, r)
+	if !ok {
+		return
+	}
+	f.mu.Lock()
+	name := f.profiles[account]
+	f.mu.Unlock()
+	writeJSON(w, map[string]interface{}{
+		"id":           account,
+		"display_name": name,
+		"images":       []map[string]string{{"url": "https://img.example/" + account + ".jpg"}},
+	})
+}
+
+// getPlaylist возвращает копию плейлиста id или nil.
+func (f *fakeSpotify) getPlaylist(id string) *fakePlaylist {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p, ok := f.playlists[id]
+	if !ok {
+		return nil
+	}
+	cp := *p
+	cp.URIs = append([]string(nil), p.URIs...)
+	return &cp
+}
+
+// countRequests считает запросы к фейку с заданными методом и путём.
+func (f *fakeSpotify) countRequests(methodPath string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, r := range f.requests {
+		if r == methodPath {
+			n++
+		}
+	}
+	return n
+}
+
+// createPlaylist обслуживает POST /v1/users/{id}/playlists.
+func (f *fakeSpotify) createPlaylist(w http.ResponseWriter, r *http.Request) {
+	account, ok := f.account(w, r)
+	if !ok {
+		return
+	}
+	owner := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/v1/users/"), "/playlists")
+	if r.Method != http.MethodPost || owner == r.URL.Path {
+		http.NotFound(w, r)
+		return
+	}
+	if owner != account {
+		http.Error(w, `{"error":{"status":403,"message":"Forbidden"}}`, http.StatusForbidden)
+		return
+	}
+
+	var body struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Name == "" {
+		http.Error(w, `{"error":{"status":400,"message":"Missing name"}}`, http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	id := fmt.Sprintf("pl%d", len(f.playlists)+1)
+	f.playlists[id] = &fakePlaylist{Owner: account, Name: body.Name, Description: body.Description}
+	f.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	writeJSON(w, fakePlaylistJSON(id))
+}
+
+// playlist обслуживает /v1/playlists/{id} и /v1/playlists/{id}/tracks.
+func (f *fakeSpotify) playlist(w http.ResponseWriter, r *http.Request) {
+	account, ok := f.account(w, r)
+	if !ok {
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/v1/playlists/")
+	id, tracks := strings.CutSuffix(id, "/tracks")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p, exists := f.playlists[id]
+	if !exists {
+		http.Error(w, `{"error":{"status":404,"message":"Not found."}}`, http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodGet && p.Owner != account {
+		http.Error(w, `{"error":{"status":403,"message":"Forbidden"}}`, http.StatusForbidden)
+		return
+	}
+
+	switch {
+	case !tracks && r.Method == http.MethodGet:
+		writeJSON(w, fakePlaylistJSON(id))
+	case !tracks && r.Method == http.MethodPut:
+		var body struct {
+			Name        string `json:"name"`
+			Description string `json:"description"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		p.Name, p.Description = body.Name, body.Description
+	case tracks && (r.Method == http.MethodPut || r.Method == http.MethodPost):
+		var body struct {
+			URIs []string `json:"uris"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if len(body.URIs) > 100 {
+			http.Error(w, `{"error":{"status":400,"message":"Too many tracks"}}`, http.StatusBadRequest)
+			return
+		}
+		if r.Method == http.MethodPut {
+			p.URIs = nil
+		}
+		p.URIs = append(p.URIs, body.URIs...)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		writeJSON(w, map[string]string{"snapshot_id": fmt.Sprintf("snap%d", len(p.URIs))})
+	default:
+		http.Error(w, `{"error":{"status":405,"message":"Method not allowed"}}`, http.StatusMethodNotAllowed)
+	}
+}
+
+// search отдаёт записанную выдачу testdata/spotify/search/<slug>.json, для
+// незаписанных запросов — пустую.
+func (f *fakeSpotify) search(w http.ResponseWriter, r *http.Request) {
+	if _, ok := f.account(w, r); !ok {
+		return
+	}
+	if r.URL.Query().Get("type") != "track" {
+		http.Error(w, `{"error":{"status":400,"message":"Bad search type field"}}`, http.StatusBadRequest)
+		return
+	}
+	if !serveFixture(w, "spotify/search/"+fixtureSlug(r.URL.Query().Get("q"))) {
+		writeJSON(w, map[string]interface{}{"tracks": map[string]interface{}{"items": []interface{}{}, "total": 0}})
+	}
+}
+
+// track отдаёт записанный трек testdata/spotify/tracks/<id>.json.
+func (f *fakeSpotify) track(w http.ResponseWriter, r *http.Request) {
+	if _, ok := f.account(w, r); !ok {
+		return
+	}
+	if !serveFixture(w, "spotify/tracks/"+strings.TrimPrefix(r.URL.Path, "/v1/tracks/")) {
+		http.Error(w, `{"error":{"status":400,"message":"invalid id"}}`, http.StatusBadRequest)
+	}
+}
+
+func fakePlaylistJSON(id string) map[string]interface{} {
+	return map[string]interface{}{
+		"id":            id,
+		"external_urls": map[string]string{"spotify": "https://open.spotify.example/playlist/" + id},
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
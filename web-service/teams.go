@@ -0,0 +1,575 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// В командном режиме игроки комнаты объединяются в команды: песни и ставки
+// записываются на команду, ставки играют на общий банк team.balance, который
+// складывается из взносов участников и после игры делится между ними.
+
+const maxTeamSize = 2
+
+type TeamMember struct {
+	UserId       int    `json:"userId"`
+	Name         string `json:"name"`
+	Contribution int    `json:"contribution"`
+}
+
+type Team struct {
+	TeamID  int          `json:"teamId"`
+	RoomID  int          `json:"roomId"`
+	Name    string       `json:"name"`
+	Balance int          `json:"balance"`
+	Members []TeamMember `json:"members"`
+}
+
+// TeamStanding — итог команды в комнате.
+type TeamStanding struct {
+	Team
+	Place        int `json:"place"`
+	BestSongID   int `json:"bestSongId,omitempty"`
+	SongsAlive   int `json:"songsAlive"`
+	Staked       int `json:"staked"`
+	Contributed  int `json:"contributed"`
+	NetProfit    int `json:"netProfit"`
+	bestPosition int
+}
+
+func joinTeam(ctx context.Context, tx pgx.Tx, roomID, teamID, userID, contribution int) error {
+	var members int
+	if err := tx.QueryRow(ctx, `
+		SELECT COUNT(*) FROM participation WHERE room_id = $1 AND team_id = $2`,
+		roomID, teamID).Scan(&members); err != nil {
+		return fmt.Errorf("count team members: %w", err)
+	}
+	if members >= maxTeamSize {
+		return errTeamFull
+	}
+
+	var balance int
+	if err := tx.QueryRow(ctx, `SELECT balance FROM "user" WHERE user_id = $1 FOR UPDATE`, userID).Scan(&balance); err != nil {
+		return fmt.Errorf("lock user: %w", err)
+	}
+	if contribution < 0 || contribution > balance {
+		return errInsufficientFunds
+	}
+
+	tag, err := tx.Exec(ctx, `
+		UPDATE participation SET team_id = $1, team_contribution = $2
+		 WHERE room_id = $3 AND user_id = $4 AND role = 'player' AND team_id IS NULL`,
+		teamID, contribution, roomID, userID)
+	if err != nil {
+		return fmt.Errorf("update participation: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return errAlreadyInTeam
+	}
+
+	if contribution > 0 {
+		if _, err := transfer(ctx, tx, userAccount(userID), teamAccount(teamID), contribution,
+			reasonTeamContribution, teamRef(teamID)); err != nil {
+			return fmt.Errorf("contribute to team: %w", err)
+		}
+	}
+	return nil
+}
+
+var (
+	errTeamFull          = errors.New("team is full")
+	errAlreadyInTeam     = errors.New("user is not a free player in this room")
+	errInsufficientFunds = errors.New("insufficient balance")
+)
+
+func teamErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errTeamFull), errors.Is(err, errAlreadyInTeam):
+		return http.StatusConflict
+	case errors.Is(err, errInsufficientFunds):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func createTeamHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	callerID, ok := requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	var data struct {
+		RoomId       int    `json:"roomId"`
+		Name         string `json:"name"`
+		Contribution int    `json:"contribution"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if data.Name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	if !requirePlayer(w, ctx, data.RoomId, callerID) {
+		return
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		log.Println("Error starting transaction:", err)
+		http.Error(w, "Database transaction error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(context.Background())
+
+	var teamID int
+	if err := tx.QueryRow(ctx, `
+		INSERT INTO team (room_id, name) VALUES ($1, $2) RETURNING team_id`,
+		data.RoomId, data.Name).Scan(&teamID); err != nil {
+		log.Println("Error creating team:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if err := joinTeam(ctx, tx, data.RoomId, teamID, callerID, data.Contribution); err != nil {
+		log.Println("Error joining team:", err)
+		http.Error(w, err.Error(), teamErrorStatus(err))
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		log.Println("Error committing transaction:", err)
+		http.Error(w, "Error committing transaction", http.StatusInternalServerError)
+		return
+	}
+
+	publishRoomEvent(data.RoomId, "team_created", map[string]interface{}{"teamId": teamID, "name": data.Name})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]int{"teamId": teamID})
+}
+
+func joinTeamHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	callerID, ok := requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	var data struct {
+		RoomId       int `json:"roomId"`
+		TeamId       int `json:"teamId"`
+		Contribution int `json:"contribution"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	if !requirePlayer(w, ctx, data.RoomId, callerID) {
+		return
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		log.Println("Error starting transaction:", err)
+		http.Error(w, "Database transaction error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(context.Background())
+
+	// Блокировка строки команды не даёт двум игрокам одновременно занять последнее место.
+	var teamID int
+	err = tx.QueryRow(ctx, `
+		SELECT team_id FROM team WHERE team_id = $1 AND room_id = $2 FOR UPDATE`,
+		data.TeamId, data.RoomId).Scan(&teamID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.Error(w, "Team not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println("Error locking team:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if err := joinTeam(ctx, tx, data.RoomId, data.TeamId, callerID, data.Contribution); err != nil {
+		log.Println("Error joining team:", err)
+		http.Error(w, err.Error(), teamErrorStatus(err))
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		log.Println("Error committing transaction:", err)
+		http.Error(w, "Error committing transaction", http.StatusInternalServerError)
+		return
+	}
+
+	publishRoomEvent(data.RoomId, "team_joined", map[string]int{"teamId": data.TeamId, "userId": callerID})
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func loadTeams(ctx context.Context, roomID int) ([]Team, error) {
+	rows, err := db.Query(ctx, `
+		SELECT t.team_id, t.name, t.balance, u.user_id, COALESCE(u.name, ''), p.team_contribution
+		  FROM team t
+	 LEFT JOIN participation p ON p.team_id = t.team_id
+	 LEFT JOIN "user" u ON u.user_id = p.user_id
+		 WHERE t.room_id = $1
+	  ORDER BY t.team_id, u.user_id`, roomID)
+	if err != nil {
+		return nil, fmt.Errorf("query teams: %w", err)
+	}
+	defer rows.Close()
+
+	var teams []Team
+	for rows.Next() {
+		var (
+			team         Team
+			userID       *int
+			name         string
+			contribution *int
+		)
+		if err := rows.Scan(&team.TeamID, &team.Name, &team.Balance, &userID, &name, &contribution); err != nil {
+			return nil, fmt.Errorf("scan team: %w", err)
+		}
+		if len(teams) == 0 || teams[len(teams)-1].TeamID != team.TeamID {
+			team.RoomID = roomID
+			team.Members = []TeamMember{}
+			teams = append(teams, team)
+		}
+		if userID != nil {
+			member := TeamMember{UserId: *userID, Name: name}
+			if contribution != nil {
+				member.Contribution = *contribution
+			}
+			last := &teams[len(teams)-1]
+			last.Members = append(last.Members, member)
+		}
+	}
+	return teams, rows.Err()
+}
+
+func listTeamsHandler(w http.ResponseWriter, r *http.Request) {
+	roomID, err := strconv.Atoi(r.URL.Query().Get("roomId"))
+	if err != nil {
+		http.Error(w, "roomId is required", http.StatusBadRequest)
+		return
+	}
+
+	teams, err := loadTeams(r.Context(), roomID)
+	if err != nil {
+		log.Println("Error loading teams:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(teams)
+}
+
+// teamStandings считает итоги команд: место по лучшей песне команды в итоговой
+// таблице, затем по банку; плюс ставки и выигрыши команды.
+func teamStandings(ctx context.Context, roomID int) ([]TeamStanding, error) {
+	teams, err := loadTeams(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	standings, err := finalStandings(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	position := make(map[int]int, len(standings))
+	for i, t := range standings {
+		position[t.SongID] = i
+	}
+
+	result := make([]TeamStanding, 0, len(teams))
+	byID := make(map[int]int, len(teams))
+	for _, t := range teams {
+		byID[t.TeamID] = len(result)
+		standing := TeamStanding{Team: t, bestPosition: len(standings)}
+		for _, m := range t.Members {
+			standing.Contributed += m.Contribution
+		}
+		result = append(result, standing)
+	}
+
+	rows, err := db.Query(ctx, `
+        SELECT s.team_id, s.song_id, (sp.eliminated IS NULL OR sp.eliminated = FALSE)
+          FROM song s
+     LEFT JOIN song_progress sp ON s.song_id = sp.song_id
+         WHERE s.room_id = $1 AND s.team_id IS NOT NULL`, roomID)
+	if err != nil {
+		return nil, fmt.Errorf("query team songs: %w", err)
+	}
+	for rows.Next() {
+		var teamID, songID int
+		var alive bool
+		if err := rows.Scan(&teamID, &songID, &alive); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan team song: %w", err)
+		}
+		i, ok := byID[teamID]
+		if !ok {
+			continue
+		}
+		if alive {
+			result[i].SongsAlive++
+		}
+		if pos, ok := position[songID]; ok && pos < result[i].bestPosition {
+			result[i].bestPosition = pos
+			result[i].BestSongID = songID
+		}
+	}
+	rows.Close()
+
+	rows, err = db.Query(ctx, `
+		SELECT team_id, COALESCE(SUM(bet_amount), 0)
+		  FROM bets
+		 WHERE room_id = $1 AND team_id IS NOT NULL AND voided_at IS NULL
+	  GROUP BY team_id`, roomID)
+	if err != nil {
+		return nil, fmt.Errorf("query team bets: %w", err)
+	}
+	for rows.Next() {
+		var teamID, staked int
+		if err := rows.Scan(&teamID, &staked); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan team bets: %w", err)
+		}
+		if i, ok := byID[teamID]; ok {
+			result[i].Staked = staked
+		}
+	}
+	rows.Close()
+
+	for i := range result {
+		result[i].NetProfit = result[i].Balance - result[i].Contributed
+	}
+
+	sort.SliceStable(result, func(a, b int) bool {
+		if result[a].bestPosition != result[b].bestPosition {
+			return result[a].bestPosition < result[b].bestPosition
+		}
+		return result[a].Balance > result[b].Balance
+	})
+	for i := range result {
+		result[i].Place = i + 1
+	}
+	return result, nil
+}
+
+func teamLeaderboardHandler(w http.ResponseWriter, r *http.Request) {
+	roomID, err := strconv.Atoi(r.URL.Query().Get("roomId"))
+	if err != nil {
+		http.Error(w, "roomId is required", http.StatusBadRequest)
+		return
+	}
+
+	standings, err := teamStandings(r.Context(), roomID)
+	if err != nil {
+		log.Println("Error computing team standings:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	if r.URL.Query().Get("by") == "bankroll" {
+		sort.SliceStable(standings, func(a, b int) bool {
+			return standings[a].Balance > standings[b].Balance
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(standings)
+}
+
+// teamPayoutHandler после окончания игры делит банк каждой команды между её
+// участниками пропорционально взносам (остаток от деления — последнему участнику).
+func teamPayoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	callerID, ok := requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	var data struct {
+		RoomId int `json:"roomId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+
+	var ownerId int
+	if err := db.QueryRow(ctx, "SELECT owner_id FROM room WHERE room_id = $1", data.RoomId).Scan(&ownerId); err != nil {
+		http.Error(w, "Room not found", http.StatusNotFound)
+		return
+	}
+	if callerID != ownerId {
+		http.Error(w, "Only the owner can pay out teams", http.StatusForbidden)
+		return
+	}
+
+	finished, err := isRoomFinished(ctx, data.RoomId)
+	if err != nil {
+		log.Println("Error checking room state:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if !finished {
+		http.Error(w, "Game is not finished yet", http.StatusConflict)
+		return
+	}
+
+	if err := payOutTeams(ctx, data.RoomId); err != nil {
+		log.Println("Error paying out teams:", err)
+		http.Error(w, "Failed to pay out teams", http.StatusInternalServerError)
+		return
+	}
+
+	publishRoomEvent(data.RoomId, "teams_paid_out", nil)
+	w.WriteHeader(http.StatusOK)
+}
+
+// teamShare — доля участника в банке команды.
+type teamShare struct{ userID, contribution int }
+
+func payOutTeams(ctx context.Context, roomID int) error {
+	return db.BeginFunc(ctx, func(tx pgx.Tx) error {
+		return payOutTeamsTx(ctx, tx, roomID)
+	})
+}
+
+// payOutTeamsTx делит банки невыплаченных команд комнаты внутри транзакции tx.
+func payOutTeamsTx(ctx context.Context, tx pgx.Tx, roomID int) error {
+	rows, err := tx.Query(ctx, `
+		SELECT team_id, balance FROM team
+		 WHERE room_id = $1 AND NOT paid_out
+		   FOR UPDATE`, roomID)
+	if err != nil {
+		return fmt.Errorf("lock teams: %w", err)
+	}
+	pools := map[int]int{}
+	for rows.Next() {
+		var teamID, balance int
+		if err := rows.Scan(&teamID, &balance); err != nil {
+			rows.Close()
+			return fmt.Errorf("scan team: %w", err)
+		}
+		pools[teamID] = balance
+	}
+	rows.Close()
+
+	for teamID, pool := range pools {
+		members, err := queryTeamShares(ctx, tx, `
+			SELECT user_id, team_contribution FROM participation
+			 WHERE team_id = $1
+		  ORDER BY user_id`, teamID)
+		if err != nil {
+			return fmt.Errorf("query members: %w", err)
+		}
+		reason := reasonTeamPayout
+
+		// Участников не осталось: банк возвращается тем, кто в него вносил,
+		// по журналу взносов, а если вернуть некому — явно уходит в house.
+		if len(members) == 0 && pool > 0 {
+			members, err = queryTeamShares(ctx, tx, `
+				SELECT e.account_id, SUM(-e.amount)::int
+				  FROM ledger_txn t
+				  JOIN ledger_entry e ON e.txn_id = t.txn_id
+				 WHERE t.reason = $2 AND t.ref = $3
+				   AND e.account_kind = 'user' AND e.amount < 0
+				   AND EXISTS (SELECT 1 FROM "user" u WHERE u.user_id = e.account_id)
+			  GROUP BY e.account_id
+			  ORDER BY e.account_id`, teamID, reasonTeamContribution, teamRef(teamID))
+			if err != nil {
+				return fmt.Errorf("query contributors: %w", err)
+			}
+			reason = reasonTeamRefund
+		}
+		if len(members) == 0 && pool > 0 {
+			if _, err := transfer(ctx, tx, teamAccount(teamID), houseAccount, pool,
+				reasonTeamForfeit, teamRef(teamID)); err != nil {
+				return fmt.Errorf("forfeit team pool: %w", err)
+			}
+			log.Printf("Team %d has no members or contributors, %d coins go to the house\n", teamID, pool)
+		}
+
+		total := 0
+		for _, m := range members {
+			total += m.contribution
+		}
+		paid := 0
+		for i, m := range members {
+			var amount int
+			if total > 0 {
+				amount = pool * m.contribution / total
+			} else {
+				amount = pool / len(members)
+			}
+			if i == len(members)-1 {
+				amount = pool - paid
+			}
+			paid += amount
+			if amount <= 0 {
+				continue
+			}
+			if _, err := transfer(ctx, tx, teamAccount(teamID), userAccount(m.userID), amount,
+				reason, teamRef(teamID)); err != nil {
+				return fmt.Errorf("credit member: %w", err)
+			}
+		}
+
+		if _, err := tx.Exec(ctx, `UPDATE team SET paid_out = TRUE WHERE team_id = $1`, teamID); err != nil {
+			return fmt.Errorf("close team: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func queryTeamShares(ctx context.Context, tx pgx.Tx, sql string, args ...interface{}) ([]teamShare, error) {
+	rows, err := tx.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var shares []teamShare
+	for rows.Next() {
+		var m teamShare
+		if err := rows.Scan(&m.userID, &m.contribution); err != nil {
+			return nil, err
+		}
+		shares = append(shares, m)
+	}
+	return shares, rows.Err()
+}
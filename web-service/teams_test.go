@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+func userBalance(t *testing.T, userID int) int {
+	t.Helper()
+	var balance int
+	if err := db.QueryRow(context.Background(), `SELECT balance FROM "user" WHERE user_id = $1`, userID).Scan(&balance); err != nil {
+		t.Fatalf("load balance of %d: %v", userID, err)
+	}
+	return balance
+}
+
+// mustJoinTeam заводит игрока в комнате и вносит contribution в банк команды.
+func mustJoinTeam(t *testing.T, roomID, teamID, userID, contribution int) {
+	t.Helper()
+	mustExec(t, `INSERT INTO participation (user_id, room_id, role) VALUES ($1, $2, 'player')`, userID, roomID)
+	err := db.BeginFunc(context.Background(), func(tx pgx.Tx) error {
+		return joinTeam(context.Background(), tx, roomID, teamID, userID, contribution)
+	})
+	if err != nil {
+		t.Fatalf("join team: %v", err)
+	}
+}
+
+func TestTeamHandlersRequireSession(t *testing.T) {
+	handlers := map[string]http.HandlerFunc{
+		"/team/create": createTeamHandler,
+		"/team/join":   joinTeamHandler,
+		"/team/payout": teamPayoutHandler,
+	}
+	for path, handler := range handlers {
+		rec := httptest.NewRecorder()
+		body := `{"roomId": 1, "userId": 1, "teamId": 1, "name": "T", "contribution": 10}`
+		handler(rec, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("%s: status %d, want 401", path, rec.Code)
+		}
+	}
+}
+
+func TestPayOutTeamWithoutMembers(t *testing.T) {
+	openTestDB(t)
+	owner := mustCreateUser(t, "Owner")
+	a := mustCreateUser(t, "A")
+	b := mustCreateUser(t, "B")
+	roomID := mustCreateRoom(t, owner, "Room")
+
+	var refunded, forfeited int
+	ctx := context.Background()
+	for _, id := range []*int{&refunded, &forfeited} {
+		if err := db.QueryRow(ctx, `INSERT INTO team (room_id, name) VALUES ($1, 'T') RETURNING team_id`, roomID).Scan(id); err != nil {
+			t.Fatalf("create team: %v", err)
+		}
+	}
+
+	// Оба участника вышли из комнаты, взносы 100 и 300 возвращаются в той же пропорции.
+	mustJoinTeam(t, roomID, refunded, a, 100)
+	mustJoinTeam(t, roomID, refunded, b, 300)
+	mustExec(t, `UPDATE team SET balance = balance + 200 WHERE team_id = $1`, refunded)
+	mustExec(t, `DELETE FROM participation WHERE team_id = $1`, refunded)
+
+	// У второй команды нет ни участников, ни взносов.
+	mustExec(t, `UPDATE team SET balance = 50 WHERE team_id = $1`, forfeited)
+
+	if err := payOutTeams(ctx, roomID); err != nil {
+		t.Fatalf("payOutTeams: %v", err)
+	}
+
+	if got := userBalance(t, a); got != startingBalance-100+150 {
+		t.Fatalf("A balance = %d, want %d", got, startingBalance+50)
+	}
+	if got := userBalance(t, b); got != startingBalance-300+450 {
+		t.Fatalf("B balance = %d, want %d", got, startingBalance+150)
+	}
+
+	rows, err := db.Query(ctx, `SELECT balance, paid_out FROM team WHERE room_id = $1`, roomID)
+	if err != nil {
+		t.Fatalf("load teams: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var balance int
+		var paidOut bool
+		if err := rows.Scan(&balance, &paidOut); err != nil {
+			t.Fatalf("scan team: %v", err)
+		}
+		if balance != 0 || !paidOut {
+			t.Fatalf("team left with balance %d, paid_out %v", balance, paidOut)
+		}
+	}
+
+	var house int
+	if err := db.QueryRow(ctx, `
+		SELECT COALESCE(SUM(e.amount), 0)
+		  FROM ledger_entry e JOIN ledger_txn t ON t.txn_id = e.txn_id
+		 WHERE e.account_kind = 'house' AND t.reason = $1`, reasonTeamForfeit).Scan(&house); err != nil {
+		t.Fatalf("load house forfeits: %v", err)
+	}
+	if house != 50 {
+		t.Fatalf("house received %d, want 50", house)
+	}
+}
+
+func TestExpireRoomPaysOutTeams(t *testing.T) {
+	openTestDB(t)
+	owner := mustCreateUser(t, "Owner")
+	a := mustCreateUser(t, "A")
+	b := mustCreateUser(t, "B")
+	roomID := mustCreateRoom(t, owner, "Room")
+
+	ctx := context.Background()
+	var teamID int
+	if err := db.QueryRow(ctx, `INSERT INTO team (room_id, name) VALUES ($1, 'T') RETURNING team_id`, roomID).Scan(&teamID); err != nil {
+		t.Fatalf("create team: %v", err)
+	}
+	mustJoinTeam(t, roomID, teamID, a, 100)
+	mustJoinTeam(t, roomID, teamID, b, 300)
+
+	// Комната простаивает и истекает, не дойдя до выплаты командам.
+	mustExec(t, `UPDATE room SET last_activity_at = now() - interval '1 hour' WHERE room_id = $1`, roomID)
+	expired, err := expireIdleRooms(ctx, roomOpen, time.Minute)
+	if err != nil {
+		t.Fatalf("expire rooms: %v", err)
+	}
+	if len(expired) != 1 || expired[0] != roomID {
+		t.Fatalf("expired %v, want [%d]", expired, roomID)
+	}
+
+	if got := userBalance(t, a); got != startingBalance {
+		t.Fatalf("A balance = %d, want %d", got, startingBalance)
+	}
+	if got := userBalance(t, b); got != startingBalance {
+		t.Fatalf("B balance = %d, want %d", got, startingBalance)
+	}
+	var balance int
+	var paidOut bool
+	if err := db.QueryRow(ctx, `SELECT balance, paid_out FROM team WHERE team_id = $1`, teamID).Scan(&balance, &paidOut); err != nil {
+		t.Fatalf("load team: %v", err)
+	}
+	if balance != 0 || !paidOut {
+		t.Fatalf("team left with balance %d, paid_out %v", balance, paidOut)
+	}
+
+	// Удаление комнаты после выплаты не оставляет денег без владельца.
+	if err := deleteRoom(ctx, roomID); err != nil {
+		t.Fatalf("delete room: %v", err)
+	}
+	audit, err := auditLedger(ctx)
+	if err != nil {
+		t.Fatalf("audit ledger: %v", err)
+	}
+	if !audit.OK() {
+		t.Fatalf("ledger audit failed: %+v", audit)
+	}
+}
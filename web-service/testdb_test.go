@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+var cipherOnce sync.Once
+
+// openTestDB создаёт одноразовую базу на сервере из TEST_DATABASE_URL,
+// накатывает db-init/init.sql и подменяет глобальный db на время теста.
+// База удаляется после теста; без TEST_DATABASE_URL тест пропускается.
+func openTestDB(t *testing.T) {
+	t.Helper()
+	adminURL := os.Getenv("TEST_DATABASE_URL")
+	if adminURL == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	cipherOnce.Do(initTokenCipher)
+
+	ctx := context.Background()
+	admin, err := pgx.Connect(ctx, adminURL)
+	if err != nil {
+		t.Fatalf("connect to test server: %v", err)
+	}
+	name := fmt.Sprintf("kotb_test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec(ctx, "CREATE DATABASE "+name); err != nil {
+		admin.Close(ctx)
+		t.Fatalf("create test database: %v", err)
+	}
+
+	cfg, err := pgxpool.ParseConfig(adminURL)
+	if err != nil {
+		t.Fatalf("parse TEST_DATABASE_URL: %v", err)
+	}
+	cfg.ConnConfig.Database = name
+	pool, err := pgxpool.ConnectConfig(ctx, cfg)
+	if err != nil {
+		t.Fatalf("connect to test database: %v", err)
+	}
+
+	prev := db
+	db = pool
+	t.Cleanup(func() {
+		db = prev
+		pool.Close()
+		if _, err := admin.Exec(ctx, "DROP DATABASE "+name+" WITH (FORCE)"); err != nil {
+			t.Logf("drop test database %s: %v", name, err)
+		}
+		admin.Close(ctx)
+	})
+
+	schema, err := os.ReadFile("db-init/init.sql")
+	if err != nil {
+		t.Fatalf("read schema: %v", err)
+	}
+	if _, err := pool.Exec(ctx, string(schema)); err != nil {
+		t.Fatalf("apply schema: %v", err)
+	}
+}
+
+// mustExec выполняет подготовительный запрос теста.
+func mustExec(t *testing.T, sql string, args ...interface{}) {
+	t.Helper()
+	if _, err := db.Exec(context.Background(), sql, args...); err != nil {
+		t.Fatalf("exec %q: %v", sql, err)
+	}
+}
+
+// mustCreateUser заводит пользователя со стартовым балансом.
+func mustCreateUser(t *testing.T, name string) int {
+	t.Helper()
+	var userID int
+	err := db.BeginFunc(context.Background(), func(tx pgx.Tx) error {
+		var err error
+		userID, err = createUser(context.Background(), tx, name, "")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("create user %s: %v", name, err)
+	}
+	return userID
+}
+
+// mustCreateRoom заводит комнату с владельцем ownerID.
+func mustCreateRoom(t *testing.T, ownerID int, name string) int {
+	t.Helper()
+	var roomID int
+	if err := db.QueryRow(context.Background(), `
+		INSERT INTO room (owner_id, name) VALUES ($1, $2) RETURNING room_id`, ownerID, name).Scan(&roomID); err != nil {
+		t.Fatalf("create room %s: %v", name, err)
+	}
+	return roomID
+}
+
+// mustAddSong добавляет в комнату песню со Spotify-треком trackID.
+func mustAddSong(t *testing.T, roomID, userID int, title, artist, trackID string) int {
+	t.Helper()
+	var songID int
+	if err := db.QueryRow(context.Background(), `
+		INSERT INTO song (room_id, user_id, track_name, artist_name, album_url, provider, provider_track_id)
+		VALUES ($1, $2, $3, $4, '', 'spotify', NULLIF($5, '')) RETURNING song_id`,
+		roomID, userID, title, artist, trackID).Scan(&songID); err != nil {
+		t.Fatalf("add song %s: %v", title, err)
+	}
+	return songID
+}